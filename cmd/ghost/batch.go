@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readTargets reads target URLs, one per line, skipping blank lines,
+// lines starting with #, and repeats.
+func readTargets(r io.Reader) ([]string, error) {
+	var targets []string
+	seen := make(map[string]bool)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		targets = append(targets, line)
+	}
+	return targets, s.Err()
+}
+
+// getTargets returns the URLs to look up: the -u URL, the URLs in the
+// -list file, or the URLs piped to stdin.
+func (g *ghost) getTargets() ([]string, error) {
+	var targets []string
+	switch {
+	case g.config.url != "":
+		targets = []string{g.config.url}
+	case g.config.list != "":
+		f, err := os.Open(g.config.list)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		targets, err = readTargets(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", g.config.list, err)
+		}
+	default:
+		var err error
+		targets, err = readTargets(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read input: %w", err)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("missing input url")
+	}
+	return targets, nil
+}
+
+// targetResult is the outcome of looking up one target.
+type targetResult struct {
+	URL       string  `json:"url"`
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	Dir       string  `json:"dir,omitempty"`
+	Snapshots int     `json:"snapshots"`
+	Seconds   float64 `json:"seconds"`
+}
+
+// runTargets looks up each target, -tc at a time, and returns the
+// results in the order the targets were given.
+func (g *ghost) runTargets(targets []string) []targetResult {
+	limit := g.config.targetLimit
+	if limit < 1 {
+		limit = 1
+	}
+	tokens := make(chan struct{}, limit)
+	results := make([]targetResult, len(targets))
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t string) {
+			defer wg.Done()
+			tokens <- struct{}{}
+			defer func() { <-tokens }()
+			results[i] = g.runTarget(t, targets)
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+// runTarget looks up a single target with its own ghost, so one target
+// failing (or panicking) doesn't affect the others. With more than one
+// target, log lines are prefixed with the target's URL, and passive mode
+// keeps every target off limits, not just the one being looked up.
+func (g *ghost) runTarget(target string, targets []string) (result targetResult) {
+	config := g.config
+	config.url = target
+	tg := newGhost(config)
+	tg.psl = g.psl
+	if len(targets) > 1 {
+		tg.infoLog.SetPrefix(fmt.Sprintf("INFO\t%s\t", target))
+		tg.errorLog.SetPrefix(fmt.Sprintf("ERROR\t%s\t", target))
+	}
+
+	start := time.Now()
+	result = targetResult{URL: target, Status: "ok"}
+	defer func() {
+		if r := recover(); r != nil {
+			result.Status = "failed"
+			result.Error = fmt.Sprintf("panic: %v", r)
+			tg.errorLog.Println(result.Error)
+		}
+		if tg.out != nil {
+			result.Dir = tg.out.dir
+		}
+		result.Seconds = time.Since(start).Seconds()
+		tg.manifestWriter(start, result.Snapshots, result.Error)
+	}()
+
+	err := tg.validateURL(target)
+	if err == nil && config.passive {
+		for _, t := range targets {
+			host, _ := tg.getHost(t)
+			domain, _ := tg.getDomain(t)
+			tg.guard.blockTarget(host, domain)
+		}
+	}
+	if err == nil {
+		result.Snapshots, err = tg.run(start)
+	}
+	if err != nil {
+		tg.errorLog.Println(err)
+		result.Status = "failed"
+		result.Error = err.Error()
+	} else if p := tg.panics.wait(); p != "" {
+		// already logged by goSafe
+		result.Status = "failed"
+		result.Error = p
+	}
+	return result
+}
+
+// panicLog records the panics recovered in a target's goroutines.
+type panicLog struct {
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	panics []string
+}
+
+// wait waits for every goroutine started with goSafe and returns the
+// first panic recorded, if any.
+func (p *panicLog) wait() string {
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.panics) == 0 {
+		return ""
+	}
+	return p.panics[0]
+}
+
+// goSafe runs f in a new goroutine, recovering any panic so that it fails
+// only the target being looked up rather than the whole batch.
+func (g *ghost) goSafe(f func()) {
+	g.panics.wg.Add(1)
+	go func() {
+		defer g.panics.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				msg := fmt.Sprintf("panic: %v", r)
+				g.errorLog.Println(msg)
+				g.panics.mu.Lock()
+				g.panics.panics = append(g.panics.panics, msg)
+				g.panics.mu.Unlock()
+			}
+		}()
+		f()
+	}()
+}
+
+// summaryWriter writes the outcome of every target in a batch to
+// summary-<timestamp>.json in the -o directory, and logs a line for
+// each.
+func (g *ghost) summaryWriter(results []targetResult, start time.Time) {
+	summary := struct {
+		Started   time.Time      `json:"started"`
+		Finished  time.Time      `json:"finished"`
+		Targets   int            `json:"targets"`
+		Succeeded int            `json:"succeeded"`
+		Failed    int            `json:"failed"`
+		Snapshots int            `json:"snapshots"`
+		Results   []targetResult `json:"results"`
+	}{Started: start.UTC(), Finished: time.Now().UTC(), Targets: len(results), Results: results}
+
+	for _, r := range results {
+		if r.Status == "ok" {
+			summary.Succeeded++
+			g.infoLog.Printf("%s: %d snapshot(s) in %s\n", r.URL, r.Snapshots, r.Dir)
+		} else {
+			summary.Failed++
+			g.errorLog.Printf("%s: %s\n", r.URL, r.Error)
+		}
+		summary.Snapshots += r.Snapshots
+	}
+	g.infoLog.Printf("Looked up %d target(s): %d succeeded, %d failed.\n", summary.Targets, summary.Succeeded, summary.Failed)
+
+	b, err := json.Marshal(summary)
+	if err != nil {
+		g.errorLog.Printf("summary marshal error: %v\n", err)
+		return
+	}
+	dir := g.config.output.dir
+	if dir == "" {
+		dir = "."
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		g.errorLog.Printf("unable to make %s: %v\n", dir, err)
+		return
+	}
+	g.writeFileAt(filepath.Join(dir, fmt.Sprintf("summary-%s.json", start.UTC().Format("20060102-150405"))), b)
+}
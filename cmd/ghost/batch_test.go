@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReadTargets(t *testing.T) {
+	in := "https://a.example.com\n\n# a comment\n  https://b.example.com  \nhttps://a.example.com\n"
+	targets, err := readTargets(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0] != "https://a.example.com" || targets[1] != "https://b.example.com" {
+		t.Errorf("readTargets = %q", targets)
+	}
+}
+
+func TestGoSafe(t *testing.T) {
+	g := quietGhost(config{})
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		i := i
+		wg.Add(1)
+		g.goSafe(func() {
+			defer wg.Done()
+			if i == 1 {
+				panic("boom")
+			}
+		})
+	}
+	wg.Wait()
+	if p := g.panics.wait(); p != "panic: boom" {
+		t.Errorf("recorded panic = %q", p)
+	}
+	if p := quietGhost(config{}).panics.wait(); p != "" {
+		t.Errorf("recorded panic without one = %q", p)
+	}
+}
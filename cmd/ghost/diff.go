@@ -0,0 +1,629 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// diffOptions holds the settings specific to the diff subcommand.
+type diffOptions struct {
+	context  int
+	selector string
+	text     bool
+}
+
+// runDiff implements "ghost diff": it retrieves the snapshots for a URL,
+// fetches each consecutive pair of distinct captures, and writes unified
+// diffs along with a side-by-side HTML report.
+func runDiff(args []string) {
+	var config config
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.IntVar(&config.diff.context, "c", 3, "number of context lines in diffs (default is 3).")
+	fs.IntVar(&config.gophers, "g", 10, "number of goroutines (default is 10).")
+	fs.StringVar(&config.diff.selector, "sel", "", "only diff elements matching this selector (tag, #id, .class, tag#id, or tag.class).")
+	fs.BoolVar(&config.diff.text, "text", false, "only diff visible text.")
+	fs.IntVar(&config.timeout, "time", 5000, "timeout in milliseconds (default is 5000).")
+	fs.StringVar(&config.url, "u", "", "url for searching")
+	filterFlags(fs, &config.filters)
+	outputFlags(fs, &config.output)
+	fs.Parse(args)
+
+	start := time.Now()
+
+	g := newGhost(config)
+
+	if config.url != "" {
+		err := g.validateURL(config.url)
+		if err != nil {
+			g.errorLog.Fatal(err)
+		}
+	} else {
+		g.getInputURL()
+	}
+
+	sel, err := parseSelector(config.diff.selector)
+	if err != nil {
+		g.errorLog.Fatal(err)
+	}
+
+	err = g.setOutput(start)
+	if err != nil {
+		g.errorLog.Fatalf("unable to make output directory: %v", err)
+	}
+
+	u := g.formURL(g.config.url, config.filters)
+	g.infoLog.Printf("Wayback Machine URL: %s\n", u)
+
+	body, err := g.getData(u, config.timeout)
+	if err != nil {
+		g.fatal(start, err)
+	}
+	snaps, err := g.getSnaps(body)
+	if err != nil {
+		g.fatal(start, err)
+	}
+
+	captures := g.distinctCaptures(snaps)
+	if len(captures) < 2 {
+		g.infoLog.Println("Need at least two distinct captures to diff. Exiting...")
+		g.manifestWriter(start, len(snaps), "")
+		return
+	}
+
+	g.fetchCaptures(captures, sel, config.diff.text)
+
+	var reports []diffReport
+	for i := 1; i < len(captures); i++ {
+		a, b := captures[i-1], captures[i]
+		if a.err != nil || b.err != nil {
+			continue
+		}
+		ops, exact := diffLines(a.lines, b.lines)
+		hunks := makeHunks(ops, config.diff.context)
+		if len(hunks) == 0 {
+			g.infoLog.Printf("No changes between %s and %s.\n", a.Timestamp, b.Timestamp)
+			continue
+		}
+
+		unified := unifiedDiff(a, b, hunks)
+		if !exact {
+			g.infoLog.Printf("%s and %s differ by more than %d lines, showing the whole page as replaced.\n", a.Timestamp, b.Timestamp, maxEdits)
+			note := fmt.Sprintf("More than %d lines differ, so the whole page is shown as replaced.\n", maxEdits)
+			unified = append([]byte(note), unified...)
+		}
+		g.writeData(fmt.Sprintf("diffs/%s-%s.diff", a.Timestamp, b.Timestamp), unified)
+
+		reports = append(reports, diffReport{
+			From:     a,
+			To:       b,
+			Hunks:    sideBySide(hunks),
+			Replaced: !exact,
+		})
+	}
+
+	g.writeDiffReport(reports)
+
+	g.infoLog.Printf("Took: %f seconds\n", time.Since(start).Seconds())
+	g.manifestWriter(start, len(snaps), "")
+}
+
+// capture is a single snapshot fetched for diffing.
+type capture struct {
+	Timestamp string
+	URL       string
+	original  string
+	lines     []string
+	err       error
+}
+
+// distinctCaptures turns the snapshots returned by getSnaps into a list
+// of captures, skipping any capture whose digest matches the previous one.
+func (g *ghost) distinctCaptures(snaps [][]string) []*capture {
+	var captures []*capture
+	var last string
+	for _, v := range snaps {
+		// urlkey, timestamp, original, mimetype, statuscode, digest, length
+		if len(v) < 6 {
+			continue
+		}
+		if v[5] == last {
+			g.infoLog.Printf("Skipping %s, identical to previous capture.\n", v[1])
+			continue
+		}
+		last = v[5]
+		captures = append(captures, &capture{
+			Timestamp: v[1],
+			URL:       fmt.Sprintf("https://web.archive.org/web/%s/%s", v[1], v[2]),
+			original:  v[2],
+		})
+	}
+	return captures
+}
+
+// fetchCaptures concurrently downloads the original (id_) content of
+// each capture and normalizes it into lines.
+func (g *ghost) fetchCaptures(captures []*capture, sel *selector, text bool) {
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, g.config.gophers)
+	for _, c := range captures {
+		tokens <- struct{}{}
+		wg.Add(1)
+		go func(c *capture) {
+			defer wg.Done()
+			defer func() { <-tokens }()
+			u := fmt.Sprintf("https://web.archive.org/web/%sid_/%s", c.Timestamp, c.original)
+			page, err := g.getData(u, g.config.timeout)
+			if err != nil {
+				g.errorLog.Printf("getData error for %s: %v\n", u, err)
+				c.err = err
+				return
+			}
+			c.lines, c.err = normalizePage(page, sel, text)
+			if c.err != nil {
+				g.errorLog.Printf("unable to normalize %s: %v\n", u, c.err)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// selector is a minimal CSS selector: a tag name, an id, a class, or a
+// tag combined with an id or class.
+type selector struct {
+	tag, id, class string
+}
+
+// parseSelector parses s into a selector. An empty string returns nil.
+func parseSelector(s string) (*selector, error) {
+	if s == "" {
+		return nil, nil
+	}
+	sel := &selector{}
+	switch {
+	case strings.Contains(s, "#"):
+		sel.tag, sel.id, _ = strings.Cut(s, "#")
+	case strings.Contains(s, "."):
+		sel.tag, sel.class, _ = strings.Cut(s, ".")
+	default:
+		sel.tag = s
+	}
+	sel.tag = strings.ToLower(sel.tag)
+	if strings.ContainsAny(sel.id+sel.class+sel.tag, "#. >+~[]:") || (sel.tag == "" && sel.id == "" && sel.class == "") {
+		return nil, fmt.Errorf("unsupported selector %q", s)
+	}
+	return sel, nil
+}
+
+// matches reports whether n is an element matching the selector.
+func (s *selector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if s.tag != "" && n.Data != s.tag {
+		return false
+	}
+	if s.id != "" && attr(n, "id") != s.id {
+		return false
+	}
+	if s.class != "" {
+		for _, c := range strings.Fields(attr(n, "class")) {
+			if c == s.class {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// attr returns the value of the named attribute of n, if any.
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// normalizePage parses page and returns it as lines suitable for
+// diffing. Markup is rendered one tag or text run per line so that
+// minified pages still produce readable diffs. If sel is not nil, only
+// matching elements are kept; if text is true, only visible text is kept.
+func normalizePage(page []byte, sel *selector, text bool) ([]string, error) {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	roots := []*html.Node{doc}
+	if sel != nil {
+		roots = nil
+		var find func(*html.Node)
+		find = func(n *html.Node) {
+			if sel.matches(n) {
+				roots = append(roots, n)
+				return
+			}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				find(c)
+			}
+		}
+		find(doc)
+	}
+
+	var lines []string
+	for _, root := range roots {
+		if text {
+			lines = append(lines, visibleText(root)...)
+		} else {
+			lines = append(lines, markupLines(root)...)
+		}
+	}
+	return lines, nil
+}
+
+// invisible holds the elements whose contents are never displayed.
+var invisible = map[string]bool{
+	"head":     true,
+	"noscript": true,
+	"script":   true,
+	"style":    true,
+	"template": true,
+}
+
+// visibleText returns the non-empty text runs under n, one per line,
+// with whitespace collapsed.
+func visibleText(n *html.Node) []string {
+	var lines []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && invisible[n.Data] {
+			return
+		}
+		if n.Type == html.TextNode {
+			if t := strings.Join(strings.Fields(n.Data), " "); t != "" {
+				lines = append(lines, t)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return lines
+}
+
+// markupLines renders n as one start tag, end tag, or text run per line.
+func markupLines(n *html.Node) []string {
+	var lines []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.ElementNode:
+			tok := html.Token{Type: html.StartTagToken, Data: n.Data, Attr: n.Attr}
+			lines = append(lines, tok.String())
+		case html.TextNode:
+			if t := strings.Join(strings.Fields(n.Data), " "); t != "" {
+				lines = append(lines, t)
+			}
+		case html.CommentNode:
+			lines = append(lines, "<!--"+n.Data+"-->")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && n.FirstChild != nil {
+			lines = append(lines, "</"+n.Data+">")
+		}
+	}
+	walk(n)
+	return lines
+}
+
+// diffOp is a single line of an edit script: ' ' for a line common to
+// both sides, '-' for a deletion, and '+' for an insertion.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// maxEdits bounds the work done by myers. Pages differing by more than
+// this many lines are treated as entirely replaced.
+const maxEdits = 1000
+
+// diffLines returns an edit script that turns a into b. If the lines
+// between a common prefix and suffix differ by more than maxEdits, they
+// are all deleted and inserted and exact is false.
+func diffLines(a, b []string) (ops []diffOp, exact bool) {
+	var prefix, suffix []diffOp
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffOp{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	middle, ok := myers(a, b, maxEdits)
+	if !ok {
+		middle = middle[:0]
+		for _, line := range a {
+			middle = append(middle, diffOp{'-', line})
+		}
+		for _, line := range b {
+			middle = append(middle, diffOp{'+', line})
+		}
+	}
+
+	ops = append(prefix, middle...)
+	return append(ops, suffix...), ok
+}
+
+// myers implements Myers' O(ND) diff algorithm. It returns false if the
+// edit distance between a and b exceeds max.
+func myers(a, b []string, max int) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset]
+			} else {
+				x = v[k-1+offset] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[k+offset] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// backtrack walks the trace recorded by myers to recover the edit script.
+func backtrack(a, b []string, trace [][]int, offset int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[prevK+offset]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// hunk is a group of nearby changes along with their surrounding context.
+type hunk struct {
+	aStart, aLen int
+	bStart, bLen int
+	ops          []diffOp
+}
+
+// makeHunks groups the changes in ops into hunks with the given number
+// of context lines. It returns nil if there are no changes.
+func makeHunks(ops []diffOp, context int) []hunk {
+	// line numbers (0-based) on each side before each op
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.kind != '+' {
+			aLine[i+1]++
+		}
+		if op.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	var hunks []hunk
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		last := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				last = j
+			} else if j-last > 2*context {
+				break
+			}
+		}
+		end := last + context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		hunks = append(hunks, hunk{
+			aStart: aLine[start] + 1,
+			aLen:   aLine[end] - aLine[start],
+			bStart: bLine[start] + 1,
+			bLen:   bLine[end] - bLine[start],
+			ops:    ops[start:end],
+		})
+		i = end
+	}
+	return hunks
+}
+
+// unifiedDiff renders hunks between two captures in unified diff format.
+func unifiedDiff(a, b *capture, hunks []hunk) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", a.URL, b.URL)
+	for _, h := range hunks {
+		aStart, bStart := h.aStart, h.bStart
+		// an empty range starts at the line before it, per diff(1)
+		if h.aLen == 0 {
+			aStart--
+		}
+		if h.bLen == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&buf, "@@ -%d,%d +%d,%d @@\n", aStart, h.aLen, bStart, h.bLen)
+		for _, op := range h.ops {
+			fmt.Fprintf(&buf, "%c%s\n", op.kind, op.line)
+		}
+	}
+	return buf.Bytes()
+}
+
+// diffRow is one row of a side-by-side diff. Kind is "equal", "delete",
+// "insert", or "change"; a zero line number means that side is blank.
+type diffRow struct {
+	Kind            string
+	LeftNo, RightNo int
+	Left, Right     string
+}
+
+// diffReport holds the side-by-side diff between two captures. Replaced
+// is set when they differed too much to compare line by line.
+type diffReport struct {
+	From, To *capture
+	Hunks    [][]diffRow
+	Replaced bool
+}
+
+// sideBySide converts hunks into rows, pairing runs of deletions with
+// the insertions that immediately follow them.
+func sideBySide(hunks []hunk) [][]diffRow {
+	var out [][]diffRow
+	for _, h := range hunks {
+		var rows []diffRow
+		aNo, bNo := h.aStart, h.bStart
+		ops := h.ops
+		for len(ops) > 0 {
+			if ops[0].kind == ' ' {
+				rows = append(rows, diffRow{Kind: "equal", LeftNo: aNo, RightNo: bNo, Left: ops[0].line, Right: ops[0].line})
+				aNo++
+				bNo++
+				ops = ops[1:]
+				continue
+			}
+
+			var dels, ins []string
+			for len(ops) > 0 && ops[0].kind == '-' {
+				dels = append(dels, ops[0].line)
+				ops = ops[1:]
+			}
+			for len(ops) > 0 && ops[0].kind == '+' {
+				ins = append(ins, ops[0].line)
+				ops = ops[1:]
+			}
+			for i := 0; i < len(dels) || i < len(ins); i++ {
+				var row diffRow
+				switch {
+				case i < len(dels) && i < len(ins):
+					row = diffRow{Kind: "change", LeftNo: aNo, RightNo: bNo, Left: dels[i], Right: ins[i]}
+					aNo++
+					bNo++
+				case i < len(dels):
+					row = diffRow{Kind: "delete", LeftNo: aNo, Left: dels[i]}
+					aNo++
+				default:
+					row = diffRow{Kind: "insert", RightNo: bNo, Right: ins[i]}
+					bNo++
+				}
+				rows = append(rows, row)
+			}
+		}
+		out = append(out, rows)
+	}
+	return out
+}
+
+// diffTemplate is the side-by-side HTML report.
+var diffTemplate = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ghost diff: {{.URL}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; table-layout: fixed; margin-bottom: 2em; }
+td { font-family: monospace; font-size: 12px; white-space: pre-wrap; word-break: break-all; vertical-align: top; padding: 1px 4px; }
+td.no { width: 4em; color: #888; text-align: right; }
+tr.hunk td { background: #eef; color: #555; }
+.delete td.left, .change td.left { background: #fdd; }
+.insert td.right, .change td.right { background: #dfd; }
+</style>
+</head>
+<body>
+<h1>{{.URL}}</h1>
+<p>{{len .Reports}} changed pair(s) of captures.</p>
+{{range .Reports}}
+<h2><a href="{{.From.URL}}">{{.From.Timestamp}}</a> &rarr; <a href="{{.To.URL}}">{{.To.Timestamp}}</a></h2>
+{{if .Replaced}}<p>More than {{$.MaxEdits}} lines differ, so the whole page is shown as replaced.</p>
+{{end}}<table>
+{{range .Hunks}}<tr class="hunk"><td class="no"></td><td>&hellip;</td><td class="no"></td><td>&hellip;</td></tr>
+{{range .}}<tr class="{{.Kind}}"><td class="no">{{if .LeftNo}}{{.LeftNo}}{{end}}</td><td class="left">{{.Left}}</td><td class="no">{{if .RightNo}}{{.RightNo}}{{end}}</td><td class="right">{{.Right}}</td></tr>
+{{end}}{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// writeDiffReport renders the side-by-side HTML report and writes it to
+// a file.
+func (g *ghost) writeDiffReport(reports []diffReport) {
+	var buf bytes.Buffer
+	err := diffTemplate.Execute(&buf, struct {
+		URL      string
+		Reports  []diffReport
+		MaxEdits int
+	}{g.config.url, reports, maxEdits})
+	if err != nil {
+		g.errorLog.Printf("diff report error: %v\n", err)
+		return
+	}
+	g.writeData("diff.html", buf.Bytes())
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// numbered returns the lines "1" to "n".
+func numbered(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprint(i + 1)
+	}
+	return lines
+}
+
+// applyOps returns the two sides an edit script was made from, and the
+// number of lines it changes.
+func applyOps(ops []diffOp) (a, b []string, edits int) {
+	for _, op := range ops {
+		if op.kind != '+' {
+			a = append(a, op.line)
+		}
+		if op.kind != '-' {
+			b = append(b, op.line)
+		}
+		if op.kind != ' ' {
+			edits++
+		}
+	}
+	return a, b, edits
+}
+
+func TestMyers(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		edits int
+	}{
+		{"", "", 0},
+		{"", "a b c", 3},
+		{"a b c", "", 3},
+		{"a b c", "a b c", 0},
+		{"a b c a b b a", "c b a b a c", 5},
+		{"a b c", "a x c", 2},
+		{"a b c d", "b c d e", 2},
+	}
+	for _, tt := range tests {
+		a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+		ops, ok := myers(a, b, maxEdits)
+		if !ok {
+			t.Errorf("myers(%q, %q) gave up", tt.a, tt.b)
+			continue
+		}
+		gotA, gotB, edits := applyOps(ops)
+		if strings.Join(gotA, " ") != tt.a || strings.Join(gotB, " ") != tt.b {
+			t.Errorf("myers(%q, %q) script gives %q and %q", tt.a, tt.b, gotA, gotB)
+		}
+		if edits != tt.edits {
+			t.Errorf("myers(%q, %q) makes %d edits, want %d", tt.a, tt.b, edits, tt.edits)
+		}
+	}
+
+	if _, ok := myers(strings.Fields("a b c"), strings.Fields("x y z"), 5); ok {
+		t.Error("myers didn't give up past max edits")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	a, b := numbered(5), numbered(5)
+	b[2] = "x"
+	ops, exact := diffLines(a, b)
+	want := []diffOp{{' ', "1"}, {' ', "2"}, {'-', "3"}, {'+', "x"}, {' ', "4"}, {' ', "5"}}
+	if !exact || !reflect.DeepEqual(ops, want) {
+		t.Errorf("diffLines = %v, %t; want %v", ops, exact, want)
+	}
+
+	if ops, exact := diffLines(nil, nil); len(ops) != 0 || !exact {
+		t.Errorf("diffLines(nil, nil) = %v, %t", ops, exact)
+	}
+
+	// pages that differ by more than maxEdits lines are replaced whole,
+	// keeping the common prefix and suffix
+	a, b = []string{"head"}, []string{"head"}
+	for i := 0; i < maxEdits; i++ {
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+	a, b = append(a, "tail"), append(b, "tail")
+	ops, exact = diffLines(a, b)
+	if exact {
+		t.Error("diffLines didn't report falling back to a full replace")
+	}
+	gotA, gotB, edits := applyOps(ops)
+	if !reflect.DeepEqual(gotA, a) || !reflect.DeepEqual(gotB, b) || edits != 2*maxEdits {
+		t.Errorf("full replace makes %d edits and doesn't give back both pages", edits)
+	}
+	if ops[0] != (diffOp{' ', "head"}) || ops[1].kind != '-' || ops[maxEdits+1].kind != '+' || ops[len(ops)-1] != (diffOp{' ', "tail"}) {
+		t.Errorf("full replace is laid out as %v ... %v", ops[:2], ops[len(ops)-2:])
+	}
+}
+
+func TestMakeHunks(t *testing.T) {
+	type span struct{ aStart, aLen, bStart, bLen int }
+	change := func(n int, lines ...int) []string {
+		b := numbered(n)
+		for _, l := range lines {
+			b[l-1] = "x"
+		}
+		return b
+	}
+	tests := []struct {
+		name    string
+		a, b    []string
+		context int
+		want    []span
+	}{
+		{"empty", nil, nil, 3, nil},
+		{"identical", numbered(20), numbered(20), 3, nil},
+		{"nearby changes merge", numbered(20), change(20, 3, 8), 3, []span{{1, 11, 1, 11}}},
+		{"distant changes split", numbered(20), change(20, 3, 15), 3, []span{{1, 6, 1, 6}, {12, 7, 12, 7}}},
+		{"no context", numbered(20), change(20, 3, 8), 0, []span{{3, 1, 3, 1}, {8, 1, 8, 1}}},
+		{"insertion at the end", numbered(2), numbered(3), 1, []span{{2, 1, 2, 2}}},
+		{"everything deleted", numbered(2), nil, 3, []span{{1, 2, 1, 0}}},
+	}
+	for _, tt := range tests {
+		ops, _ := diffLines(tt.a, tt.b)
+		hunks := makeHunks(ops, tt.context)
+		var got []span
+		for _, h := range hunks {
+			got = append(got, span{h.aStart, h.aLen, h.bStart, h.bLen})
+			a, b, _ := applyOps(h.ops)
+			if len(a) != h.aLen || len(b) != h.bLen {
+				t.Errorf("%s: hunk %+v holds %d and %d lines", tt.name, got[len(got)-1], len(a), len(b))
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: hunks = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := &capture{URL: "https://web.archive.org/web/1id_/x"}
+	b := &capture{URL: "https://web.archive.org/web/2id_/x"}
+	ops, _ := diffLines([]string{"one", "two"}, nil)
+	got := string(unifiedDiff(a, b, makeHunks(ops, 3)))
+	want := "--- https://web.archive.org/web/1id_/x\n+++ https://web.archive.org/web/2id_/x\n@@ -1,2 +0,0 @@\n-one\n-two\n"
+	if got != want {
+		t.Errorf("unifiedDiff = %q, want %q", got, want)
+	}
+}
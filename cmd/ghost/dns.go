@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// typeCAA is the CAA record type, which dnsmessage doesn't define.
+	typeCAA = dnsmessage.Type(257)
+	// maxCNAMEHops limits how far a CNAME chain is followed.
+	maxCNAMEHops = 8
+	// maxDNSMessage is the largest DNS message accepted over UDP.
+	maxDNSMessage = 4096
+)
+
+// dnsResolver is where DNS queries are sent: plain DNS over UDP or TCP,
+// DNS-over-TLS, or DNS-over-HTTPS.
+type dnsResolver struct {
+	network string
+	address string
+	url     string
+	// roots verifies DNS-over-TLS servers; nil means the system's roots
+	roots *x509.CertPool
+}
+
+// String returns the resolver in the form accepted by -resolver.
+func (r dnsResolver) String() string {
+	if r.network == "https" {
+		return r.url
+	}
+	return r.network + "://" + r.address
+}
+
+// parseResolver parses a -resolver value: udp://host[:port],
+// tcp://host[:port], tls://host[:port] (DNS-over-TLS), an https:// URL
+// (DNS-over-HTTPS), or a bare host[:port] for UDP. If s is empty, the
+// first name server in /etc/resolv.conf is used.
+func parseResolver(s string) (dnsResolver, error) {
+	if s == "" {
+		return dnsResolver{network: "udp", address: systemNameServer()}, nil
+	}
+	if strings.HasPrefix(s, "https://") {
+		return dnsResolver{network: "https", url: s}, nil
+	}
+
+	network := "udp"
+	if scheme, rest, found := strings.Cut(s, "://"); found {
+		network, s = strings.ToLower(scheme), rest
+	}
+	port := "53"
+	switch network {
+	case "udp", "tcp":
+	case "tls", "dot":
+		network, port = "tls", "853"
+	default:
+		return dnsResolver{}, fmt.Errorf("unsupported resolver scheme %q", network)
+	}
+	s = strings.TrimSuffix(s, "/")
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		s = net.JoinHostPort(strings.Trim(s, "[]"), port)
+	}
+	return dnsResolver{network: network, address: s}, nil
+}
+
+// systemNameServer returns the first name server in /etc/resolv.conf,
+// or the local host if there isn't one.
+func systemNameServer() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err == nil {
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			fields := strings.Fields(s.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// dnsRecord is a single resource record from an answer.
+type dnsRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	TTL   uint32 `json:"ttl"`
+	Value string `json:"value"`
+}
+
+// dnsVerification is a TXT record left behind to prove domain ownership
+// to a service.
+type dnsVerification struct {
+	Service string `json:"service"`
+	Value   string `json:"value"`
+}
+
+// dnsReport is the result written to dns.json.
+type dnsReport struct {
+	Resolver     string            `json:"resolver"`
+	Records      []dnsRecord       `json:"records"`
+	CNAMEChain   []string          `json:"cname_chain,omitempty"`
+	SPF          []string          `json:"spf,omitempty"`
+	DMARC        []string          `json:"dmarc,omitempty"`
+	Verification []dnsVerification `json:"verification,omitempty"`
+	Errors       []string          `json:"errors,omitempty"`
+}
+
+// dnsQuery sends a single question to the resolver and returns the
+// answer records.
+func (g *ghost) dnsQuery(r dnsResolver, name string, qtype dnsmessage.Type, timeout int) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	rand.Read(id[:])
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true})
+	b.EnableCompression()
+	err = b.StartQuestions()
+	if err == nil {
+		err = b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET})
+	}
+	if err == nil {
+		err = b.StartAdditionals()
+	}
+	if err == nil {
+		var opt dnsmessage.ResourceHeader
+		err = opt.SetEDNS0(maxDNSMessage, dnsmessage.RCodeSuccess, false)
+		if err == nil {
+			err = b.OPTResource(opt, dnsmessage.OPTResource{})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+	// DNS-over-HTTPS requests are recorded by the client
+	var rec *requestRecord
+	if r.network != "https" {
+		rec = g.requests.start("DNS", fmt.Sprintf("%s?name=%s&type=%s", r, name, strings.TrimPrefix(qtype.String(), "Type")))
+	}
+	resp, err := g.dnsExchange(ctx, r, query)
+	if rec != nil {
+		g.requests.finish(rec, 0, resp, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg dnsmessage.Message
+	err = msg.Unpack(resp)
+	if err != nil {
+		return nil, fmt.Errorf("unpack error: %w", err)
+	}
+	if msg.ID != binary.BigEndian.Uint16(id[:]) {
+		return nil, errors.New("response ID does not match query")
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+		// a missing name just has no answers
+	default:
+		return nil, fmt.Errorf("%s", msg.RCode)
+	}
+	return msg.Answers, nil
+}
+
+// dnsExchange sends a packed query to the resolver and returns the
+// packed response. All connections go through the guard.
+func (g *ghost) dnsExchange(ctx context.Context, r dnsResolver, query []byte) ([]byte, error) {
+	if r.network == "https" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(query))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+		resp, err := g.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	}
+
+	network := r.network
+	if network == "tls" {
+		network = "tcp"
+	}
+	conn, err := g.guard.dialContext(ctx, network, r.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if r.network == "udp" {
+		_, err = conn.Write(query)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, maxDNSMessage)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// a truncated answer is asked for again over TCP
+		var h dnsmessage.Parser
+		if hdr, err := h.Start(buf[:n]); err == nil && hdr.Truncated {
+			return g.dnsExchange(ctx, dnsResolver{network: "tcp", address: r.address}, query)
+		}
+		return buf[:n], nil
+	}
+
+	if r.network == "tls" {
+		host, _, _ := net.SplitHostPort(r.address)
+		tc := tls.Client(conn, &tls.Config{ServerName: host, RootCAs: r.roots})
+		err = tc.HandshakeContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		conn = tc
+	}
+
+	// stream transports prefix each message with its length
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	_, err = conn.Write(msg)
+	if err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	_, err = io.ReadFull(conn, length[:])
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(conn, resp)
+	return resp, err
+}
+
+// dnsRecordFrom turns an answer into a record, with its value in zone
+// file format.
+func dnsRecordFrom(res dnsmessage.Resource) (dnsRecord, bool) {
+	rec := dnsRecord{
+		Name: strings.TrimSuffix(res.Header.Name.String(), "."),
+		TTL:  res.Header.TTL,
+		Type: strings.TrimPrefix(res.Header.Type.String(), "Type"),
+	}
+	switch b := res.Body.(type) {
+	case *dnsmessage.AResource:
+		rec.Value = net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		rec.Value = net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		rec.Value = strings.TrimSuffix(b.CNAME.String(), ".")
+	case *dnsmessage.MXResource:
+		rec.Value = fmt.Sprintf("%d %s", b.Pref, strings.TrimSuffix(b.MX.String(), "."))
+	case *dnsmessage.NSResource:
+		rec.Value = strings.TrimSuffix(b.NS.String(), ".")
+	case *dnsmessage.PTRResource:
+		rec.Value = strings.TrimSuffix(b.PTR.String(), ".")
+	case *dnsmessage.TXTResource:
+		rec.Value = strings.Join(b.TXT, "")
+	case *dnsmessage.SOAResource:
+		rec.Value = fmt.Sprintf("%s %s %d %d %d %d %d",
+			strings.TrimSuffix(b.NS.String(), "."), strings.TrimSuffix(b.MBox.String(), "."),
+			b.Serial, b.Refresh, b.Retry, b.Expire, b.MinTTL)
+	case *dnsmessage.UnknownResource:
+		if b.Type != typeCAA {
+			return rec, false
+		}
+		rec.Type = "CAA"
+		// flags, tag length, tag, value
+		if len(b.Data) < 2 || len(b.Data) < 2+int(b.Data[1]) {
+			return rec, false
+		}
+		tagEnd := 2 + int(b.Data[1])
+		rec.Value = fmt.Sprintf("%d %s %q", b.Data[0], b.Data[2:tagEnd], b.Data[tagEnd:])
+	default:
+		return rec, false
+	}
+	return rec, true
+}
+
+// verificationService returns the service a TXT record proves ownership
+// to, if it looks like a verification token.
+func verificationService(txt string) (string, bool) {
+	key, _, found := strings.Cut(txt, "=")
+	if !found {
+		return "", false
+	}
+	lower := strings.ToLower(key)
+	switch {
+	case strings.Contains(lower, "verification"), strings.Contains(lower, "verify"), strings.HasSuffix(lower, "-challenge"):
+		return key, true
+	case key == "MS", key == "docusign", key == "ZOOM_verify", key == "h1-domain-verification":
+		return key, true
+	}
+	return "", false
+}
+
+// dnsLookup enumerates the A, AAAA, and CNAME records of host and the NS,
+// SOA, MX, TXT, and CAA records of domain (plus its _dmarc TXT record)
+// through the -resolver, following CNAME chains and picking out SPF,
+// DMARC, and verification tokens. The records, with their TTLs, are
+// written to dns.json.
+func (g *ghost) dnsLookup(wg *sync.WaitGroup, host, domain string, timeout int) {
+	defer wg.Done()
+
+	r, err := parseResolver(g.config.resolver)
+	if err != nil {
+		g.errorLog.Printf("invalid resolver: %v\n", err)
+		return
+	}
+	report := dnsReport{Resolver: r.String()}
+	g.infoLog.Printf("Querying DNS through %s.\n", report.Resolver)
+
+	seen := make(map[dnsRecord]bool)
+	query := func(name string, qtype dnsmessage.Type) []dnsRecord {
+		answers, err := g.dnsQuery(r, name, qtype, timeout)
+		if err != nil {
+			msg := fmt.Sprintf("%s %s: %v", name, strings.TrimPrefix(qtype.String(), "Type"), err)
+			g.errorLog.Println(msg)
+			report.Errors = append(report.Errors, msg)
+			return nil
+		}
+		var recs []dnsRecord
+		for _, a := range answers {
+			rec, ok := dnsRecordFrom(a)
+			if !ok {
+				continue
+			}
+			recs = append(recs, rec)
+			if !seen[rec] {
+				seen[rec] = true
+				report.Records = append(report.Records, rec)
+			}
+		}
+		return recs
+	}
+
+	if host = strings.ToLower(hostWithoutPort(host)); host != "" && net.ParseIP(host) == nil {
+		chain, err := cnameChain(host, func(name string) []dnsRecord { return query(name, dnsmessage.TypeA) })
+		if err != nil {
+			g.errorLog.Println(err)
+			report.Errors = append(report.Errors, err.Error())
+		}
+		if len(chain) > 1 {
+			report.CNAMEChain = chain
+		}
+		query(host, dnsmessage.TypeAAAA)
+		query(host, dnsmessage.TypeCNAME)
+	}
+
+	if domain != "" {
+		if domain != host {
+			query(domain, dnsmessage.TypeA)
+			query(domain, dnsmessage.TypeAAAA)
+		}
+		query(domain, dnsmessage.TypeNS)
+		query(domain, dnsmessage.TypeSOA)
+		query(domain, dnsmessage.TypeMX)
+		query(domain, typeCAA)
+		for _, rec := range query(domain, dnsmessage.TypeTXT) {
+			if rec.Type != "TXT" {
+				continue
+			}
+			if strings.HasPrefix(strings.ToLower(rec.Value), "v=spf1") {
+				report.SPF = append(report.SPF, rec.Value)
+			} else if service, ok := verificationService(rec.Value); ok {
+				report.Verification = append(report.Verification, dnsVerification{Service: service, Value: rec.Value})
+			}
+		}
+		for _, rec := range query("_dmarc."+domain, dnsmessage.TypeTXT) {
+			if rec.Type == "TXT" && strings.HasPrefix(strings.ToUpper(rec.Value), "V=DMARC1") {
+				report.DMARC = append(report.DMARC, rec.Value)
+			}
+		}
+	}
+
+	g.infoLog.Printf("Found %d DNS record(s).\n", len(report.Records))
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		g.errorLog.Printf("dnsLookup marshal error: %v\n", err)
+		return
+	}
+	g.writeData("dns.json", b)
+	g.storeTable(dnsTable(report.Records))
+}
+
+// cnameChain follows the CNAME chain from host, using lookup to get the
+// A records of a name. The resolver usually answers with the whole chain
+// at once, so lookup is only called again for a name the last answer
+// didn't cover. The chain ends at a name without a CNAME, and is cut
+// short at a loop or after maxCNAMEHops.
+func cnameChain(host string, lookup func(name string) []dnsRecord) ([]string, error) {
+	chain := []string{host}
+	recs := lookup(host)
+	for {
+		name := chain[len(chain)-1]
+		target, answered := cnameTarget(recs, name)
+		if !answered && len(chain) > 1 {
+			recs = lookup(name)
+			target, _ = cnameTarget(recs, name)
+		}
+		if target == "" {
+			return chain, nil
+		}
+		for _, c := range chain {
+			if strings.EqualFold(c, target) {
+				return chain, fmt.Errorf("%s CNAME: loop back to %s", name, target)
+			}
+		}
+		if len(chain) > maxCNAMEHops {
+			return chain, fmt.Errorf("%s CNAME: chain longer than %d", host, maxCNAMEHops)
+		}
+		chain = append(chain, target)
+	}
+}
+
+// cnameTarget returns the target of name's CNAME record in recs, if any,
+// and whether recs have any record for name at all.
+func cnameTarget(recs []dnsRecord, name string) (string, bool) {
+	answered := false
+	for _, rec := range recs {
+		if !strings.EqualFold(rec.Name, name) {
+			continue
+		}
+		answered = true
+		if rec.Type == "CNAME" {
+			return rec.Value, true
+		}
+	}
+	return "", answered
+}
+
+// hostWithoutPort strips any port from host.
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
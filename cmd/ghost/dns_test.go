@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestCNAMEChain(t *testing.T) {
+	a := func(name string) dnsRecord { return dnsRecord{Name: name, Type: "A", Value: "192.0.2.1"} }
+	cname := func(name, target string) dnsRecord { return dnsRecord{Name: name, Type: "CNAME", Value: target} }
+
+	// www.example.com -> h1.example.com -> h2.example.com -> ...
+	long := make(map[string][]dnsRecord)
+	longChain := []string{"www.example.com"}
+	for i := 1; i < 20; i++ {
+		name := fmt.Sprintf("h%d.example.com", i)
+		prev := longChain[len(longChain)-1]
+		long[prev] = []dnsRecord{cname(prev, name)}
+		longChain = append(longChain, name)
+	}
+
+	tests := []struct {
+		name    string
+		answers map[string][]dnsRecord
+		want    []string
+		lookups int
+		err     string
+	}{
+		{
+			name:    "no CNAME",
+			answers: map[string][]dnsRecord{"www.example.com": {a("www.example.com")}},
+			want:    []string{"www.example.com"},
+			lookups: 1,
+		},
+		{
+			name:    "no answer",
+			answers: map[string][]dnsRecord{},
+			want:    []string{"www.example.com"},
+			lookups: 1,
+		},
+		{
+			name: "whole chain in one answer",
+			answers: map[string][]dnsRecord{"www.example.com": {
+				cname("www.example.com", "a.cdn.net"),
+				cname("A.cdn.net", "b.cdn.net"),
+				a("b.cdn.net"),
+			}},
+			want:    []string{"www.example.com", "a.cdn.net", "b.cdn.net"},
+			lookups: 1,
+		},
+		{
+			name: "chain across answers",
+			answers: map[string][]dnsRecord{
+				"www.example.com": {cname("www.example.com", "a.cdn.net")},
+				"a.cdn.net":       {cname("a.cdn.net", "b.cdn.net"), a("b.cdn.net")},
+			},
+			want:    []string{"www.example.com", "a.cdn.net", "b.cdn.net"},
+			lookups: 2,
+		},
+		{
+			name:    "dangling",
+			answers: map[string][]dnsRecord{"www.example.com": {cname("www.example.com", "gone.example.net")}},
+			want:    []string{"www.example.com", "gone.example.net"},
+			lookups: 2,
+		},
+		{
+			name: "loop",
+			answers: map[string][]dnsRecord{
+				"www.example.com": {cname("www.example.com", "a.example.com")},
+				"a.example.com":   {cname("a.example.com", "b.example.com")},
+				"b.example.com":   {cname("b.example.com", "WWW.example.com")},
+			},
+			want:    []string{"www.example.com", "a.example.com", "b.example.com"},
+			lookups: 3,
+			err:     "loop back to WWW.example.com",
+		},
+		{
+			name:    "self loop",
+			answers: map[string][]dnsRecord{"www.example.com": {cname("www.example.com", "www.example.com")}},
+			want:    []string{"www.example.com"},
+			lookups: 1,
+			err:     "loop",
+		},
+		{
+			name:    "too long",
+			answers: long,
+			want:    longChain[:maxCNAMEHops+1],
+			lookups: maxCNAMEHops + 1,
+			err:     fmt.Sprintf("chain longer than %d", maxCNAMEHops),
+		},
+	}
+	for _, tt := range tests {
+		lookups := 0
+		chain, err := cnameChain("www.example.com", func(name string) []dnsRecord {
+			lookups++
+			return tt.answers[name]
+		})
+		if !reflect.DeepEqual(chain, tt.want) {
+			t.Errorf("%s: chain = %q, want %q", tt.name, chain, tt.want)
+		}
+		if lookups != tt.lookups {
+			t.Errorf("%s: %d lookups, want %d", tt.name, lookups, tt.lookups)
+		}
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// dnsAnswer returns an A record for name.
+func dnsAnswer(name string, ip [4]byte) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+		Body:   &dnsmessage.AResource{A: ip},
+	}
+}
+
+// dnsReply packs a response to query. A nil answers slice with truncated
+// set gives the empty, truncated reply a server sends over UDP when the
+// answer doesn't fit.
+func dnsReply(t *testing.T, query []byte, truncated bool, answers ...dnsmessage.Resource) []byte {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil {
+		t.Errorf("server got a bad query: %v", err)
+		return nil
+	}
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, Truncated: truncated},
+		Questions: q.Questions,
+		Answers:   answers,
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	return b
+}
+
+// listenDNS returns a UDP and a TCP listener on the same local port.
+func listenDNS(t *testing.T) (net.PacketConn, net.Listener) {
+	for i := 0; i < 10; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc, err := net.ListenPacket("udp", l.Addr().String())
+		if err == nil {
+			t.Cleanup(func() { pc.Close(); l.Close() })
+			return pc, l
+		}
+		l.Close()
+	}
+	t.Fatal("no local port free for both UDP and TCP")
+	return nil, nil
+}
+
+// serveUDP answers each datagram on pc with reply.
+func serveUDP(pc net.PacketConn, reply func(query []byte) []byte) {
+	go func() {
+		buf := make([]byte, maxDNSMessage)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(reply(buf[:n]), addr)
+		}
+	}()
+}
+
+// serveStream answers length-prefixed queries on l with reply.
+func serveStream(l net.Listener, reply func(query []byte) []byte) {
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := reply(query)
+				binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+				conn.Write(append(length[:], resp...))
+			}()
+		}
+	}()
+}
+
+func TestDNSQuery(t *testing.T) {
+	g := quietGhost(config{})
+	udpAnswer := dnsAnswer("example.com.", [4]byte{192, 0, 2, 1})
+	tcpAnswer := dnsAnswer("example.com.", [4]byte{192, 0, 2, 2})
+	want := func(res []dnsmessage.Resource, ip string) error {
+		if len(res) != 1 {
+			return fmt.Errorf("%d answers", len(res))
+		}
+		if rec, ok := dnsRecordFrom(res[0]); !ok || rec.Value != ip || rec.TTL != 300 || rec.Name != "example.com" {
+			return fmt.Errorf("answer = %+v", rec)
+		}
+		return nil
+	}
+
+	t.Run("udp", func(t *testing.T) {
+		pc, l := listenDNS(t)
+		serveUDP(pc, func(q []byte) []byte { return dnsReply(t, q, false, udpAnswer) })
+		serveStream(l, func(q []byte) []byte { return dnsReply(t, q, false, tcpAnswer) })
+		res, err := g.dnsQuery(dnsResolver{network: "udp", address: pc.LocalAddr().String()}, "example.com", dnsmessage.TypeA, 2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := want(res, "192.0.2.1"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("truncated udp falls back to tcp", func(t *testing.T) {
+		pc, l := listenDNS(t)
+		serveUDP(pc, func(q []byte) []byte { return dnsReply(t, q, true) })
+		serveStream(l, func(q []byte) []byte { return dnsReply(t, q, false, tcpAnswer) })
+		res, err := g.dnsQuery(dnsResolver{network: "udp", address: pc.LocalAddr().String()}, "example.com", dnsmessage.TypeA, 2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := want(res, "192.0.2.2"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("mismatched id", func(t *testing.T) {
+		pc, _ := listenDNS(t)
+		serveUDP(pc, func(q []byte) []byte {
+			b := dnsReply(t, q, false, udpAnswer)
+			b[0] ^= 0xff
+			return b
+		})
+		_, err := g.dnsQuery(dnsResolver{network: "udp", address: pc.LocalAddr().String()}, "example.com", dnsmessage.TypeA, 2000)
+		if err == nil || !strings.Contains(err.Error(), "ID does not match") {
+			t.Errorf("error = %v", err)
+		}
+	})
+
+	t.Run("server failure", func(t *testing.T) {
+		pc, _ := listenDNS(t)
+		serveUDP(pc, func(q []byte) []byte {
+			b := dnsReply(t, q, false)
+			b[3] |= byte(dnsmessage.RCodeServerFailure)
+			return b
+		})
+		_, err := g.dnsQuery(dnsResolver{network: "udp", address: pc.LocalAddr().String()}, "example.com", dnsmessage.TypeA, 2000)
+		if err == nil || !strings.Contains(err.Error(), "ServerFailure") {
+			t.Errorf("error = %v", err)
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		_, l := listenDNS(t)
+		serveStream(l, func(q []byte) []byte { return dnsReply(t, q, false, tcpAnswer) })
+		res, err := g.dnsQuery(dnsResolver{network: "tcp", address: l.Addr().String()}, "example.com", dnsmessage.TypeA, 2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := want(res, "192.0.2.2"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// httptest's certificate is valid for 127.0.0.1, so it serves for
+	// DNS-over-TLS as well as DNS-over-HTTPS
+	var gotType string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		q, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsReply(t, q, false, udpAnswer))
+	}))
+	defer srv.Close()
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	t.Run("tls", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		serveStream(tls.NewListener(l, &tls.Config{Certificates: srv.TLS.Certificates}), func(q []byte) []byte { return dnsReply(t, q, false, tcpAnswer) })
+
+		r, err := parseResolver("tls://" + l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.roots = roots
+		res, err := g.dnsQuery(r, "example.com", dnsmessage.TypeA, 2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := want(res, "192.0.2.2"); err != nil {
+			t.Error(err)
+		}
+
+		// a server the roots don't vouch for is refused
+		r.roots = nil
+		if _, err := g.dnsQuery(r, "example.com", dnsmessage.TypeA, 2000); err == nil {
+			t.Error("untrusted DNS-over-TLS server was accepted")
+		}
+	})
+
+	t.Run("https", func(t *testing.T) {
+		g := quietGhost(config{})
+		g.client = srv.Client()
+		r, err := parseResolver(srv.URL + "/dns-query")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := g.dnsQuery(r, "example.com", dnsmessage.TypeA, 2000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := want(res, "192.0.2.1"); err != nil {
+			t.Error(err)
+		}
+		if gotType != "application/dns-message" {
+			t.Errorf("request content type = %q", gotType)
+		}
+	})
+}
+
+func TestParseResolver(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"1.1.1.1", "udp://1.1.1.1:53"},
+		{"tcp://1.1.1.1", "tcp://1.1.1.1:53"},
+		{"dot://dns.example", "tls://dns.example:853"},
+		{"tls://[2001:db8::1]", "tls://[2001:db8::1]:853"},
+		{"udp://127.0.0.1:5353/", "udp://127.0.0.1:5353"},
+		{"https://dns.example/dns-query", "https://dns.example/dns-query"},
+	}
+	for _, tt := range tests {
+		r, err := parseResolver(tt.in)
+		if err != nil || r.String() != tt.want {
+			t.Errorf("parseResolver(%q) = %s, %v; want %s", tt.in, r, err, tt.want)
+		}
+	}
+	if _, err := parseResolver("quic://1.1.1.1"); err == nil {
+		t.Error("parseResolver accepted an unsupported scheme")
+	}
+}
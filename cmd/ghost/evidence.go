@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// evidenceLogName is the hash-chained log of captures in evidence mode.
+const evidenceLogName = "evidence/log.jsonl"
+
+// evidenceEntry is a single capture in the evidence log. SHA1 is the
+// base32 SHA-1 of the content, in the form the CDX server uses for its
+// digests, and Digest says whether it matches the CDX digest: "match",
+// "mismatch", or "unknown" when the CDX server didn't list one. URL is
+// where the content came from, after any redirects, and Requested the
+// URL asked for if it was different. Hash covers the entry (with Hash
+// empty) and the hash of the entry before it.
+type evidenceEntry struct {
+	Seq       int       `json:"seq"`
+	Time      time.Time `json:"time"`
+	URL       string    `json:"url"`
+	Requested string    `json:"requested,omitempty"`
+	Timestamp string    `json:"timestamp"`
+	Original  string    `json:"original"`
+	File      string    `json:"file"`
+	Bytes     int64     `json:"bytes"`
+	SHA256    string    `json:"sha256"`
+	SHA1      string    `json:"sha1"`
+	CDXDigest string    `json:"cdx_digest,omitempty"`
+	Digest    string    `json:"digest"`
+	Prev      string    `json:"prev"`
+	Hash      string    `json:"hash"`
+}
+
+// chainHash returns the hash of e chained to the entry before it.
+func (e evidenceEntry) chainHash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(e.Prev), b...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// evidenceGenesis is the Prev of the first entry in the log.
+var evidenceGenesis = strings.Repeat("0", 64)
+
+// evidenceLog is a mutex-protected record of every capture fetched in
+// evidence mode, along with the CDX digests of the captures ghost knows
+// about.
+type evidenceLog struct {
+	mu      sync.Mutex
+	digests map[string]string
+	stored  map[string]bool
+	entries []evidenceEntry
+	head    string
+}
+
+// newEvidenceLog returns a pointer to a new evidenceLog.
+func newEvidenceLog() *evidenceLog {
+	return &evidenceLog{
+		digests: make(map[string]string),
+		stored:  make(map[string]bool),
+		head:    evidenceGenesis,
+	}
+}
+
+// captureKey identifies a capture by timestamp and original URL, loosely
+// enough that http://Example.com/ and https://example.com match.
+func captureKey(timestamp, original string) string {
+	original = strings.ToLower(original)
+	for _, prefix := range []string{"https://", "http://"} {
+		original = strings.TrimPrefix(original, prefix)
+	}
+	return timestamp + " " + strings.TrimSuffix(original, "/")
+}
+
+// addDigest records the CDX digest of a capture.
+func (l *evidenceLog) addDigest(timestamp, original, digest string) {
+	if digest == "" {
+		return
+	}
+	l.mu.Lock()
+	l.digests[captureKey(timestamp, original)] = digest
+	l.mu.Unlock()
+}
+
+// addDigests records the CDX digests in rows of CDX results, a header
+// row of field names followed by rows of strings.
+func (l *evidenceLog) addDigests(rows [][]string) {
+	if len(rows) < 2 {
+		return
+	}
+	ts, original, digest := -1, -1, -1
+	for i, f := range rows[0] {
+		switch f {
+		case "timestamp":
+			ts = i
+		case "original":
+			original = i
+		case "digest":
+			digest = i
+		}
+	}
+	if ts < 0 || original < 0 || digest < 0 {
+		return
+	}
+	for _, r := range rows[1:] {
+		if ts < len(r) && original < len(r) && digest < len(r) {
+			l.addDigest(r[ts], r[original], r[digest])
+		}
+	}
+}
+
+// captureURL matches a Wayback Machine capture URL, with or without a
+// modifier like id_.
+var captureURL = regexp.MustCompile(`^https?://web\.archive\.org/web/(\d{1,14})(?:[a-z]{2}_)?/(.+)$`)
+
+// cdxDigest returns the digest the CDX server would give data: its SHA-1
+// in base32.
+func cdxDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// captureEvidence stores the raw bytes of a capture requested from
+// requested and served from u under evidence/captures, named by their
+// SHA-256, checks them against the capture's CDX digest, and adds them to
+// the evidence log. The capture is identified by u, the URL after any
+// redirects. Anything that isn't a capture is ignored.
+func (g *ghost) captureEvidence(requested, u string, data []byte) {
+	m := captureURL.FindStringSubmatch(u)
+	if m == nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	e := evidenceEntry{
+		Time:      time.Now().UTC(),
+		URL:       u,
+		Timestamp: m[1],
+		Original:  m[2],
+		Bytes:     int64(len(data)),
+		SHA256:    hex.EncodeToString(sum[:]),
+		SHA1:      cdxDigest(data),
+		Digest:    "unknown",
+	}
+	if requested != u {
+		e.Requested = requested
+	}
+	e.File = "evidence/captures/" + e.SHA256
+
+	l := g.evidence
+	l.mu.Lock()
+	e.CDXDigest = l.digests[captureKey(e.Timestamp, e.Original)]
+	write := !l.stored[e.SHA256]
+	l.stored[e.SHA256] = true
+	l.mu.Unlock()
+
+	switch {
+	case e.CDXDigest == "":
+	case strings.EqualFold(e.CDXDigest, e.SHA1):
+		e.Digest = "match"
+	default:
+		e.Digest = "mismatch"
+		g.errorLog.Printf("digest mismatch for %s: CDX has %s, content is %s\n", u, e.CDXDigest, e.SHA1)
+	}
+	if write {
+		g.writeData(e.File, data)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq = len(l.entries) + 1
+	e.Prev = l.head
+	hash, err := e.chainHash()
+	if err != nil {
+		g.errorLog.Printf("evidence log error: %v\n", err)
+		return
+	}
+	e.Hash = hash
+	l.head = hash
+	l.entries = append(l.entries, e)
+}
+
+// evidenceSummary is the evidence section of the manifest.
+type evidenceSummary struct {
+	Log        string `json:"log"`
+	Captures   int    `json:"captures"`
+	Matched    int    `json:"digest_matched"`
+	Mismatched int    `json:"digest_mismatched"`
+	Unknown    int    `json:"digest_unknown"`
+	ChainHead  string `json:"chain_head"`
+	PublicKey  string `json:"public_key,omitempty"`
+}
+
+// evidenceWriter writes the evidence log, one entry per line, and returns
+// its summary for the manifest.
+func (g *ghost) evidenceWriter() *evidenceSummary {
+	l := g.evidence
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := &evidenceSummary{Log: evidenceLogName, Captures: len(l.entries), ChainHead: l.head}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, e := range l.entries {
+		switch e.Digest {
+		case "match":
+			s.Matched++
+		case "mismatch":
+			s.Mismatched++
+		default:
+			s.Unknown++
+		}
+		err := enc.Encode(e)
+		if err != nil {
+			g.errorLog.Printf("evidence log error: %v\n", err)
+			return s
+		}
+	}
+	if key := g.config.signingKey; key != nil {
+		s.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	}
+	g.infoLog.Printf("Stored %d capture(s): %d matched their CDX digest, %d didn't, %d had none.\n", s.Captures, s.Matched, s.Mismatched, s.Unknown)
+	g.writeData(evidenceLogName, buf.Bytes())
+	return s
+}
+
+// manifestSignature is written to manifest.sig alongside a signed
+// manifest. The signature covers the bytes of manifest.json.
+type manifestSignature struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// signManifest signs the manifest with the -key and writes the signature
+// to manifest.sig.
+func (g *ghost) signManifest(manifest []byte) {
+	key := g.config.signingKey
+	sig := manifestSignature{
+		Algorithm: "ed25519",
+		PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, manifest)),
+	}
+	b, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		g.errorLog.Printf("signature marshal error: %v\n", err)
+		return
+	}
+	g.writeFileAt(g.out.path("manifest.sig"), append(b, '\n'))
+}
+
+// readKey returns the PEM block or the key bytes in data: hex, base64, or
+// raw.
+func readKey(data []byte) (*pem.Block, []byte) {
+	if block, _ := pem.Decode(data); block != nil {
+		return block, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if b, err := hex.DecodeString(text); err == nil {
+		return nil, b
+	}
+	if b, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return nil, b
+	}
+	return nil, data
+}
+
+// parsePrivateKey parses an Ed25519 private key: a PKCS #8 PEM file, like
+// the ones openssl genpkey -algorithm ed25519 writes, or a 32-byte seed
+// or 64-byte key in hex, base64, or raw.
+func parsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, b := readKey(data)
+	if block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("not an Ed25519 key: %T", key)
+		}
+		return k, nil
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, errors.New("not an Ed25519 private key")
+}
+
+// parsePublicKey parses an Ed25519 public key: a PKIX PEM file, or 32
+// bytes in hex, base64, or raw. A private key is accepted too.
+func parsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, b := readKey(data)
+	if block != nil && block.Type == "PUBLIC KEY" {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("not an Ed25519 key: %T", key)
+		}
+		return k, nil
+	}
+	if block == nil && len(b) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(b), nil
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, errors.New("not an Ed25519 public key")
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// runVerify implements "ghost verify": it checks each run directory
+// given against its manifest, signature, and evidence log.
+func runVerify(args []string) {
+	var keyFile string
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.StringVar(&keyFile, "key", "", "name of file containing the Ed25519 public key the manifest must be signed with.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: ghost verify [-key public.pem] run-directory...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	g := newGhost(config{})
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var key ed25519.PublicKey
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			g.errorLog.Fatal(err)
+		}
+		key, err = parsePublicKey(data)
+		if err != nil {
+			g.errorLog.Fatalf("unable to read %s: %v", keyFile, err)
+		}
+	}
+
+	failed := false
+	for _, dir := range fs.Args() {
+		problems := g.verifyRun(dir, key)
+		if len(problems) == 0 {
+			g.infoLog.Printf("%s: verified.\n", dir)
+			continue
+		}
+		failed = true
+		for _, p := range problems {
+			g.errorLog.Printf("%s: %s\n", dir, p)
+		}
+		g.errorLog.Printf("%s: %d problem(s).\n", dir, len(problems))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// verifyRun checks a run directory and returns any problems found: the
+// manifest's signature (required if key is given), the hash of every
+// file in the manifest, and, for evidence runs, the evidence log's hash
+// chain and every capture in it.
+func (g *ghost) verifyRun(dir string, key ed25519.PublicKey) []string {
+	var problems []string
+	fail := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var m manifest
+	err = json.Unmarshal(raw, &m)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to read manifest: %v", err)}
+	}
+
+	// the signature
+	sigData, err := os.ReadFile(filepath.Join(dir, "manifest.sig"))
+	switch {
+	case err == nil:
+		var sig manifestSignature
+		err = json.Unmarshal(sigData, &sig)
+		if err != nil {
+			fail("unable to read signature: %v", err)
+			break
+		}
+		pub, err := base64.StdEncoding.DecodeString(sig.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			fail("invalid public key in signature")
+			break
+		}
+		s, err := base64.StdEncoding.DecodeString(sig.Signature)
+		if err != nil {
+			fail("invalid signature: %v", err)
+			break
+		}
+		if key != nil && !bytes.Equal(key, pub) {
+			fail("manifest was signed with a different key")
+		}
+		if m.Evidence != nil && m.Evidence.PublicKey != "" && m.Evidence.PublicKey != sig.PublicKey {
+			fail("manifest names a different key than its signature")
+		}
+		if !ed25519.Verify(pub, raw, s) {
+			fail("manifest signature does not match")
+		} else if key == nil {
+			g.infoLog.Printf("%s: signature is valid, but was only checked against the key it names; use -key to check who signed it.\n", dir)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if key != nil {
+			fail("manifest is not signed")
+		} else {
+			g.infoLog.Printf("%s: manifest is not signed.\n", dir)
+		}
+	default:
+		fail("%v", err)
+	}
+
+	// the files
+	for _, f := range m.Files {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f.Name)))
+		if err != nil {
+			fail("%v", err)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		if hex.EncodeToString(sum[:]) != f.SHA256 || int64(len(b)) != f.Bytes {
+			fail("%s has changed", f.Name)
+		}
+	}
+
+	if m.Evidence == nil {
+		return problems
+	}
+
+	// the evidence log and captures
+	logFile, err := os.Open(filepath.Join(dir, filepath.FromSlash(m.Evidence.Log)))
+	if err != nil {
+		fail("%v", err)
+		return problems
+	}
+	defer logFile.Close()
+
+	head := evidenceGenesis
+	count := 0
+	s := bufio.NewScanner(logFile)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var e evidenceEntry
+		err := json.Unmarshal(s.Bytes(), &e)
+		if err != nil {
+			fail("evidence log line %d: %v", count+1, err)
+			return problems
+		}
+		count++
+		if e.Seq != count || e.Prev != head {
+			fail("evidence log entry %d is out of order", count)
+		}
+		hash, err := e.chainHash()
+		if err != nil || hash != e.Hash {
+			fail("evidence log entry %d has been altered", count)
+		}
+		head = e.Hash
+
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(e.File)))
+		if err != nil {
+			fail("capture %d: %v", count, err)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		if hex.EncodeToString(sum[:]) != e.SHA256 {
+			fail("capture %d (%s) has changed", count, e.File)
+		}
+		if cdxDigest(b) != e.SHA1 {
+			fail("capture %d (%s) does not match its SHA-1", count, e.File)
+		}
+		if e.Digest == "match" && !strings.EqualFold(e.CDXDigest, e.SHA1) {
+			fail("capture %d is marked as matching a CDX digest it doesn't match", count)
+		}
+	}
+	if err := s.Err(); err != nil {
+		fail("evidence log: %v", err)
+	}
+	if count != m.Evidence.Captures {
+		fail("evidence log has %d entries; the manifest lists %d", count, m.Evidence.Captures)
+	}
+	if head != m.Evidence.ChainHead {
+		fail("evidence log chain ends at %s; the manifest has %s", head, m.Evidence.ChainHead)
+	}
+	return problems
+}
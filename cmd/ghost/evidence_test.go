@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quietGhost returns a ghost for tests that logs nothing.
+func quietGhost(c config) *ghost {
+	g := newGhost(c)
+	g.infoLog = log.New(io.Discard, "", 0)
+	g.errorLog = log.New(io.Discard, "", 0)
+	return g
+}
+
+// roundTripFunc serves HTTP requests in tests without a network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// testKey returns an Ed25519 key made from seed.
+func testKey(seed byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+}
+
+// evidenceRun writes a signed evidence run with two captures, the first
+// matching its CDX digest, and returns its directory.
+func evidenceRun(t *testing.T) string {
+	t.Helper()
+	g := quietGhost(config{evidence: true, signingKey: testKey(1)})
+	out, err := newOutput(outputOptions{dir: t.TempDir()}, "https://example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.out = out
+
+	first := []byte("<html>first</html>")
+	g.evidence.addDigest("20200102030405", "http://example.com/", cdxDigest(first))
+	g.captureEvidence("https://web.archive.org/web/20200102030405id_/http://example.com/", "https://web.archive.org/web/20200102030405id_/http://example.com/", first)
+	second := "https://web.archive.org/web/20210102030405id_/http://example.com/about"
+	g.captureEvidence(second, second, []byte("<html>second</html>"))
+	g.writeData("snapshots.json", []byte(`[["timestamp"],["20200102030405"]]`))
+	g.manifestWriter(time.Now(), 2, "")
+	return g.out.dir
+}
+
+// readEvidenceLog returns the entries of the evidence log in dir.
+func readEvidenceLog(t *testing.T, dir string) []evidenceEntry {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(evidenceLogName)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []evidenceEntry
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var e evidenceEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// writeEvidenceLog replaces the evidence log in dir with entries.
+func writeEvidenceLog(t *testing.T, dir string, entries []evidenceEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(evidenceLogName)), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyRun(t *testing.T) {
+	g := quietGhost(config{})
+	pub := testKey(1).Public().(ed25519.PublicKey)
+
+	dir := evidenceRun(t)
+	entries := readEvidenceLog(t, dir)
+	if len(entries) != 2 || entries[0].Digest != "match" || entries[1].Digest != "unknown" {
+		t.Fatalf("evidence log = %+v", entries)
+	}
+	if problems := g.verifyRun(dir, pub); len(problems) != 0 {
+		t.Fatalf("verifyRun on an untouched run: %v", problems)
+	}
+	if problems := g.verifyRun(dir, nil); len(problems) != 0 {
+		t.Fatalf("verifyRun without a key: %v", problems)
+	}
+
+	tests := []struct {
+		name   string
+		key    ed25519.PublicKey
+		change func(t *testing.T, dir string)
+		want   string
+	}{
+		{
+			name: "tampered result",
+			change: func(t *testing.T, dir string) {
+				os.WriteFile(filepath.Join(dir, "snapshots.json"), []byte("[]"), 0644)
+			},
+			want: "snapshots.json has changed",
+		},
+		{
+			name: "tampered capture",
+			change: func(t *testing.T, dir string) {
+				e := readEvidenceLog(t, dir)[1]
+				os.WriteFile(filepath.Join(dir, filepath.FromSlash(e.File)), []byte("<html>forged</html>"), 0644)
+			},
+			want: "capture 2 (evidence/captures/",
+		},
+		{
+			name: "missing capture",
+			change: func(t *testing.T, dir string) {
+				e := readEvidenceLog(t, dir)[0]
+				os.Remove(filepath.Join(dir, filepath.FromSlash(e.File)))
+			},
+			want: "capture 1:",
+		},
+		{
+			name: "altered entry",
+			change: func(t *testing.T, dir string) {
+				entries := readEvidenceLog(t, dir)
+				entries[0].Digest = "unknown"
+				writeEvidenceLog(t, dir, entries)
+			},
+			want: "evidence log entry 1 has been altered",
+		},
+		{
+			name: "broken chain link",
+			change: func(t *testing.T, dir string) {
+				// a rehashed entry that doesn't chain to the one before it
+				entries := readEvidenceLog(t, dir)
+				entries[1].Prev = evidenceGenesis
+				entries[1].Hash, _ = entries[1].chainHash()
+				writeEvidenceLog(t, dir, entries)
+			},
+			want: "evidence log entry 2 is out of order",
+		},
+		{
+			name: "dropped entry",
+			change: func(t *testing.T, dir string) {
+				writeEvidenceLog(t, dir, readEvidenceLog(t, dir)[1:])
+			},
+			want: "evidence log has 1 entries; the manifest lists 2",
+		},
+		{
+			name: "bad signature",
+			change: func(t *testing.T, dir string) {
+				p := filepath.Join(dir, "manifest.json")
+				b, _ := os.ReadFile(p)
+				os.WriteFile(p, bytes.Replace(b, []byte(`"snapshots": 2`), []byte(`"snapshots": 3`), 1), 0644)
+			},
+			want: "manifest signature does not match",
+		},
+		{
+			name:   "different key",
+			key:    testKey(2).Public().(ed25519.PublicKey),
+			change: func(t *testing.T, dir string) {},
+			want:   "manifest was signed with a different key",
+		},
+		{
+			name: "unsigned",
+			key:  pub,
+			change: func(t *testing.T, dir string) {
+				os.Remove(filepath.Join(dir, "manifest.sig"))
+			},
+			want: "manifest is not signed",
+		},
+	}
+	for _, tt := range tests {
+		dir := evidenceRun(t)
+		tt.change(t, dir)
+		problems := g.verifyRun(dir, tt.key)
+		found := false
+		for _, p := range problems {
+			if strings.Contains(p, tt.want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: problems = %q, want one containing %q", tt.name, problems, tt.want)
+		}
+	}
+}
+
+func TestGetDataEvidenceRedirect(t *testing.T) {
+	const (
+		requested = "https://web.archive.org/web/2020id_/http://example.com/"
+		final     = "https://web.archive.org/web/20200102030405id_/http://example.com/"
+	)
+	g := quietGhost(config{evidence: true})
+	out, err := newOutput(outputOptions{dir: t.TempDir()}, "https://example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.out = out
+	g.client = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		resp := &http.Response{Request: req, Header: make(http.Header), Body: io.NopCloser(strings.NewReader("page"))}
+		if req.URL.String() == requested {
+			resp.StatusCode = http.StatusFound
+			resp.Header.Set("Location", final)
+		} else {
+			resp.StatusCode = http.StatusOK
+		}
+		return resp, nil
+	})}
+
+	if _, err := g.getData(requested, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.evidence.entries) != 1 {
+		t.Fatalf("evidence log has %d entries, want 1", len(g.evidence.entries))
+	}
+	e := g.evidence.entries[0]
+	if e.URL != final || e.Requested != requested || e.Timestamp != "20200102030405" {
+		t.Errorf("entry has URL %q, requested %q, timestamp %q; want the capture redirected to", e.URL, e.Requested, e.Timestamp)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// outputFormats are the formats -format accepts. JSON is always written;
+// naming it just makes that explicit.
+var outputFormats = []string{"json", "jsonl", "csv", "sqlite", "md"}
+
+// parseFormats splits and checks a -format list.
+func parseFormats(s string) ([]string, error) {
+	var formats []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || contains(formats, f) {
+			continue
+		}
+		if !contains(outputFormats, f) {
+			return nil, fmt.Errorf("unknown output format %q (want %s)", f, strings.Join(outputFormats, ", "))
+		}
+		formats = append(formats, f)
+	}
+	return formats, nil
+}
+
+// column is a named, typed column of a table. typ is a SQLite type,
+// TEXT or INTEGER.
+type column struct {
+	name string
+	typ  string
+}
+
+// table is a result laid out as rows for the -format writers. Values are
+// strings, int64s, or nil.
+type table struct {
+	name    string
+	columns []column
+	rows    [][]interface{}
+}
+
+// tableSet is a mutex-protected set of tables, kept in the order they
+// were first stored.
+type tableSet struct {
+	mu     sync.Mutex
+	tables []*table
+}
+
+// store adds t to the set, appending its rows to any table already
+// stored under the same name.
+func (s *tableSet) store(t *table) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, old := range s.tables {
+		if old.name == t.name {
+			old.rows = append(old.rows, t.rows...)
+			return
+		}
+	}
+	s.tables = append(s.tables, t)
+}
+
+// storeTable keeps t for the -format writers, if any formats besides JSON
+// were asked for.
+func (g *ghost) storeTable(t *table) {
+	for _, f := range g.config.formats {
+		if f != "json" {
+			g.tables.store(t)
+			return
+		}
+	}
+}
+
+// cdxTable lays out CDX results (a header row of field names followed by
+// rows of strings) as a table. The fields named in ints are INTEGER
+// columns; values in them that aren't numbers, like "-", are kept as text.
+func cdxTable(name string, rows [][]string, ints ...string) *table {
+	t := &table{name: name}
+	if len(rows) == 0 {
+		return t
+	}
+	for _, f := range rows[0] {
+		typ := "TEXT"
+		if contains(ints, f) {
+			typ = "INTEGER"
+		}
+		t.columns = append(t.columns, column{f, typ})
+	}
+	for _, r := range rows[1:] {
+		row := make([]interface{}, len(t.columns))
+		for i, c := range t.columns {
+			if i >= len(r) {
+				break
+			}
+			row[i] = r[i]
+			if c.typ == "INTEGER" {
+				if n, err := strconv.ParseInt(r[i], 10, 64); err == nil {
+					row[i] = n
+				}
+			}
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t
+}
+
+// searchTable lays out search results, one row per matching snapshot.
+func searchTable(data map[string][]string) *table {
+	t := &table{
+		name:    "search_hits",
+		columns: []column{{"term", "TEXT"}, {"timestamp", "TEXT"}, {"url", "TEXT"}},
+	}
+	terms := make([]string, 0, len(data))
+	for term := range data {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	for _, term := range terms {
+		for _, u := range data[term] {
+			var ts interface{}
+			if m := snapshotTimestamp.FindStringSubmatch(u); m != nil {
+				ts = m[1]
+			}
+			t.rows = append(t.rows, []interface{}{term, ts, u})
+		}
+	}
+	return t
+}
+
+// ruleTable lays out rule matches, one row per matching string in each
+// source.
+func ruleTable(data map[string][]ruleMatch) *table {
+	t := &table{
+		name:    "rule_matches",
+		columns: []column{{"rule", "TEXT"}, {"source", "TEXT"}, {"string", "TEXT"}, {"count", "INTEGER"}},
+	}
+	rules := make([]string, 0, len(data))
+	for rule := range data {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		for _, m := range data[rule] {
+			ids := make([]string, 0, len(m.Strings))
+			for id := range m.Strings {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			if len(ids) == 0 {
+				t.rows = append(t.rows, []interface{}{rule, m.Source, nil, nil})
+			}
+			for _, id := range ids {
+				t.rows = append(t.rows, []interface{}{rule, m.Source, id, int64(m.Strings[id])})
+			}
+		}
+	}
+	return t
+}
+
+// whoisTable lays out a whois record as a single row, joining lists with
+// spaces.
+func whoisTable(r *whoisRecord) *table {
+	names := []string{
+		"domain", "registrar", "registrar_url", "abuse_email",
+		"registrant_name", "registrant_organization", "registrant_email", "registrant_country",
+		"created", "updated", "expires", "name_servers", "status", "servers",
+	}
+	t := &table{name: "whois"}
+	for _, n := range names {
+		t.columns = append(t.columns, column{n, "TEXT"})
+	}
+	values := []string{
+		r.Domain, r.Registrar, r.RegistrarURL, r.AbuseEmail,
+		r.Registrant.Name, r.Registrant.Organization, r.Registrant.Email, r.Registrant.Country,
+		r.Created, r.Updated, r.Expires,
+		strings.Join(r.NameServers, " "), strings.Join(r.Status, " "), strings.Join(r.Servers, " "),
+	}
+	row := make([]interface{}, len(values))
+	for i, v := range values {
+		if v != "" {
+			row[i] = v
+		}
+	}
+	t.rows = [][]interface{}{row}
+	return t
+}
+
+// dnsTable lays out DNS records, one row each.
+func dnsTable(records []dnsRecord) *table {
+	t := &table{
+		name:    "dns_records",
+		columns: []column{{"name", "TEXT"}, {"type", "TEXT"}, {"ttl", "INTEGER"}, {"value", "TEXT"}},
+	}
+	for _, r := range records {
+		t.rows = append(t.rows, []interface{}{r.Name, r.Type, int64(r.TTL), r.Value})
+	}
+	return t
+}
+
+// formatWriter writes the stored tables in each -format: a .jsonl and a
+// .csv file per table, and a single results.db and results.md holding
+// every table.
+func (g *ghost) formatWriter() {
+	tables := g.tables.tables
+	if len(tables) == 0 {
+		return
+	}
+	for _, f := range g.config.formats {
+		switch f {
+		case "jsonl":
+			for _, t := range tables {
+				b, err := t.jsonl()
+				if err != nil {
+					g.errorLog.Printf("%s jsonl error: %v\n", t.name, err)
+					continue
+				}
+				g.writeData(t.name+".jsonl", b)
+			}
+		case "csv":
+			for _, t := range tables {
+				b, err := t.csv()
+				if err != nil {
+					g.errorLog.Printf("%s csv error: %v\n", t.name, err)
+					continue
+				}
+				g.writeData(t.name+".csv", b)
+			}
+		case "sqlite":
+			b, err := buildSQLite(tables)
+			if err != nil {
+				g.errorLog.Printf("sqlite error: %v\n", err)
+				continue
+			}
+			g.writeData("results.db", b)
+		case "md":
+			var buf bytes.Buffer
+			for i, t := range tables {
+				if i > 0 {
+					buf.WriteByte('\n')
+				}
+				t.markdown(&buf)
+			}
+			g.writeData("results.md", buf.Bytes())
+		}
+	}
+}
+
+// jsonl encodes each row as a JSON object, keys in column order, one per
+// line.
+func (t *table) jsonl() ([]byte, error) {
+	var buf bytes.Buffer
+	value := func(v interface{}) error {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(v)
+		buf.Write(bytes.TrimRight(b.Bytes(), "\n"))
+		return err
+	}
+	for _, row := range t.rows {
+		buf.WriteByte('{')
+		for i, c := range t.columns {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := value(c.name); err != nil {
+				return nil, err
+			}
+			buf.WriteByte(':')
+			if err := value(row[i]); err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// csv encodes the table with a header row of column names. NULLs are
+// empty fields.
+func (t *table) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = c.name
+	}
+	w.Write(header)
+	for _, row := range t.rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = cellText(v)
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// markdown writes the table to buf as a section with a Markdown table.
+func (t *table) markdown(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "## %s\n\n", t.name)
+	if len(t.rows) == 0 {
+		buf.WriteString("No results.\n")
+		return
+	}
+	line := func(cells []string) {
+		buf.WriteString("|")
+		for _, c := range cells {
+			fmt.Fprintf(buf, " %s |", c)
+		}
+		buf.WriteString("\n")
+	}
+	header := make([]string, len(t.columns))
+	rule := make([]string, len(t.columns))
+	for i, c := range t.columns {
+		header[i] = markdownEscape(c.name)
+		rule[i] = "---"
+		if c.typ == "INTEGER" {
+			rule[i] = "---:"
+		}
+	}
+	line(header)
+	line(rule)
+	for _, row := range t.rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = markdownEscape(cellText(v))
+		}
+		line(cells)
+	}
+}
+
+// cellText returns a value as text.
+func cellText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return fmt.Sprint(v)
+}
+
+// markdownEscape keeps a value inside its table cell.
+var markdownEscape = strings.NewReplacer("\\", "\\\\", "|", "\\|", "\r\n", "<br>", "\n", "<br>", "\r", "<br>").Replace
@@ -6,27 +6,29 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
-	"strings"
 )
 
-// writeData takes in a file name and a byte slice and writes
-// the contents to a file.
+// writeData takes in a result name, relative to the run directory, and
+// a byte slice and writes the contents through the output manager.
 func (g *ghost) writeData(name string, data []byte) {
-	g.infoLog.Printf("Writing %s", name)
-	f, err := os.Create(name)
-	if err != nil {
-		g.errorLog.Println(err)
+	if g.out == nil {
+		g.errorLog.Printf("no output directory for %s\n", name)
 		return
 	}
-	defer f.Close()
-
-	_, err = f.Write(data)
+	p, err := g.out.write(name, data)
+	g.infoLog.Printf("Writing %s", p)
 	if err != nil {
 		g.errorLog.Println(err)
-		return
 	}
-	err = f.Sync()
+}
+
+// writeFileAt writes a file outside the run directory, like the caches
+// and the batch summary.
+func (g *ghost) writeFileAt(name string, data []byte) {
+	g.infoLog.Printf("Writing %s", name)
+	err := writeFile(name, data)
 	if err != nil {
 		g.errorLog.Println(err)
 	}
@@ -38,11 +40,11 @@ func (g *ghost) searchMapWriter(query interface{}, data map[string][]string) {
 	var name string
 	switch query.(type) {
 	case string:
-		name = "data/termResults.json"
+		name = "termResults.json"
 	case []string:
-		name = "data/termsResults.json"
+		name = "termsResults.json"
 	case *regexp.Regexp:
-		name = "data/regexResults.json"
+		name = "regexResults.json"
 	}
 
 	b, err := json.Marshal(data)
@@ -52,29 +54,41 @@ func (g *ghost) searchMapWriter(query interface{}, data map[string][]string) {
 	}
 
 	g.writeData(name, b)
-
+	g.storeTable(searchTable(data))
 }
 
 // getQuery checks whether the user has submitted a search term flag, a
-// regexp flag, or a file input flag and creates the query accordingly.
-func (g *ghost) getQuery() bool {
+// regexp flag, a file input flag, or a rule file flag and creates the
+// query accordingly.
+func (g *ghost) getQuery() (bool, error) {
 	switch {
+	case len(g.config.rules) > 0:
+		rules, err := g.readRuleFile(g.config.rules)
+		if err != nil {
+			return false, fmt.Errorf("unable to read rule file: %w", err)
+		}
+		g.query = rules
+		return true, nil
 	case len(g.config.regex) > 0:
-		g.query = regexp.MustCompile(g.config.regex)
-		return true
+		re, err := regexp.Compile(g.config.regex)
+		if err != nil {
+			return false, fmt.Errorf("invalid regex: %w", err)
+		}
+		g.query = re
+		return true, nil
 	case len(g.config.terms) > 0:
 		query, err := g.readInputFile(g.config.terms)
 		if err != nil {
-			g.errorLog.Fatal("Unable to read input file.")
+			return false, fmt.Errorf("unable to read input file: %w", err)
 		}
 		g.query = query
-		return true
+		return true, nil
 	case len(g.config.term) > 0:
 		g.query = g.config.term
-		return true
+		return true, nil
 	default:
 		g.infoLog.Println("No query submitted. Checking for snapshots...")
-		return false
+		return false, nil
 	}
 }
 
@@ -82,7 +96,16 @@ func (g *ghost) getQuery() bool {
 // CDX server. Including default values of "" doesn't impact the query results.
 func (g *ghost) formURL(url string, filters filters) string {
 	const base = "http://web.archive.org/cdx/search/cdx?output=json"
-	u := fmt.Sprintf("%s&fastLatest=true&url=%s&from=%s&to=%s&limit=%s&collapse=digest", base, url, filters.from, filters.to, filters.limit)
+	if filters.domain != "" {
+		// match the whole registrable domain, not just the host
+		if domain, err := g.getDomain(url); err == nil && domain != "" {
+			url = domain
+		}
+	}
+	u := fmt.Sprintf("%s&fastLatest=true&url=%s&from=%s&to=%s&limit=%s", base, url, filters.from, filters.to, filters.limit)
+	if filters.collapse != "" {
+		u = fmt.Sprintf("%s&collapse=%s", u, filters.collapse)
+	}
 	if filters.notMimetype != "" {
 		u = fmt.Sprintf("%s&filter=!mimetype:%s", u, filters.notMimetype)
 	} else {
@@ -125,54 +148,70 @@ func (g *ghost) readInputFile(name string) ([]string, error) {
 
 // getInputURL accepts a URL from stdin and sets it to g.config.url.
 func (g *ghost) getInputURL() {
-	s := bufio.NewScanner(os.Stdin)
-	for s.Scan() {
-		g.config.url = s.Text()
-	}
-	if err := s.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to read input: %v", err)
+	targets, err := readTargets(os.Stdin)
+	if err != nil {
+		g.errorLog.Fatalf("Unable to read input: %v", err)
 	}
-	if g.config.url != "" {
-		g.validateURL(g.config.url)
-	} else {
+	switch {
+	case len(targets) == 0:
 		g.errorLog.Fatal("Missing input url.")
+	case len(targets) > 1:
+		g.errorLog.Fatalf("Expected one input url, got %d.", len(targets))
+	}
+	g.config.url = targets[0]
+	err = g.validateURL(g.config.url)
+	if err != nil {
+		g.errorLog.Fatal(err)
 	}
-
 }
 
-// validateURL is a boolean check to see if a string is a valid URL.
-// It isn't bulletproof, but it should work for the scope of this
-// project.
-func (g *ghost) validateURL(myURL string) {
+// validateURL checks whether a string is a valid URL.
+func (g *ghost) validateURL(myURL string) error {
 	u, err := url.Parse(myURL)
 	if err != nil || u.Scheme == "" || u.Host == "" {
-		g.errorLog.Fatalf("%s is not a valid URL, please try again\n", myURL)
+		return fmt.Errorf("%s is not a valid URL, please try again", myURL)
 	}
+	return nil
 }
 
-// getDomain takes in the URL and returns the domain and any error.
+// getDomain takes in the URL and returns the registrable domain (e.g.
+// example.co.uk for https://api.dev.example.co.uk) and any error. IP
+// addresses and hosts that are public suffixes are returned as they are.
 func (g *ghost) getDomain(full string) (string, error) {
 	u, err := url.Parse(full)
 	if err != nil {
 		return "", err
 	}
-
-	var domain string
-	if strings.HasPrefix(u.Host, "www") {
-		parts := strings.Split(u.Host, ".")
-		domain = strings.Join(parts[1:], ".")
-	} else {
-		domain = u.Host
+	p, err := g.splitHost(u.Host)
+	if err != nil {
+		return "", err
 	}
-
-	return domain, nil
+	if p.Domain == "" {
+		return p.Host, nil
+	}
+	return p.Domain, nil
 }
 
-// getHost takes in the URL and returns the host and any error.
+// getHost takes in the URL and returns the host, in lowercase ASCII
+// (punycode), and any error.
 func (g *ghost) getHost(full string) (string, error) {
 	u, err := url.Parse(full)
 	if err != nil {
 		return "", err
 	}
-	return u.Host, nil
+	p, err := g.splitHost(u.Host)
+	if err != nil {
+		return "", err
+	}
+	return p.Host, nil
+}
+
+// cacheDir returns the directory ghost keeps downloaded reference data
+// (the RDAP bootstrap files, the Public Suffix List) of the given kind in.
+func cacheDir(kind string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ghost", kind), nil
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultPatterns is the built-in pattern library for flagging
+// interesting archived URLs.
+//
+//go:embed interesting.txt
+var defaultPatterns string
+
+// severities ranks the severity levels, highest first.
+var severities = map[string]int{"high": 0, "medium": 1, "low": 2, "info": 3}
+
+// pathPattern flags archived URLs whose path matches re.
+type pathPattern struct {
+	severity string
+	category string
+	re       *regexp.Regexp
+}
+
+// parsePatterns parses a pattern library: one severity, category, and
+// regular expression per line, separated by whitespace. Blank lines and
+// lines starting with # are skipped.
+func parsePatterns(src string) ([]pathPattern, error) {
+	var patterns []pathPattern
+	s := bufio.NewScanner(strings.NewReader(src))
+	var n int
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: want severity, category, and pattern", n)
+		}
+		severity := strings.ToLower(fields[0])
+		if _, ok := severities[severity]; !ok {
+			return nil, fmt.Errorf("line %d: unknown severity %q", n, fields[0])
+		}
+		// the pattern is the rest of the line, spaces and all
+		rest := strings.TrimSpace(line[len(fields[0]):])
+		expr := strings.TrimSpace(rest[len(fields[1]):])
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		patterns = append(patterns, pathPattern{severity: severity, category: strings.ToLower(fields[1]), re: re})
+	}
+	return patterns, s.Err()
+}
+
+// interestingPatterns returns the built-in patterns plus any in the
+// -ipatterns file.
+func (g *ghost) interestingPatterns() ([]pathPattern, error) {
+	patterns, err := parsePatterns(defaultPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("built-in patterns: %w", err)
+	}
+	if g.config.interestingFile != "" {
+		lines, err := g.readInputFile(g.config.interestingFile)
+		if err != nil {
+			return nil, err
+		}
+		extra, err := parsePatterns(strings.Join(lines, "\n"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", g.config.interestingFile, err)
+		}
+		patterns = append(patterns, extra...)
+	}
+	return patterns, nil
+}
+
+// interestingURL is an archived URL flagged by one or more patterns.
+type interestingURL struct {
+	URL        string   `json:"url"`
+	Severity   string   `json:"severity"`
+	Categories []string `json:"categories"`
+	Mimetype   string   `json:"mimetype"`
+	First      string   `json:"first"`
+	Last       string   `json:"last"`
+	Captures   string   `json:"captures"`
+}
+
+// classifyURLs takes in the archivedURLs data and flags backups, config
+// and env files, database dumps, archives, logs, admin panels, debug
+// endpoints, version-control paths, and anything else matched by the
+// pattern library. The flagged URLs are written to interesting.json,
+// highest severity first.
+func (g *ghost) classifyURLs(data []byte) {
+	patterns, err := g.interestingPatterns()
+	if err != nil {
+		g.errorLog.Printf("unable to load interesting patterns: %v\n", err)
+		return
+	}
+
+	var s [][]string
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		g.errorLog.Printf("classifyURLs unmarshal error: %v\n", err)
+		return
+	}
+	if len(s) < 2 {
+		return
+	}
+
+	var found []interestingURL
+	counts := make(map[string]int)
+	// skip the key
+	for _, v := range s[1:] {
+		// original, mimetype, timestamp, endtimestamp, groupcount, uniqcount
+		if len(v) < 5 {
+			continue
+		}
+		u, err := url.Parse(v[0])
+		if err != nil {
+			continue
+		}
+		p := u.Path
+		if p == "" {
+			p = "/"
+		}
+
+		severity := ""
+		categories := make(map[string]bool)
+		for _, pat := range patterns {
+			if !pat.re.MatchString(p) {
+				continue
+			}
+			categories[pat.category] = true
+			if severity == "" || severities[pat.severity] < severities[severity] {
+				severity = pat.severity
+			}
+		}
+		if severity == "" {
+			continue
+		}
+		counts[severity]++
+		found = append(found, interestingURL{
+			URL:        v[0],
+			Severity:   severity,
+			Categories: sortedKeys(categories),
+			Mimetype:   v[1],
+			First:      v[2],
+			Last:       v[3],
+			Captures:   v[4],
+		})
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.Severity != b.Severity {
+			return severities[a.Severity] < severities[b.Severity]
+		}
+		return a.URL < b.URL
+	})
+
+	g.infoLog.Printf("Flagged %d interesting URL(s): %d high, %d medium, %d low, %d info.\n",
+		len(found), counts["high"], counts["medium"], counts["low"], counts["info"])
+	if len(found) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(found)
+	if err != nil {
+		g.errorLog.Printf("classifyURLs marshal error: %v\n", err)
+		return
+	}
+	g.writeData("interesting.json", b)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultProviders lists the address ranges of well-known CDN, cloud,
+// and hosting providers.
+//
+//go:embed providers.txt
+var defaultProviders string
+
+// providerRange is an address range belonging to a provider.
+type providerRange struct {
+	provider string
+	kind     string
+	network  *net.IPNet
+}
+
+// parseProviders parses a provider range list: one provider, kind, and
+// CIDR per line, separated by whitespace. Blank lines and lines starting
+// with # are skipped.
+func parseProviders(src string) ([]providerRange, error) {
+	var ranges []providerRange
+	s := bufio.NewScanner(strings.NewReader(src))
+	var n int
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: want provider, kind, and CIDR", n)
+		}
+		_, network, err := net.ParseCIDR(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		ranges = append(ranges, providerRange{provider: fields[0], kind: fields[1], network: network})
+	}
+	return ranges, s.Err()
+}
+
+// hostingOrgs maps words found in AS organization names to the hosting
+// or cloud provider behind them, for addresses outside the embedded
+// ranges.
+var hostingOrgs = []struct {
+	match    string
+	provider string
+	kind     string
+}{
+	{"amazon", "aws", "cloud"},
+	{"google", "google", "cloud"},
+	{"microsoft", "azure", "cloud"},
+	{"oracle", "oracle", "cloud"},
+	{"alibaba", "alibaba", "cloud"},
+	{"tencent", "tencent", "cloud"},
+	{"cloudflare", "cloudflare", "cdn"},
+	{"fastly", "fastly", "cdn"},
+	{"akamai", "akamai", "cdn"},
+	{"digitalocean", "digitalocean", "hosting"},
+	{"linode", "linode", "hosting"},
+	{"ovh", "ovh", "hosting"},
+	{"hetzner", "hetzner", "hosting"},
+	{"vultr", "vultr", "hosting"},
+	{"choopa", "vultr", "hosting"},
+	{"scaleway", "scaleway", "hosting"},
+	{"contabo", "contabo", "hosting"},
+	{"leaseweb", "leaseweb", "hosting"},
+	{"github", "github", "hosting"},
+	{"vercel", "vercel", "hosting"},
+}
+
+// ipInfo is what the local databases and provider ranges say about an
+// address. Sources names where each answer came from.
+type ipInfo struct {
+	IP       string   `json:"ip"`
+	ASN      uint64   `json:"asn,omitempty"`
+	ASOrg    string   `json:"as_org,omitempty"`
+	Country  string   `json:"country,omitempty"`
+	City     string   `json:"city,omitempty"`
+	Provider string   `json:"provider,omitempty"`
+	Kind     string   `json:"kind,omitempty"`
+	Range    string   `json:"range,omitempty"`
+	Sources  []string `json:"sources,omitempty"`
+}
+
+// addSource records name as a source, once.
+func (info *ipInfo) addSource(name string) {
+	for _, s := range info.Sources {
+		if s == name {
+			return
+		}
+	}
+	info.Sources = append(info.Sources, name)
+}
+
+// enrichIPs looks up each address offline: in the MaxMind DB files given
+// with -mmdb, in the IP-to-ASN TSV given with -asndb, and in the embedded
+// provider ranges. Nothing here touches the network. The results are
+// written to ipinfo.json.
+func (g *ghost) enrichIPs(ips []net.IP) {
+	if len(ips) == 0 {
+		return
+	}
+	infos := make([]*ipInfo, len(ips))
+	for i, ip := range ips {
+		infos[i] = &ipInfo{IP: ip.String()}
+	}
+
+	for _, name := range strings.Split(g.config.mmdb, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		db, err := openMMDB(name)
+		if err != nil {
+			g.errorLog.Printf("unable to open %s: %v\n", name, err)
+			continue
+		}
+		for i, ip := range ips {
+			rec, err := db.lookup(ip)
+			if err != nil {
+				g.errorLog.Printf("%s lookup error for %s: %v\n", name, ip, err)
+				continue
+			}
+			if m, ok := rec.(map[string]interface{}); ok && infos[i].mergeMMDB(m) {
+				infos[i].addSource(db.databaseType)
+			}
+		}
+	}
+
+	if g.config.asnDB != "" {
+		err := lookupASNTSV(g.config.asnDB, ips, infos)
+		if err != nil {
+			g.errorLog.Printf("unable to read %s: %v\n", g.config.asnDB, err)
+		}
+	}
+
+	ranges, err := parseProviders(defaultProviders)
+	if err != nil {
+		g.errorLog.Printf("built-in provider ranges: %v\n", err)
+	}
+	for i, ip := range ips {
+		info := infos[i]
+		for _, r := range ranges {
+			if r.network.Contains(ip) {
+				info.Provider, info.Kind, info.Range = r.provider, r.kind, r.network.String()
+				info.addSource("providers")
+				break
+			}
+		}
+		if info.Provider != "" || info.ASOrg == "" {
+			continue
+		}
+		org := strings.ToLower(info.ASOrg)
+		for _, h := range hostingOrgs {
+			if strings.Contains(org, h.match) {
+				info.Provider, info.Kind = h.provider, h.kind
+				break
+			}
+		}
+	}
+
+	for _, info := range infos {
+		if info.Provider != "" {
+			g.infoLog.Printf("%s is served by %s (%s).\n", info.IP, info.Provider, info.Kind)
+		}
+	}
+
+	b, err := json.Marshal(infos)
+	if err != nil {
+		g.errorLog.Printf("ipinfo marshal error: %v\n", err)
+		return
+	}
+	g.writeData("ipinfo.json", b)
+}
+
+// mergeMMDB fills in the fields a MaxMind DB record has, and reports
+// whether it had any. It understands the GeoIP2/GeoLite2 and DB-IP
+// layouts, and the flat asn, as_name, and country_code layout of other
+// providers.
+func (info *ipInfo) mergeMMDB(m map[string]interface{}) bool {
+	var found bool
+	if n := toUint(m["autonomous_system_number"]); n != 0 {
+		info.ASN, found = n, true
+	}
+	if s, ok := m["asn"].(string); ok {
+		if n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "AS"), 10, 32); err == nil {
+			info.ASN, found = n, true
+		}
+	}
+	for _, key := range []string{"autonomous_system_organization", "as_name"} {
+		if s, ok := m[key].(string); ok && s != "" {
+			info.ASOrg, found = s, true
+		}
+	}
+	for _, key := range []string{"registered_country", "country"} {
+		if c, ok := m[key].(map[string]interface{}); ok {
+			if s, ok := c["iso_code"].(string); ok && s != "" {
+				info.Country, found = s, true
+			}
+		}
+	}
+	if s, ok := m["country_code"].(string); ok && s != "" {
+		info.Country, found = s, true
+	}
+	if c, ok := m["city"].(map[string]interface{}); ok {
+		if names, ok := c["names"].(map[string]interface{}); ok {
+			if s, ok := names["en"].(string); ok && s != "" {
+				info.City, found = s, true
+			}
+		}
+	}
+	return found
+}
+
+// lookupASNTSV scans an IP-to-ASN TSV file (optionally gzipped) in the
+// iptoasn.com layout: range start, range end, AS number, country code,
+// and AS description, tab-separated. Ranges with AS number 0 aren't
+// routed and are skipped.
+func lookupASNTSV(name string, ips []net.IP, infos []*ipInfo) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil || asn == 0 {
+			continue
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			continue
+		}
+		for i, ip := range ips {
+			// compare like with like, so IPv4 never matches an IPv6 range
+			if (ip.To4() == nil) != (start.To4() == nil) {
+				continue
+			}
+			if bytes.Compare(ip.To16(), start.To16()) < 0 || bytes.Compare(ip.To16(), end.To16()) > 0 {
+				continue
+			}
+			info := infos[i]
+			info.ASN = asn
+			if fields[3] != "" && fields[3] != "None" {
+				info.Country = fields[3]
+			}
+			if fields[4] != "" && fields[4] != "Not routed" {
+				info.ASOrg = fields[4]
+			}
+			info.addSource("asndb")
+		}
+	}
+	return s.Err()
+}
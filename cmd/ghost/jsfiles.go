@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// jsPattern matches the original URL of JavaScript files.
+const jsPattern = `.*\.js(\?.*)?$`
+
+// jsExtractors are the patterns used to pull endpoints out of JavaScript,
+// keyed by the kind of endpoint each finds. Each has one capture group.
+var jsExtractors = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"fetch", regexp.MustCompile(`\bfetch\(\s*["'` + "`" + `]([^"'` + "`" + `\s]+)`)},
+	{"xhr", regexp.MustCompile(`\.open\(\s*["'](?i:GET|POST|PUT|DELETE|PATCH|HEAD|OPTIONS)["']\s*,\s*["'` + "`" + `]([^"'` + "`" + `\s]+)`)},
+	{"axios", regexp.MustCompile(`\baxios(?:\.(?:get|post|put|delete|patch|head|options|request))?\(\s*["'` + "`" + `]([^"'` + "`" + `\s]+)`)},
+	{"jquery", regexp.MustCompile(`\$\.(?:ajax|get|post|getJSON)\(\s*["'` + "`" + `]([^"'` + "`" + `\s]+)`)},
+	{"url", regexp.MustCompile(`["'` + "`" + `](https?://[A-Za-z0-9.-]+(?::\d+)?(?:/[^"'` + "`" + `\s<>\\]*)?)["'` + "`" + `]`)},
+	{"path", regexp.MustCompile(`["'` + "`" + `]((?:\.{1,2})?/[A-Za-z0-9_\-.~%{}$:@+]+(?:/[A-Za-z0-9_\-.~%{}$:@+]*)*(?:\?[^"'` + "`" + `\s]*)?)["'` + "`" + `]`)},
+	{"path", regexp.MustCompile(`["'` + "`" + `]([A-Za-z0-9_\-]+/[A-Za-z0-9_\-.]+(?:/[A-Za-z0-9_\-.]+)*(?:\?[^"'` + "`" + `\s]*)?)["'` + "`" + `]`)},
+	{"hostname", regexp.MustCompile(`["'` + "`" + `]((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,})["'` + "`" + `]`)},
+}
+
+// apiRoute matches paths that look like API routes.
+var apiRoute = regexp.MustCompile(`(?i)(?:^|/)(?:api|rest|graphql|v\d+|rpc|ajax|ws)(?:/|$|\?)`)
+
+// mediaType matches strings like text/html that look like relative paths.
+var mediaType = regexp.MustCompile(`^(?:application|audio|font|image|multipart|text|video)/`)
+
+// fileTLDs are file extensions that would otherwise pass for top-level
+// domains when matching bare hostnames.
+var fileTLDs = map[string]bool{
+	"css": true, "gif": true, "htm": true, "html": true, "jpeg": true, "jpg": true,
+	"js": true, "json": true, "map": true, "md": true, "mjs": true, "php": true,
+	"png": true, "svg": true, "ts": true, "tsx": true, "txt": true, "vue": true,
+	"woff": true, "xml": true,
+}
+
+// extractJS returns the endpoints, routes, and hostnames found in src,
+// keyed by value, with the kind(s) each was found as.
+func extractJS(src []byte) map[string]map[string]bool {
+	found := make(map[string]map[string]bool)
+	add := func(value, kind string) {
+		if found[value] == nil {
+			found[value] = make(map[string]bool)
+		}
+		found[value][kind] = true
+	}
+
+	for _, x := range jsExtractors {
+		for _, m := range x.re.FindAllSubmatch(src, -1) {
+			value := string(m[1])
+			switch x.kind {
+			case "path":
+				if mediaType.MatchString(value) || strings.HasPrefix(value, "//") {
+					continue
+				}
+				if apiRoute.MatchString(value) {
+					add(value, "api-route")
+					continue
+				}
+			case "url":
+				if u, err := url.Parse(value); err == nil {
+					add(u.Hostname(), "hostname")
+				}
+			case "hostname":
+				if fileTLDs[value[strings.LastIndexByte(value, '.')+1:]] {
+					continue
+				}
+			}
+			add(value, x.kind)
+		}
+	}
+	return found
+}
+
+// jsSighting is a JavaScript capture in which an endpoint was found.
+type jsSighting struct {
+	File      string `json:"file"`
+	Timestamp string `json:"timestamp"`
+}
+
+// jsEndpoint is a deduplicated endpoint along with everywhere it was seen.
+type jsEndpoint struct {
+	Value string       `json:"value"`
+	Kinds []string     `json:"kinds"`
+	Seen  []jsSighting `json:"seen"`
+
+	kinds map[string]bool
+}
+
+// jsEndpointMap is a mutex-protected map of endpoints found in
+// JavaScript files, in the key-value form value: endpoint.
+type jsEndpointMap struct {
+	mu        sync.Mutex
+	endpoints map[string]*jsEndpoint
+}
+
+// store adds the endpoints found in a single capture to the map.
+func (m *jsEndpointMap) store(found map[string]map[string]bool, f archivedFile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for value, kinds := range found {
+		e, ok := m.endpoints[value]
+		if !ok {
+			e = &jsEndpoint{Value: value, kinds: make(map[string]bool)}
+			m.endpoints[value] = e
+		}
+		for k := range kinds {
+			e.kinds[k] = true
+		}
+		e.Seen = append(e.Seen, jsSighting{File: f.Original, Timestamp: f.Timestamp})
+	}
+}
+
+// scanJS enumerates the archived JavaScript files for domain and fetches
+// each distinct capture. With -js, endpoints, API routes, fetch/XHR calls,
+// and hostnames are extracted from each file and written to
+// jsEndpoints.json and jsEndpoints.txt. With -maps, any sourceMappingURL
+// references are collected and handed off to sourceMaps.
+func (g *ghost) scanJS(wg *sync.WaitGroup, domain string, timeout int) {
+	defer wg.Done()
+
+	files, err := g.listArchived(domain, jsPattern, g.config.jsLimit, timeout)
+	if err != nil {
+		g.errorLog.Printf("unable to list JavaScript files: %v\n", err)
+	} else if len(files) == 0 {
+		g.infoLog.Println("No archived JavaScript files found.")
+	} else {
+		g.infoLog.Printf("Found %d archived JavaScript file(s).\n", len(files))
+	}
+
+	m := &jsEndpointMap{endpoints: make(map[string]*jsEndpoint)}
+	var (
+		mu   sync.Mutex
+		refs []mapRef
+	)
+	var jswg sync.WaitGroup
+	tokens := make(chan struct{}, g.config.gophers)
+	for _, f := range files {
+		tokens <- struct{}{}
+		jswg.Add(1)
+		f := f
+		g.goSafe(func() {
+			defer jswg.Done()
+			src, err := g.getData(f.rawURL(), timeout)
+			<-tokens
+			if err != nil {
+				g.errorLog.Printf("getData error for %s: %v\n", f.rawURL(), err)
+				return
+			}
+			g.scanAsset(src, f.rawURL())
+			if g.config.js {
+				m.store(extractJS(src), f)
+			}
+			if g.config.maps {
+				if ref, ok := sourceMapRef(src, f); ok {
+					mu.Lock()
+					refs = append(refs, ref)
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	jswg.Wait()
+
+	if g.config.js && len(files) > 0 {
+		g.jsEndpointWriter(m)
+	}
+	if g.config.maps {
+		g.sourceMaps(domain, refs, timeout)
+	}
+}
+
+// jsEndpointWriter sorts the endpoints found in JavaScript files and
+// writes them to jsEndpoints.json and jsEndpoints.txt.
+func (g *ghost) jsEndpointWriter(m *jsEndpointMap) {
+	endpoints := make([]*jsEndpoint, 0, len(m.endpoints))
+	values := make([]string, 0, len(m.endpoints))
+	for _, e := range m.endpoints {
+		e.Kinds = sortedKeys(e.kinds)
+		sort.Slice(e.Seen, func(i, j int) bool {
+			if e.Seen[i].Timestamp != e.Seen[j].Timestamp {
+				return e.Seen[i].Timestamp < e.Seen[j].Timestamp
+			}
+			return e.Seen[i].File < e.Seen[j].File
+		})
+		endpoints = append(endpoints, e)
+		values = append(values, e.Value)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Value < endpoints[j].Value })
+	sort.Strings(values)
+
+	g.infoLog.Printf("Found %d endpoint(s) in JavaScript files.\n", len(endpoints))
+
+	g.writeLines("jsEndpoints.txt", values)
+	b, err := json.Marshal(endpoints)
+	if err != nil {
+		g.errorLog.Printf("jsEndpoints marshal error: %v\n", err)
+		return
+	}
+	g.writeData("jsEndpoints.json", b)
+}
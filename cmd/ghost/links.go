@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// linkAttrs maps each element ghost extracts links from to the
+// attribute holding the link.
+var linkAttrs = map[string]string{
+	"a":      "href",
+	"form":   "action",
+	"iframe": "src",
+	"script": "src",
+}
+
+// archivePrefix matches the prefix the Wayback Machine adds when it
+// rewrites links in an archived page, e.g. https://web.archive.org/web/20220922000000js_/.
+var archivePrefix = regexp.MustCompile(`^(?:https?://web\.archive\.org)?/web/\d{1,14}(?:[a-z]{2}_)?/`)
+
+// linkEdge is a link from a page to a target, keyed by the element
+// it came from.
+type linkEdge struct {
+	source, target, kind string
+}
+
+// linkGraph is a mutex-protected record of the links found in each
+// snapshot, in the key-value form edge: timestamps, along with the
+// timestamps each page was captured at.
+type linkGraph struct {
+	mu        sync.Mutex
+	edges     map[linkEdge]map[string]bool
+	snapshots map[string]map[string]bool
+}
+
+// newLinkGraph returns a pointer to a new linkGraph.
+func newLinkGraph() *linkGraph {
+	return &linkGraph{
+		edges:     make(map[linkEdge]map[string]bool),
+		snapshots: make(map[string]map[string]bool),
+	}
+}
+
+// store records that the snapshot of page at timestamp contained edges.
+func (l *linkGraph) store(page, timestamp string, edges []linkEdge) {
+	l.mu.Lock()
+	if l.snapshots[page] == nil {
+		l.snapshots[page] = make(map[string]bool)
+	}
+	l.snapshots[page][timestamp] = true
+	for _, e := range edges {
+		if l.edges[e] == nil {
+			l.edges[e] = make(map[string]bool)
+		}
+		l.edges[e][timestamp] = true
+	}
+	l.mu.Unlock()
+}
+
+// extractLinks collects the anchors, script sources, form actions, and
+// iframes in an archived page, undoes the Wayback Machine's rewriting,
+// and stores the resulting links from source, the page's original URL,
+// under the snapshot's timestamp.
+func (g *ghost) extractLinks(page []byte, timestamp, source string) {
+	base, err := url.Parse(source)
+	if err != nil {
+		g.errorLog.Printf("extractLinks: %v\n", err)
+		return
+	}
+
+	// the Wayback Machine injects its own scripts at the top of the page
+	// and a toolbar at the top of the body, both marked by comments
+	skipping := bytes.Contains(page, []byte("End Wayback Rewrite JS Include"))
+
+	seen := make(map[linkEdge]bool)
+	var edges []linkEdge
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt == html.CommentToken {
+			switch c := string(z.Text()); {
+			case strings.Contains(c, "BEGIN WAYBACK TOOLBAR INSERT"):
+				skipping = true
+			case strings.Contains(c, "END WAYBACK TOOLBAR INSERT"), strings.Contains(c, "End Wayback Rewrite JS Include"):
+				skipping = false
+			}
+			continue
+		}
+		if skipping || (tt != html.StartTagToken && tt != html.SelfClosingTagToken) {
+			continue
+		}
+		tok := z.Token()
+		want, ok := linkAttrs[tok.Data]
+		if !ok {
+			continue
+		}
+		for _, a := range tok.Attr {
+			if a.Key != want {
+				continue
+			}
+			target, ok := resolveLink(base, a.Val)
+			if !ok {
+				continue
+			}
+			e := linkEdge{source: source, target: target, kind: tok.Data}
+			if !seen[e] {
+				seen[e] = true
+				edges = append(edges, e)
+			}
+		}
+	}
+
+	g.infoLog.Printf("Found %d link(s) in %s.\n", len(edges), timestamp)
+	g.links.store(source, timestamp, edges)
+}
+
+// resolveLink strips any Wayback Machine prefix from link and resolves
+// it against base. Links to the archive itself (the toolbar and its
+// assets) along with javascript:, mailto:, and fragment-only links are
+// dropped.
+func resolveLink(base *url.URL, link string) (string, bool) {
+	link = strings.TrimSpace(link)
+	if link == "" || strings.HasPrefix(link, "#") {
+		return "", false
+	}
+	link = archivePrefix.ReplaceAllString(link, "")
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", false
+	}
+	u = base.ResolveReference(u)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	if host := u.Hostname(); host == "archive.org" || strings.HasSuffix(host, ".archive.org") {
+		return "", false
+	}
+	u.Fragment = ""
+	return u.String(), true
+}
+
+// linkPeriod is a span of snapshots in which a link was present.
+// Disappeared is the first snapshot without the link, or empty if the
+// link was in the latest snapshot.
+type linkPeriod struct {
+	Appeared    string `json:"appeared"`
+	Disappeared string `json:"disappeared,omitempty"`
+}
+
+// linkRecord is a link along with its history.
+type linkRecord struct {
+	Source    string       `json:"source"`
+	Target    string       `json:"target"`
+	Kind      string       `json:"kind"`
+	FirstSeen string       `json:"first_seen"`
+	LastSeen  string       `json:"last_seen"`
+	Snapshots int          `json:"snapshots"`
+	Periods   []linkPeriod `json:"periods"`
+}
+
+// linkHistory is the result written to links.json.
+type linkHistory struct {
+	Snapshots []string     `json:"snapshots"`
+	Nodes     []string     `json:"nodes"`
+	Links     []linkRecord `json:"links"`
+}
+
+// history computes when each link appeared and disappeared across the
+// snapshots of its source page that were successfully fetched.
+func (l *linkGraph) history() linkHistory {
+	var h linkHistory
+	pages := make(map[string][]string)
+	all := make(map[string]bool)
+	for page, seen := range l.snapshots {
+		for ts := range seen {
+			pages[page] = append(pages[page], ts)
+			all[ts] = true
+		}
+		sort.Strings(pages[page])
+	}
+	for ts := range all {
+		h.Snapshots = append(h.Snapshots, ts)
+	}
+	sort.Strings(h.Snapshots)
+
+	nodes := make(map[string]bool)
+	for e, seen := range l.edges {
+		nodes[e.source] = true
+		nodes[e.target] = true
+		r := linkRecord{Source: e.source, Target: e.target, Kind: e.kind, Snapshots: len(seen)}
+		present := false
+		for _, ts := range pages[e.source] {
+			switch {
+			case seen[ts] && !present:
+				r.Periods = append(r.Periods, linkPeriod{Appeared: ts})
+				if r.FirstSeen == "" {
+					r.FirstSeen = ts
+				}
+			case !seen[ts] && present:
+				r.Periods[len(r.Periods)-1].Disappeared = ts
+			}
+			if seen[ts] {
+				r.LastSeen = ts
+			}
+			present = seen[ts]
+		}
+		h.Links = append(h.Links, r)
+	}
+	for n := range nodes {
+		h.Nodes = append(h.Nodes, n)
+	}
+	sort.Strings(h.Nodes)
+	sort.Slice(h.Links, func(i, j int) bool {
+		a, b := h.Links[i], h.Links[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Kind < b.Kind
+	})
+	return h
+}
+
+// GraphML document structure, see http://graphml.graphdrawing.org.
+type (
+	graphML struct {
+		XMLName xml.Name     `xml:"graphml"`
+		XMLNS   string       `xml:"xmlns,attr"`
+		Keys    []graphMLKey `xml:"key"`
+		Graph   graphMLGraph `xml:"graph"`
+	}
+	graphMLKey struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	graphMLGraph struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	}
+	graphMLNode struct {
+		ID   string        `xml:"id,attr"`
+		Data []graphMLData `xml:"data"`
+	}
+	graphMLEdge struct {
+		ID     string        `xml:"id,attr"`
+		Source string        `xml:"source,attr"`
+		Target string        `xml:"target,attr"`
+		Data   []graphMLData `xml:"data"`
+	}
+	graphMLData struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+)
+
+// graphML converts the link history into a GraphML document. Node ids
+// are indexes into h.Nodes, with the URL stored as a node attribute.
+func (h linkHistory) graphML() graphML {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "url", For: "node", Name: "url", Type: "string"},
+			{ID: "kind", For: "edge", Name: "kind", Type: "string"},
+			{ID: "first_seen", For: "edge", Name: "first_seen", Type: "string"},
+			{ID: "last_seen", For: "edge", Name: "last_seen", Type: "string"},
+			{ID: "snapshots", For: "edge", Name: "snapshots", Type: "int"},
+			{ID: "periods", For: "edge", Name: "periods", Type: "string"},
+		},
+		Graph: graphMLGraph{ID: "links", EdgeDefault: "directed"},
+	}
+
+	ids := make(map[string]string)
+	for i, n := range h.Nodes {
+		id := "n" + strconv.Itoa(i)
+		ids[n] = id
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID:   id,
+			Data: []graphMLData{{Key: "url", Value: n}},
+		})
+	}
+	for i, l := range h.Links {
+		var periods []string
+		for _, p := range l.Periods {
+			periods = append(periods, p.Appeared+"-"+p.Disappeared)
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			ID:     "e" + strconv.Itoa(i),
+			Source: ids[l.Source],
+			Target: ids[l.Target],
+			Data: []graphMLData{
+				{Key: "kind", Value: l.Kind},
+				{Key: "first_seen", Value: l.FirstSeen},
+				{Key: "last_seen", Value: l.LastSeen},
+				{Key: "snapshots", Value: strconv.Itoa(l.Snapshots)},
+				{Key: "periods", Value: strings.Join(periods, " ")},
+			},
+		})
+	}
+	return doc
+}
+
+// linkGraphWriter writes the link history to JSON and GraphML files.
+func (g *ghost) linkGraphWriter() {
+	h := g.links.history()
+	g.infoLog.Printf("Found %d distinct link(s) across %d snapshot(s).\n", len(h.Links), len(h.Snapshots))
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		g.errorLog.Printf("Marshal error: %v\n", err)
+		return
+	}
+	g.writeData("links.json", b)
+
+	b, err = xml.MarshalIndent(h.graphML(), "", "  ")
+	if err != nil {
+		g.errorLog.Printf("GraphML marshal error: %v\n", err)
+		return
+	}
+	g.writeData("links.graphml", append([]byte(xml.Header), b...))
+}
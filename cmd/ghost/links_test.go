@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinkGraphPages(t *testing.T) {
+	g := quietGhost(config{})
+	a := `<html><body><a href="/b">b</a><script src="https://web.archive.org/web/20200101000000js_/https://cdn.example.net/app.js"></script></body></html>`
+	b := `<html><body><a href="https://web.archive.org/web/20200101000000/https://example.com/a">a</a></body></html>`
+	bWithout := `<html><body><p>no links</p></body></html>`
+
+	g.extractLinks([]byte(a), "20200101000000", "https://example.com/a")
+	g.extractLinks([]byte(b), "20200102000000", "https://example.com/b")
+	g.extractLinks([]byte(a), "20200103000000", "https://example.com/a")
+	g.extractLinks([]byte(bWithout), "20200104000000", "https://example.com/b")
+
+	h := g.links.history()
+	if want := []string{"https://cdn.example.net/app.js", "https://example.com/a", "https://example.com/b"}; !reflect.DeepEqual(h.Nodes, want) {
+		t.Errorf("nodes = %q, want %q", h.Nodes, want)
+	}
+	want := []linkRecord{
+		{
+			Source: "https://example.com/a", Target: "https://cdn.example.net/app.js", Kind: "script",
+			FirstSeen: "20200101000000", LastSeen: "20200103000000", Snapshots: 2,
+			Periods: []linkPeriod{{Appeared: "20200101000000"}},
+		},
+		{
+			Source: "https://example.com/a", Target: "https://example.com/b", Kind: "a",
+			FirstSeen: "20200101000000", LastSeen: "20200103000000", Snapshots: 2,
+			Periods: []linkPeriod{{Appeared: "20200101000000"}},
+		},
+		{
+			Source: "https://example.com/b", Target: "https://example.com/a", Kind: "a",
+			FirstSeen: "20200102000000", LastSeen: "20200102000000", Snapshots: 1,
+			Periods: []linkPeriod{{Appeared: "20200102000000", Disappeared: "20200104000000"}},
+		},
+	}
+	if !reflect.DeepEqual(h.Links, want) {
+		t.Errorf("links:\ngot  %+v\nwant %+v", h.Links, want)
+	}
+
+	// the two pages link to each other in the GraphML too
+	doc := h.graphML()
+	edges := make(map[[2]string]bool)
+	for _, e := range doc.Graph.Edges {
+		edges[[2]string{e.Source, e.Target}] = true
+	}
+	if !edges[[2]string{"n1", "n2"}] || !edges[[2]string{"n2", "n1"}] {
+		t.Errorf("graphml edges = %+v", doc.Graph.Edges)
+	}
+}
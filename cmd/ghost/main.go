@@ -1,25 +1,62 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"sync"
 	"time"
 )
 
 type config struct {
-	filters filters
-	gophers int
-	regex   string
-	term    string
-	terms   string
-	timeout int
-	url     string
+	asnDB           string
+	diff            diffOptions
+	dns             bool
+	evidence        bool
+	filters         filters
+	format          string
+	formats         []string
+	gophers         int
+	interestingFile string
+	js              bool
+	jsLimit         int
+	keyFile         string
+	links           bool
+	list            string
+	maps            bool
+	mmdb            string
+	output          outputOptions
+	passive         bool
+	pivot           bool
+	pslRefresh      bool
+	rdap            bool
+	rdapRefresh     bool
+	regex           string
+	report          bool
+	robots          bool
+	robotsCDX       bool
+	resolver        string
+	rules           string
+	signingKey      ed25519.PrivateKey
+	sitemaps        bool
+	subLimit        int
+	subdomains      bool
+	targetLimit     int
+	term            string
+	terms           string
+	timeout         int
+	url             string
+	wellKnown       bool
+	wellKnownFile   string
+	whoisServer     string
 }
 
 type filters struct {
+	collapse      string
 	domain        string
 	from          string
 	host          string
@@ -33,147 +70,401 @@ type filters struct {
 }
 
 type ghost struct {
-	config   config
-	errorLog *log.Logger
-	infoLog  *log.Logger
-	query    interface{}
-	searches *searchMap
+	assetResults *assetReport
+	client       *http.Client
+	config       config
+	errorCount   *errorCounter
+	errorLog     *log.Logger
+	evidence     *evidenceLog
+	guard        *guard
+	infoLog      *log.Logger
+	links        *linkGraph
+	out          *output
+	panics       *panicLog
+	psl          *suffixList
+	query        interface{}
+	rdap         *rdapReport
+	requests     *requestLog
+	ruleMatches  *ruleMatchMap
+	searches     *searchMap
+	tables       *tableSet
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "timeline":
+			runTimeline(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		}
+	}
+
 	var config config
+	flag.StringVar(&config.asnDB, "asndb", "", "name of IP-to-ASN TSV file (iptoasn.com layout, optionally gzipped) for enriching the target's IP addresses.")
+	flag.BoolVar(&config.dns, "dns", false, "enumerate the target's A, AAAA, CNAME, MX, NS, TXT, SOA, and CAA records.")
+	flag.BoolVar(&config.evidence, "evidence", false, "store the raw bytes of every capture fetched, check them against their CDX digests, and keep a hash-chained log of them.")
+	flag.StringVar(&config.format, "format", "json", "comma-separated output formats: json, jsonl, csv, sqlite, and md. json is always written (default is 'json').")
 	flag.IntVar(&config.gophers, "g", 10, "number of goroutines (default is 10).")
+	flag.StringVar(&config.interestingFile, "ipatterns", "", "name of file containing additional patterns for flagging interesting URLs.")
+	flag.BoolVar(&config.js, "js", false, "extract endpoints from archived JavaScript files.")
+	flag.IntVar(&config.jsLimit, "jsl", 500, "maximum number of JavaScript and source map captures to fetch (default is 500).")
+	flag.StringVar(&config.keyFile, "key", "", "name of file containing an Ed25519 private key (PKCS #8 PEM, or a hex or base64 seed) for signing each run's manifest.")
+	flag.BoolVar(&config.links, "links", false, "extract links from each snapshot and save the link graph.")
+	flag.StringVar(&config.list, "list", "", "name of file containing target URLs, one per line (default is -u, or stdin).")
+	flag.BoolVar(&config.maps, "maps", false, "rebuild original sources from archived source maps.")
+	flag.StringVar(&config.mmdb, "mmdb", "", "comma-separated names of MaxMind DB files (GeoLite2 ASN, Country, City, and the like) for enriching the target's IP addresses.")
+	flag.BoolVar(&config.passive, "passive", false, "refuse connections to the target's hosts and write an audit log.")
+	flag.BoolVar(&config.pivot, "pivot", false, "search the archive for other hosts named like the target's reverse DNS (PTR) names.")
+	flag.BoolVar(&config.pslRefresh, "psl-refresh", false, "download the current Public Suffix List before working out the target's domain.")
+	flag.BoolVar(&config.rdap, "rdap", false, "look up the domain and its IP addresses with RDAP, falling back to whois.")
+	flag.BoolVar(&config.rdapRefresh, "rdap-refresh", false, "download the current RDAP bootstrap files from IANA before looking anything up.")
 	flag.StringVar(&config.regex, "regex", "", "regex pattern for parsing search results.")
+	flag.BoolVar(&config.report, "report", false, "write a self-contained HTML report of the run to report.html.")
+	flag.BoolVar(&config.robots, "robots", false, "parse every archived version of robots.txt.")
+	flag.BoolVar(&config.robotsCDX, "robotscdx", false, "search the archive for captures of disallowed paths (implies -robots).")
+	flag.StringVar(&config.resolver, "resolver", "", "resolver for -dns and reverse DNS lookups: udp://, tcp://, or tls:// host[:port], or an https:// DNS-over-HTTPS URL (default is the system resolver).")
+	flag.StringVar(&config.rules, "rules", "", "name of file containing YARA-style rules for scanning snapshots and every other archived file fetched.")
+	flag.BoolVar(&config.sitemaps, "sitemaps", false, "parse every archived sitemap, following sitemap indexes.")
+	flag.BoolVar(&config.subdomains, "subdomains", false, "list the hosts under the target's domain seen in the archive.")
+	flag.IntVar(&config.subLimit, "subl", 100000, "maximum number of URLs to list when finding subdomains or pivoting (default is 100000).")
+	flag.IntVar(&config.targetLimit, "tc", 1, "number of targets to process at once (default is 1).")
 	flag.StringVar(&config.term, "term", "", "term for parsing search results.")
 	flag.StringVar(&config.terms, "terms", "", "name of file containing term list for parsing search results.")
 	flag.IntVar(&config.timeout, "time", 5000, "timeout in milliseconds (default is 5000).")
 	flag.StringVar(&config.url, "u", "", "url for searching")
+	flag.StringVar(&config.whoisServer, "whois-server", "", "whois server to start lookups at (default is whois.iana.org).")
+	flag.BoolVar(&config.wellKnown, "wellknown", false, "check the archive for security.txt, .well-known/* and other well-known files.")
+	flag.StringVar(&config.wellKnownFile, "wkfile", "", "name of file containing additional well-known paths to check.")
 
-	// filtering Wayback Machine results
-	flag.StringVar(&config.filters.from, "f", "", "search from here, including at least a year. format more specific queries as yyyyMMddhhmmss.")
-	flag.StringVar(&config.filters.limit, "l", "0", "limit query results, using -1, -2, -3 etc. for most recent, 1, 2, 3 etc. for oldest.")
-	flag.StringVar(&config.filters.mimetype, "m", "text/html", "filter results according to mimetype (default is 'text/html').")
-	flag.StringVar(&config.filters.notMimetype, "nm", "", "filter specified mimetype out of results (inactive by default).")
-	flag.StringVar(&config.filters.notStatusCode, "ns", "0", "filter specified status code out of results (inactive by default).")
-	flag.StringVar(&config.filters.statuscode, "s", "200", "filter results by status code (default is 200).")
-	flag.StringVar(&config.filters.to, "t", "", "search to here, including at least a year. format more specific queries as yyyyMMddhhmmss.")
-
-	// matchType
-	flag.StringVar(&config.filters.domain, "domain", "", "return results from host and all subhosts.")
-	flag.StringVar(&config.filters.host, "host", "", "return results from host.")
-	flag.StringVar(&config.filters.prefix, "prefix", "", "return results for all results under the path.")
+	filterFlags(flag.CommandLine, &config.filters)
+	outputFlags(flag.CommandLine, &config.output)
 
 	flag.Parse()
 
 	start := time.Now()
 
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ltime|log.Lshortfile)
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ltime)
-	searches := newSearchMap()
+	g := newGhost(config)
 
-	g := &ghost{
-		config:   config,
-		errorLog: errorLog,
-		infoLog:  infoLog,
-		searches: searches,
+	formats, err := parseFormats(config.format)
+	if err != nil {
+		g.errorLog.Fatal(err)
 	}
+	g.config.formats = formats
 
-	if config.url != "" {
-		g.validateURL(config.url)
-	} else {
-		g.getInputURL()
+	if config.evidence && config.output.append {
+		g.errorLog.Fatal("-evidence can't be used with -append")
+	}
+	if config.rules != "" && (config.regex != "" || config.term != "" || config.terms != "") {
+		g.errorLog.Fatal("-rules can't be used with -regex, -term, or -terms")
+	}
+	if config.keyFile != "" {
+		data, err := os.ReadFile(config.keyFile)
+		if err != nil {
+			g.errorLog.Fatal(err)
+		}
+		g.config.signingKey, err = parsePrivateKey(data)
+		if err != nil {
+			g.errorLog.Fatalf("unable to read %s: %v", config.keyFile, err)
+		}
+	} else if config.evidence {
+		g.infoLog.Println("No -key given: manifests won't be signed.")
 	}
 
-	var wg sync.WaitGroup
-
-	err := os.Mkdir("data", 0755)
+	targets, err := g.getTargets()
 	if err != nil {
-		g.errorLog.Fatalf("unable to make data folder: %v", err)
+		g.errorLog.Fatal(err)
+	}
+
+	if config.pslRefresh {
+		g.refreshSuffixList(config.timeout)
+	}
+	if config.rdapRefresh {
+		g.refreshBootstrap(config.timeout)
+	}
+
+	results := g.runTargets(targets)
+	if len(targets) > 1 {
+		g.summaryWriter(results, start)
+	}
+	for _, r := range results {
+		if r.Status != "ok" {
+			os.Exit(1)
+		}
 	}
+	// as before, a run without a query exits once the snapshots are saved
+	if config.rules == "" && config.regex == "" && config.terms == "" && config.term == "" && !config.links {
+		os.Exit(1)
+	}
+}
+
+// run looks up a single target, writing everything it finds to the
+// target's run directory, and returns the number of snapshots found.
+func (g *ghost) run(start time.Time) (int, error) {
+	config := g.config
+	var wg sync.WaitGroup
 
 	host, err := g.getHost(g.config.url)
 	if err != nil {
 		g.errorLog.Printf("getHost error: %v\n", err)
-	} else {
-		wg.Add(1)
-		go g.getIP(&wg, host)
 	}
-
 	domain, err := g.getDomain(g.config.url)
 	if err != nil {
 		g.errorLog.Printf("getDomain error: %v\n", err)
-	} else {
+	}
+	err = g.setOutput(start)
+	if err != nil {
+		return 0, fmt.Errorf("unable to make output directory: %w", err)
+	}
+
+	if config.passive {
+		if host == "" && domain == "" {
+			g.auditLogWriter()
+			return 0, errors.New("passive mode: unable to determine the target's host")
+		}
+		g.guard.blockTarget(host, domain)
+	}
+
+	if host != "" {
+		if config.passive {
+			// resolving the target would query its nameservers
+			g.infoLog.Println("Passive mode: skipping IP lookup.")
+		} else {
+			wg.Add(1)
+			g.goSafe(func() {
+				defer wg.Done()
+				ips := g.getIP(host)
+				g.enrichIPs(ips)
+				g.reverseDNS(ips, domain, config.timeout)
+				if config.rdap {
+					g.rdapIPs(ips, config.timeout)
+				}
+			})
+		}
+	}
+
+	if config.dns && (host != "" || domain != "") {
+		if config.passive {
+			g.infoLog.Println("Passive mode: skipping DNS enumeration.")
+		} else {
+			wg.Add(1)
+			g.goSafe(func() { g.dnsLookup(&wg, host, domain, config.timeout) })
+		}
+	}
+
+	if domain != "" {
 		wg.Add(1)
-		go g.whoisLookup(&wg, domain, config.timeout)
+		if config.rdap {
+			g.goSafe(func() { g.rdapDomain(&wg, domain, config.timeout) })
+		} else {
+			g.goSafe(func() { g.whoisLookup(&wg, domain, config.timeout) })
+		}
 	}
 
-	validQuery := g.getQuery()
+	validQuery, err := g.getQuery()
+	if err != nil {
+		wg.Wait() // let resource gathering finish
+		g.auditLogWriter()
+		return 0, err
+	}
 	u := g.formURL(g.config.url, config.filters)
 	g.infoLog.Printf("Wayback Machine URL: %s\n", u)
 
-	// check Wayback Machine for robots.txt
-	wg.Add(1)
-	go g.checkAsset(&wg, g.config.url, "data/robots.txt", config.timeout)
-
-	// check Wayback Machine for sitemap.xml
-	wg.Add(1)
-	go g.checkAsset(&wg, g.config.url, "data/sitemap.xml", config.timeout)
+	// check Wayback Machine for robots.txt, sitemap.xml, and any other
+	// well-known files
+	assets, err := g.assets()
+	if err != nil {
+		wg.Wait() // let resource gathering finish
+		g.auditLogWriter()
+		return 0, err
+	}
+	for _, asset := range assets {
+		asset := asset
+		wg.Add(1)
+		g.goSafe(func() { g.checkAsset(&wg, g.config.url, asset, config.timeout) })
+	}
 
 	// get all archived URLs for given URL prefix
 	wg.Add(1)
-	go g.archivedURLs(&wg, g.config.url, config.timeout)
+	g.goSafe(func() { g.archivedURLs(&wg, g.config.url, config.timeout) })
+
+	// get every archived version of robots.txt
+	if config.robots || config.robotsCDX {
+		wg.Add(1)
+		g.goSafe(func() { g.robotsHistory(&wg, g.config.url, config.timeout) })
+	}
+
+	// get every archived sitemap
+	if config.sitemaps && domain != "" {
+		wg.Add(1)
+		g.goSafe(func() { g.sitemapHistory(&wg, domain, config.timeout) })
+	}
+
+	// get every host under the domain
+	if config.subdomains && domain != "" {
+		wg.Add(1)
+		g.goSafe(func() { g.subdomains(&wg, domain, config.timeout) })
+	}
+
+	// check Wayback Machine for JavaScript files and source maps
+	if (config.js || config.maps) && domain != "" {
+		wg.Add(1)
+		g.goSafe(func() { g.scanJS(&wg, domain, config.timeout) })
+	}
 
 	// check Wayback Machine for snapshots
 	body, err := g.getData(u, config.timeout)
 	if err != nil {
 		wg.Wait() // let resource gathering finish
-		g.errorLog.Fatal(err)
+		g.auditLogWriter()
+		return 0, err
 	}
 
 	// also saves the snaps to a .json file
 	snaps, err := g.getSnaps(body)
 	if err != nil {
 		wg.Wait() // let resource gathering finish
-		g.errorLog.Fatal(err)
+		g.auditLogWriter()
+		return 0, err
 	}
 
 	// wait here in case of early exit cause no query
 	wg.Wait()
 
-	if !validQuery {
-		g.infoLog.Println("Snapshots retrieved and saved to file. Exiting...")
+	if config.wellKnown || config.wellKnownFile != "" {
+		g.assetReportWriter()
+	}
+
+	if config.rdap {
+		g.rdapWriter()
+	}
+
+	if !validQuery && !config.links {
+		g.formatWriter()
+		if config.report {
+			g.reportWriter(start, snaps)
+		}
+		g.auditLogWriter()
+		g.infoLog.Println("Snapshots retrieved and saved to file.")
 		g.infoLog.Printf("Took: %f seconds\n", time.Since(start).Seconds())
-		os.Exit(1)
+		return len(snaps), nil
 	}
 
-	// extract timestamps from snaps
-	var filteredSnaps []string
+	// each capture is fetched (and its links sourced) from the URL it was
+	// archived under, which differs from the target's with -prefix,
+	// -host, or -domain
+	var captures []archivedFile
 	for _, v := range snaps {
-		filteredSnaps = append(filteredSnaps, v[1])
+		c := archivedFile{Timestamp: v[1], Original: g.config.url}
+		if len(v) > 2 {
+			c.Original = v[2]
+		}
+		captures = append(captures, c)
 	}
 
 	tokens := make(chan struct{}, config.gophers)
 
-	for _, timestamp := range filteredSnaps {
+	for _, c := range captures {
 		tokens <- struct{}{}
 		wg.Add(1)
-		go func(t string) {
+		c := c
+		g.goSafe(func() {
 			defer wg.Done()
-			url := fmt.Sprintf("https://web.archive.org/web/%s/%s", t, g.config.url)
-			page, err := g.getData(url, config.timeout)
+			url := fmt.Sprintf("https://web.archive.org/web/%s/%s", c.Timestamp, c.Original)
+			fetch := url
+			if config.evidence {
+				// keep the capture as archived, not as the Wayback
+				// Machine rewrites it for display
+				fetch = c.rawURL()
+			}
+			page, err := g.getData(fetch, config.timeout)
 			if err != nil {
 				g.errorLog.Printf("getData error for %s: %v\n", url, err)
 				<-tokens
 				return
 			}
 			<-tokens
-			g.parsePage(string(page), url, g.query)
-		}(timestamp)
+			if config.links {
+				g.extractLinks(page, c.Timestamp, c.Original)
+			}
+			if validQuery {
+				g.parsePage(string(page), url, g.query)
+			}
+		})
 	}
 
 	wg.Wait()
 
-	g.searchMapWriter(g.query, g.searches.searches)
+	if config.links {
+		g.linkGraphWriter()
+	}
+
+	if _, ok := g.query.(*ruleSet); ok {
+		g.ruleMatchWriter(g.ruleMatches.matches)
+	} else if validQuery {
+		g.searchMapWriter(g.query, g.searches.searches)
+	}
+
+	g.formatWriter()
+	if config.report {
+		g.reportWriter(start, snaps)
+	}
+	g.auditLogWriter()
 
 	g.infoLog.Printf("Took: %f seconds\n", time.Since(start).Seconds())
+	return len(snaps), nil
+}
+
+// filterFlags registers the flags for filtering Wayback Machine
+// results on fs.
+func filterFlags(fs *flag.FlagSet, f *filters) {
+	// filtering Wayback Machine results
+	fs.StringVar(&f.collapse, "collapse", "digest", "collapse adjacent results with the same value for this field (default is 'digest').")
+	fs.StringVar(&f.from, "f", "", "search from here, including at least a year. format more specific queries as yyyyMMddhhmmss.")
+	fs.StringVar(&f.limit, "l", "0", "limit query results, using -1, -2, -3 etc. for most recent, 1, 2, 3 etc. for oldest.")
+	fs.StringVar(&f.mimetype, "m", "text/html", "filter results according to mimetype (default is 'text/html').")
+	fs.StringVar(&f.notMimetype, "nm", "", "filter specified mimetype out of results (inactive by default).")
+	fs.StringVar(&f.notStatusCode, "ns", "0", "filter specified status code out of results (inactive by default).")
+	fs.StringVar(&f.statuscode, "s", "200", "filter results by status code (default is 200).")
+	fs.StringVar(&f.to, "t", "", "search to here, including at least a year. format more specific queries as yyyyMMddhhmmss.")
+
+	// matchType
+	fs.StringVar(&f.domain, "domain", "", "return results from host and all subhosts.")
+	fs.StringVar(&f.host, "host", "", "return results from host.")
+	fs.StringVar(&f.prefix, "prefix", "", "return results for all results under the path.")
+}
+
+// newGhost returns a pointer to a ghost with its loggers, guarded HTTP
+// client, and result stores set up.
+func newGhost(config config) *ghost {
+	guard := newGuard(config.passive)
+	requests := &requestLog{}
+	errorCount := &errorCounter{w: os.Stderr}
+	var evidence *evidenceLog
+	if config.evidence {
+		evidence = newEvidenceLog()
+	}
+	return &ghost{
+		assetResults: &assetReport{},
+		client:       newClient(guard, requests),
+		config:       config,
+		errorCount:   errorCount,
+		errorLog:     log.New(errorCount, "ERROR\t", log.Ltime|log.Lshortfile),
+		evidence:     evidence,
+		guard:        guard,
+		infoLog:      log.New(os.Stdout, "INFO\t", log.Ltime),
+		links:        newLinkGraph(),
+		panics:       &panicLog{},
+		psl:          loadSuffixList(),
+		rdap:         &rdapReport{},
+		requests:     requests,
+		ruleMatches:  newRuleMatchMap(),
+		searches:     newSearchMap(),
+		tables:       &tableSet{},
+	}
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// buildVersion is ghost's version, set at build time with
+// -ldflags "-X main.buildVersion=v1.2.3". Without it, the module version
+// or VCS revision from the build info is used.
+var buildVersion string
+
+// ghostVersion returns the version recorded in manifests.
+func ghostVersion() string {
+	if buildVersion != "" {
+		return buildVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return "devel-" + s.Value
+		}
+	}
+	return "devel"
+}
+
+// requestRecord is a single request ghost made: an HTTP request, a whois
+// query, or a DNS query. SHA256 is the hash of the whole response body,
+// and is left out if the body wasn't read to the end.
+type requestRecord struct {
+	Method string    `json:"method"`
+	URL    string    `json:"url"`
+	Time   time.Time `json:"time"`
+	Status int       `json:"status,omitempty"`
+	Bytes  int64     `json:"bytes"`
+	SHA256 string    `json:"sha256,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// requestLog is a mutex-protected list of the requests made during a run.
+type requestLog struct {
+	mu      sync.Mutex
+	records []*requestRecord
+}
+
+// start adds a request to the log and returns it, to be filled in with
+// finish.
+func (l *requestLog) start(method, url string) *requestRecord {
+	r := &requestRecord{Method: method, URL: url, Time: time.Now().UTC()}
+	l.mu.Lock()
+	l.records = append(l.records, r)
+	l.mu.Unlock()
+	return r
+}
+
+// finish records the outcome of a request.
+func (l *requestLog) finish(r *requestRecord, status int, body []byte, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Status = status
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+	sum := sha256.Sum256(body)
+	r.Bytes = int64(len(body))
+	r.SHA256 = hex.EncodeToString(sum[:])
+}
+
+// failed returns the number of requests that errored or got an error
+// status.
+func (l *requestLog) failed() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var n int
+	for _, r := range l.records {
+		if r.Error != "" || r.Status >= http.StatusBadRequest {
+			n++
+		}
+	}
+	return n
+}
+
+// recordingTransport logs every HTTP request, including redirects and
+// requests the guard refuses, along with a hash of each response body.
+type recordingTransport struct {
+	base http.RoundTripper
+	log  *requestLog
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := t.log.start(req.Method, req.URL.String())
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.log.finish(r, 0, nil, err)
+		return nil, err
+	}
+	t.log.mu.Lock()
+	r.Status = resp.StatusCode
+	t.log.mu.Unlock()
+	resp.Body = &hashingBody{ReadCloser: resp.Body, hash: sha256.New(), record: r, log: t.log}
+	return resp, nil
+}
+
+// hashingBody hashes a response body as it's read, filling in its
+// request's record once the whole body has been read.
+type hashingBody struct {
+	io.ReadCloser
+	hash   hash.Hash
+	n      int64
+	record *requestRecord
+	log    *requestLog
+	done   bool
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	b.n += int64(n)
+	if err == io.EOF && !b.done {
+		b.done = true
+		b.log.mu.Lock()
+		b.record.Bytes = b.n
+		b.record.SHA256 = hex.EncodeToString(b.hash.Sum(nil))
+		b.log.mu.Unlock()
+	} else if err != nil && err != io.EOF && !b.done {
+		b.done = true
+		b.log.mu.Lock()
+		b.record.Bytes = b.n
+		b.record.Error = err.Error()
+		b.log.mu.Unlock()
+	}
+	return n, err
+}
+
+func (b *hashingBody) Close() error {
+	if !b.done {
+		b.done = true
+		b.log.mu.Lock()
+		b.record.Bytes = b.n
+		b.log.mu.Unlock()
+	}
+	return b.ReadCloser.Close()
+}
+
+// errorCounter counts the lines written to the error log.
+type errorCounter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *errorCounter) Write(p []byte) (int, error) {
+	c.n.Add(1)
+	return c.w.Write(p)
+}
+
+// manifestFile is a file written during the run.
+type manifestFile struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifest records how a run directory came to be: the ghost build and
+// arguments that produced it, when it ran, every request made, and a hash
+// of every file written.
+type manifest struct {
+	Version   string           `json:"ghost_version"`
+	GoVersion string           `json:"go_version"`
+	Args      []string         `json:"args"`
+	URL       string           `json:"url"`
+	Dir       string           `json:"dir"`
+	Started   time.Time        `json:"started"`
+	Finished  time.Time        `json:"finished"`
+	Seconds   float64          `json:"seconds"`
+	Status    string           `json:"status"`
+	Error     string           `json:"error,omitempty"`
+	Snapshots int              `json:"snapshots"`
+	Requests  []*requestRecord `json:"requests"`
+	Files     []manifestFile   `json:"files"`
+	Evidence  *evidenceSummary `json:"evidence,omitempty"`
+	Errors    struct {
+		Logged   int64 `json:"logged"`
+		Requests int   `json:"requests"`
+	} `json:"errors"`
+}
+
+// manifestWriter writes manifest.json to the run directory, last, so
+// every other file is in it. failure is the reason the run failed, if it
+// did. In evidence mode the evidence log is written first, and with -key
+// the finished manifest is signed.
+func (g *ghost) manifestWriter(start time.Time, snapshots int, failure string) {
+	if g.out == nil {
+		return
+	}
+	finished := time.Now()
+	m := manifest{
+		Version:   ghostVersion(),
+		GoVersion: runtime.Version(),
+		Args:      os.Args[1:],
+		URL:       g.config.url,
+		Dir:       g.out.dir,
+		Started:   start.UTC(),
+		Finished:  finished.UTC(),
+		Seconds:   finished.Sub(start).Seconds(),
+		Status:    "ok",
+		Error:     failure,
+		Snapshots: snapshots,
+	}
+	if failure != "" {
+		m.Status = "failed"
+	}
+
+	g.requests.mu.Lock()
+	for _, r := range g.requests.records {
+		c := *r
+		m.Requests = append(m.Requests, &c)
+	}
+	g.requests.mu.Unlock()
+	sort.SliceStable(m.Requests, func(i, j int) bool { return m.Requests[i].Time.Before(m.Requests[j].Time) })
+	m.Errors.Requests = g.requests.failed()
+	if g.evidence != nil {
+		m.Evidence = g.evidenceWriter()
+	}
+	m.Errors.Logged = g.errorCount.n.Load()
+
+	for _, name := range g.out.written() {
+		b, err := os.ReadFile(g.out.path(name))
+		if err != nil {
+			g.errorLog.Printf("unable to hash %s: %v\n", name, err)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		m.Files = append(m.Files, manifestFile{Name: name, Bytes: int64(len(b)), SHA256: hex.EncodeToString(sum[:])})
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(m)
+	if err != nil {
+		g.errorLog.Printf("manifest marshal error: %v\n", err)
+		return
+	}
+	// the manifest describes this run alone, so it's never merged
+	g.writeFileAt(g.out.path("manifest.json"), buf.Bytes())
+	if g.config.signingKey != nil {
+		g.signManifest(buf.Bytes())
+	}
+}
+
+// fatal writes the manifest for a failed run and exits.
+func (g *ghost) fatal(start time.Time, err error) {
+	g.errorLog.Output(2, err.Error())
+	g.manifestWriter(start, 0, err.Error())
+	os.Exit(1)
+}
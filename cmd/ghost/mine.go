@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxParamValues caps the number of distinct values kept per query
+// parameter in endpoints.json.
+const maxParamValues = 50
+
+// endpoint is a scheme, host, and path seen in the archived URLs,
+// along with the query parameters used with it.
+type endpoint struct {
+	Endpoint  string   `json:"endpoint"`
+	Count     int      `json:"count"`
+	Params    []string `json:"params,omitempty"`
+	Mimetypes []string `json:"mimetypes,omitempty"`
+	First     string   `json:"first"`
+	Last      string   `json:"last"`
+
+	params    map[string]bool
+	mimetypes map[string]bool
+}
+
+// paramStat records how often a query parameter appeared and with
+// which values.
+type paramStat struct {
+	Name   string         `json:"name"`
+	Count  int            `json:"count"`
+	Values map[string]int `json:"values"`
+	// Truncated is true if values beyond maxParamValues were dropped.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// counted is a string along with the number of archived URLs it
+// appeared in.
+type counted struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// minedURLs is the result written to endpoints.json.
+type minedURLs struct {
+	Endpoints  []*endpoint  `json:"endpoints"`
+	Params     []*paramStat `json:"params"`
+	Segments   []counted    `json:"segments"`
+	Extensions []counted    `json:"extensions"`
+}
+
+// mineURLs takes in the archivedURLs data, parses each URL into its
+// endpoint, path segments, query parameters, and file extension, and
+// writes the results to params.txt, paths.txt, segments.txt, and
+// endpoints.json.
+func (g *ghost) mineURLs(data []byte) {
+	g.infoLog.Println("Mining URLs.")
+
+	var s [][]string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		g.errorLog.Printf("mineURLs unmarshal error: %v\n", err)
+		return
+	}
+	if len(s) < 2 {
+		return
+	}
+
+	endpoints := make(map[string]*endpoint)
+	params := make(map[string]*paramStat)
+	segments := make(map[string]int)
+	extensions := make(map[string]int)
+	paths := make(map[string]bool)
+
+	// skip the key
+	for _, v := range s[1:] {
+		// original, mimetype, timestamp, endtimestamp, groupcount, uniqcount
+		if len(v) < 4 {
+			continue
+		}
+		u, err := url.Parse(v[0])
+		if err != nil || u.Host == "" {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if port := u.Port(); port != "" && port != "80" && port != "443" {
+			host += ":" + port
+		}
+		p := u.EscapedPath()
+		if p == "" {
+			p = "/"
+		}
+		paths[p] = true
+
+		key := u.Scheme + "://" + host + p
+		e, ok := endpoints[key]
+		if !ok {
+			e = &endpoint{
+				Endpoint:  key,
+				First:     v[2],
+				Last:      v[3],
+				params:    make(map[string]bool),
+				mimetypes: make(map[string]bool),
+			}
+			endpoints[key] = e
+		}
+		e.Count++
+		e.mimetypes[v[1]] = true
+		if v[2] < e.First {
+			e.First = v[2]
+		}
+		if v[3] > e.Last {
+			e.Last = v[3]
+		}
+
+		for _, seg := range strings.Split(strings.Trim(u.Path, "/"), "/") {
+			if seg != "" {
+				segments[seg]++
+			}
+		}
+		if ext := strings.ToLower(path.Ext(u.Path)); ext != "" {
+			extensions[ext]++
+		}
+
+		for name, values := range u.Query() {
+			e.params[name] = true
+			ps, ok := params[name]
+			if !ok {
+				ps = &paramStat{Name: name, Values: make(map[string]int)}
+				params[name] = ps
+			}
+			ps.Count++
+			for _, value := range values {
+				if _, ok := ps.Values[value]; !ok && len(ps.Values) >= maxParamValues {
+					ps.Truncated = true
+					continue
+				}
+				ps.Values[value]++
+			}
+		}
+	}
+
+	var mined minedURLs
+	for _, e := range endpoints {
+		e.Params = sortedKeys(e.params)
+		e.Mimetypes = sortedKeys(e.mimetypes)
+		mined.Endpoints = append(mined.Endpoints, e)
+	}
+	sort.Slice(mined.Endpoints, func(i, j int) bool {
+		return mined.Endpoints[i].Endpoint < mined.Endpoints[j].Endpoint
+	})
+	for _, ps := range params {
+		mined.Params = append(mined.Params, ps)
+	}
+	sort.Slice(mined.Params, func(i, j int) bool {
+		a, b := mined.Params[i], mined.Params[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+	mined.Segments = byCount(segments)
+	mined.Extensions = byCount(extensions)
+
+	g.infoLog.Printf("Found %d endpoint(s) and %d parameter(s).\n", len(mined.Endpoints), len(mined.Params))
+
+	var names []string
+	for _, ps := range mined.Params {
+		names = append(names, ps.Name)
+	}
+	g.writeLines("params.txt", names)
+	g.writeLines("paths.txt", sortedKeys(paths))
+	var segs []string
+	for _, c := range mined.Segments {
+		segs = append(segs, c.Value)
+	}
+	g.writeLines("segments.txt", segs)
+
+	b, err := json.Marshal(mined)
+	if err != nil {
+		g.errorLog.Printf("mineURLs marshal error: %v\n", err)
+		return
+	}
+	g.writeData("endpoints.json", b)
+}
+
+// writeLines writes lines to a file, one per line. Nothing is written
+// if lines is empty.
+func (g *ghost) writeLines(name string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	g.writeData(name, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// byCount converts a map of counts into a slice sorted by descending
+// count, then by value.
+func byCount(m map[string]int) []counted {
+	out := make([]counted, 0, len(m))
+	for v, n := range m {
+		out = append(out, counted{Value: v, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	return out
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker starts the metadata section at the end of a
+// MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMaxDepth caps how deeply maps and arrays may nest, so a corrupt or
+// hostile database can't recurse without end.
+const mmdbMaxDepth = 512
+
+// mmdbReader reads a MaxMind DB (.mmdb) file, as used by GeoLite2,
+// GeoIP2, and several other IP databases.
+type mmdbReader struct {
+	buf          []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	dataStart    uint
+	ipv4Start    uint
+}
+
+// openMMDB reads and validates the database at name.
+func openMMDB(name string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	r := &mmdbReader{buf: buf}
+	meta, _, err := r.decode(buf[i+len(mmdbMetadataMarker):], 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata is not a map")
+	}
+	r.nodeCount = uint(toUint(m["node_count"]))
+	r.recordSize = uint(toUint(m["record_size"]))
+	r.ipVersion = uint(toUint(m["ip_version"]))
+	r.databaseType, _ = m["database_type"].(string)
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	r.dataStart = treeSize + 16
+	if r.dataStart > uint(i) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for j := 0; j < 96 && node < r.nodeCount; j++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node.
+func (r *mmdbReader) record(node, bit uint) uint {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// lookup returns the record for ip, or nil if the database has none.
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	addr := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		addr = ip4
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		bit := uint(addr[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("invalid search tree")
+	}
+	offset := node - r.nodeCount - 16
+	v, _, err := r.decode(r.buf[r.dataStart:], offset, 0)
+	return v, err
+}
+
+// decode decodes the value at offset in data (the data section, or the
+// metadata), returning it and the offset just past it. depth is how many
+// maps and arrays the value is nested in.
+func (r *mmdbReader) decode(data []byte, offset uint, depth int) (interface{}, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	if offset >= uint(len(data)) {
+		return nil, 0, errors.New("offset out of range")
+	}
+	ctrl := data[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == 1 {
+		// pointer into the data section
+		ss, vvv := uint(ctrl>>3)&3, uint(ctrl&7)
+		if offset+ss+1 > uint(len(data)) {
+			return nil, 0, errors.New("pointer out of range")
+		}
+		var p uint
+		switch ss {
+		case 0:
+			p = vvv<<8 | uint(data[offset])
+		case 1:
+			p = (vvv<<16 | uint(data[offset])<<8 | uint(data[offset+1])) + 2048
+		case 2:
+			p = (vvv<<24 | uint(data[offset])<<16 | uint(data[offset+1])<<8 | uint(data[offset+2])) + 526336
+		case 3:
+			p = uint(binary.BigEndian.Uint32(data[offset:]))
+		}
+		// the spec doesn't allow a pointer to point at another pointer
+		if p < uint(len(data)) && data[p]>>5 == 1 {
+			return nil, 0, errors.New("pointer to a pointer")
+		}
+		v, _, err := r.decode(data, p, depth)
+		return v, offset + ss + 1, err
+	}
+
+	if typ == 0 {
+		// extended type
+		if offset >= uint(len(data)) {
+			return nil, 0, errors.New("offset out of range")
+		}
+		typ = 7 + uint(data[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(data)) {
+			return nil, 0, errors.New("size out of range")
+		}
+		var extra uint
+		for _, b := range data[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		case 3:
+			size = 65821 + extra
+		}
+	}
+
+	// maps and arrays hold size entries rather than size bytes. Each
+	// entry takes at least a byte, so a corrupt size can't preallocate
+	// more than what's left of data.
+	hint := size
+	if offset >= uint(len(data)) {
+		hint = 0
+	} else if rest := uint(len(data)) - offset; hint > rest {
+		hint = rest
+	}
+	switch typ {
+	case 7:
+		m := make(map[string]interface{}, hint)
+		for i := uint(0); i < size; i++ {
+			k, next, err := r.decode(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			v, next, err := r.decode(data, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, _ := k.(string)
+			m[key] = v
+			offset = next
+		}
+		return m, offset, nil
+	case 11:
+		a := make([]interface{}, 0, hint)
+		for i := uint(0); i < size; i++ {
+			v, next, err := r.decode(data, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, errors.New("value out of range")
+	}
+	b := data[offset : offset+size]
+	offset += size
+	switch typ {
+	case 2:
+		return string(b), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 4:
+		return append([]byte{}, b...), offset, nil
+	case 5, 6, 9:
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, offset, nil
+	case 8:
+		var u uint32
+		for _, c := range b {
+			u = u<<8 | uint32(c)
+		}
+		return int32(u), offset, nil
+	case 10:
+		return new(big.Int).SetBytes(b).String(), offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), offset, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// toUint converts a decoded integer to a uint64.
+func toUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int32:
+		return uint64(n)
+	}
+	return 0
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// mmdbValue encodes a control byte for a value of type typ holding size
+// bytes or entries, followed by body.
+func mmdbValue(typ, size int, body ...byte) []byte {
+	var b []byte
+	ctrl := typ
+	if typ > 7 {
+		ctrl = 0
+	}
+	switch {
+	case size < 29:
+		b = append(b, byte(ctrl<<5|size))
+	case size < 285:
+		b = append(b, byte(ctrl<<5|29))
+		if typ > 7 {
+			b = append(b, byte(typ-7))
+		}
+		b = append(b, byte(size-29))
+		return append(b, body...)
+	default:
+		b = append(b, byte(ctrl<<5|30))
+		if typ > 7 {
+			b = append(b, byte(typ-7))
+		}
+		b = append(b, byte((size-285)>>8), byte(size-285))
+		return append(b, body...)
+	}
+	if typ > 7 {
+		b = append(b, byte(typ-7))
+	}
+	return append(b, body...)
+}
+
+func mmdbString(s string) []byte { return mmdbValue(2, len(s), []byte(s)...) }
+
+// mmdbUint encodes v as an unsigned integer of type typ, in as few bytes
+// as it needs.
+func mmdbUint(typ int, v uint64) []byte {
+	var body []byte
+	for ; v > 0; v >>= 8 {
+		body = append([]byte{byte(v)}, body...)
+	}
+	return mmdbValue(typ, len(body), body...)
+}
+
+// mmdbMap encodes a map from alternating keys and encoded values.
+func mmdbMap(kv ...interface{}) []byte {
+	b := mmdbValue(7, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		b = append(b, mmdbString(kv[i].(string))...)
+		b = append(b, kv[i+1].([]byte)...)
+	}
+	return b
+}
+
+// mmdbPointer encodes a pointer to offset p using pointer size ss.
+func mmdbPointer(ss int, p uint32) []byte {
+	switch ss {
+	case 0:
+		return []byte{byte(0x20 | p>>8), byte(p)}
+	case 1:
+		p -= 2048
+		return []byte{byte(0x28 | p>>16), byte(p >> 8), byte(p)}
+	case 2:
+		p -= 526336
+		return []byte{byte(0x30 | p>>24), byte(p >> 16), byte(p >> 8), byte(p)}
+	}
+	return []byte{0x38, byte(p >> 24), byte(p >> 16), byte(p >> 8), byte(p)}
+}
+
+// mmdbPrefix is a network in the search tree and the data offset of its
+// record.
+type mmdbPrefix struct {
+	cidr   string
+	offset int
+}
+
+// buildMMDB lays out a database with the given record size and IP
+// version, mapping each prefix to its offset in data.
+func buildMMDB(t *testing.T, recordSize, ipVersion int, data []byte, prefixes []mmdbPrefix) string {
+	t.Helper()
+	const empty, dataFlag = -1, 1 << 30
+	nodes := [][2]int{{empty, empty}}
+	for _, p := range prefixes {
+		_, network, err := net.ParseCIDR(p.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := network.IP.To16()
+		ones, _ := network.Mask.Size()
+		if ip4 := network.IP.To4(); ip4 != nil {
+			if ipVersion == 4 {
+				addr = ip4
+			} else {
+				addr = append(make([]byte, 12), ip4...)
+				ones += 96
+			}
+		}
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(addr[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[node][bit] = dataFlag + p.offset
+				break
+			}
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	var buf bytes.Buffer
+	n := len(nodes)
+	value := func(r int) uint32 {
+		switch {
+		case r == empty:
+			return uint32(n)
+		case r >= dataFlag:
+			return uint32(n + 16 + r - dataFlag)
+		}
+		return uint32(r)
+	}
+	for _, node := range nodes {
+		l, r := value(node[0]), value(node[1])
+		switch recordSize {
+		case 24:
+			buf.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(r >> 16), byte(r >> 8), byte(r)})
+		case 28:
+			buf.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(l>>24)<<4 | byte(r>>24), byte(r >> 16), byte(r >> 8), byte(r)})
+		case 32:
+			binary.Write(&buf, binary.BigEndian, [2]uint32{l, r})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.Write(mmdbMetadataMarker)
+	buf.Write(mmdbMap(
+		"node_count", mmdbUint(6, uint64(n)),
+		"record_size", mmdbUint(5, uint64(recordSize)),
+		"ip_version", mmdbUint(5, uint64(ipVersion)),
+		"database_type", mmdbString("ghost-test"),
+	))
+
+	name := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// mmdbFixture returns a data section holding a record with every data
+// type at offset 0, a record of pointers of every size, a self-pointer,
+// and a map that points back at itself, along with their offsets.
+func mmdbFixture() (data []byte, pointers, self, loop int) {
+	var double, float [8]byte
+	binary.BigEndian.PutUint64(double[:], math.Float64bits(1.5))
+	binary.BigEndian.PutUint32(float[:], math.Float32bits(-2.25))
+	uint128 := append([]byte{1}, make([]byte, 15)...) // 2^120
+
+	data = mmdbMap(
+		"string", mmdbString("hello"),
+		"long", mmdbString(strings.Repeat("x", 300)),
+		"double", mmdbValue(3, 8, double[:]...),
+		"bytes", mmdbValue(4, 3, 1, 2, 3),
+		"uint16", mmdbUint(5, 443),
+		"uint32", mmdbUint(6, 1<<31),
+		"int32", mmdbValue(8, 4, 0xff, 0xff, 0xff, 0xfe),
+		"uint64", mmdbUint(9, 1<<63),
+		"uint128", mmdbValue(10, 16, uint128...),
+		"array", append(mmdbValue(11, 2), append(mmdbString("a"), mmdbUint(5, 7)...)...),
+		"map", mmdbMap("nested", mmdbString("yes")),
+		"true", mmdbValue(14, 1),
+		"false", mmdbValue(14, 0),
+		"float", mmdbValue(15, 4, float[:4]...),
+	)
+
+	// targets for the pointers, one in the range of each pointer size
+	targets := []int{1500, 3000, 600000}
+	pointers = len(data)
+	data = append(data, mmdbMap(
+		"ss0", mmdbPointer(0, uint32(targets[0])),
+		"ss1", mmdbPointer(1, uint32(targets[1])),
+		"ss2", mmdbPointer(2, uint32(targets[2])),
+		"ss3", mmdbPointer(3, uint32(targets[0])),
+	)...)
+
+	self = len(data)
+	data = append(data, mmdbPointer(0, uint32(self))...)
+
+	loop = len(data)
+	data = append(data, mmdbMap("again", mmdbPointer(0, uint32(loop)))...)
+
+	for i, off := range targets {
+		data = append(data, make([]byte, off-len(data))...)
+		data = append(data, mmdbString([]string{"one", "two", "three"}[i])...)
+	}
+	return data, pointers, self, loop
+}
+
+func TestMMDBLookup(t *testing.T) {
+	data, pointers, self, loop := mmdbFixture()
+	prefixes := []mmdbPrefix{
+		{"1.2.3.0/24", 0},
+		{"2001:db8::/32", pointers},
+		{"10.0.0.0/8", self},
+		{"192.168.0.0/16", loop},
+	}
+
+	wantTypes := map[string]interface{}{
+		"string":  "hello",
+		"long":    strings.Repeat("x", 300),
+		"double":  1.5,
+		"bytes":   []byte{1, 2, 3},
+		"uint16":  uint64(443),
+		"uint32":  uint64(1 << 31),
+		"int32":   int32(-2),
+		"uint64":  uint64(1 << 63),
+		"uint128": "1329227995784915872903807060280344576",
+		"array":   []interface{}{"a", uint64(7)},
+		"map":     map[string]interface{}{"nested": "yes"},
+		"true":    true,
+		"false":   false,
+		"float":   float32(-2.25),
+	}
+	wantPointers := map[string]interface{}{"ss0": "one", "ss1": "two", "ss2": "three", "ss3": "one"}
+
+	for _, size := range []int{24, 28, 32} {
+		name := buildMMDB(t, size, 6, data, prefixes)
+		r, err := openMMDB(name)
+		if err != nil {
+			t.Fatalf("record size %d: %v", size, err)
+		}
+		if r.databaseType != "ghost-test" {
+			t.Errorf("record size %d: database type %q", size, r.databaseType)
+		}
+
+		tests := []struct {
+			ip   string
+			want interface{}
+			err  string
+		}{
+			{ip: "1.2.3.4", want: wantTypes},
+			{ip: "2001:db8::1", want: wantPointers},
+			{ip: "8.8.8.8"},
+			{ip: "2001:db9::1"},
+			{ip: "10.1.2.3", err: "pointer to a pointer"},
+			{ip: "192.168.1.1", err: "nested too deeply"},
+		}
+		for _, tt := range tests {
+			got, err := r.lookup(net.ParseIP(tt.ip))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("record size %d: lookup(%s) error = %v, want %q", size, tt.ip, err, tt.err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("record size %d: lookup(%s): %v", size, tt.ip, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("record size %d: lookup(%s) = %#v, want %#v", size, tt.ip, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestMMDBLookupIPv4(t *testing.T) {
+	data := mmdbMap("city", mmdbString("Springfield"))
+	r, err := openMMDB(buildMMDB(t, 24, 4, data, []mmdbPrefix{{"1.2.3.0/24", 0}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.lookup(net.ParseIP("1.2.3.200"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"city": "Springfield"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("lookup(1.2.3.200) = %#v, want %#v", got, want)
+	}
+	for _, ip := range []string{"1.2.4.1", "2001:db8::1"} {
+		got, err := r.lookup(net.ParseIP(ip))
+		if got != nil || err != nil {
+			t.Errorf("lookup(%s) = %v, %v, want nothing", ip, got, err)
+		}
+	}
+}
+
+func TestOpenMMDBErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"empty", nil, "not a MaxMind DB"},
+		{"record size", append(append([]byte{}, mmdbMetadataMarker...), mmdbMap(
+			"node_count", mmdbUint(6, 1),
+			"record_size", mmdbUint(5, 20),
+			"ip_version", mmdbUint(5, 6),
+		)...), "unsupported record size"},
+		{"tree too large", append(append([]byte{}, mmdbMetadataMarker...), mmdbMap(
+			"node_count", mmdbUint(6, 1000),
+			"record_size", mmdbUint(5, 24),
+			"ip_version", mmdbUint(5, 6),
+		)...), "search tree is larger"},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(name, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := openMMDB(name)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestMMDBDecodeCorruptSize(t *testing.T) {
+	// a map and an array claiming the largest size there is, with nothing
+	// after them
+	for _, data := range [][]byte{
+		{7<<5 | 31, 0xff, 0xff, 0xff},
+		{0<<5 | 31, 11 - 7, 0xff, 0xff, 0xff},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, _, err := (&mmdbReader{}).decode(data, 0, 0)
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("decode(% x) didn't fail", data)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("decode(% x) allocated %d bytes", data, n)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputOptions holds the settings for where results are written.
+type outputOptions struct {
+	dir       string
+	overwrite bool
+	append    bool
+}
+
+// outputFlags registers the flags for choosing the output directory on
+// fs.
+func outputFlags(fs *flag.FlagSet, o *outputOptions) {
+	fs.StringVar(&o.dir, "o", "data", "directory to write results to, in a subdirectory per target (default is 'data').")
+	fs.BoolVar(&o.overwrite, "overwrite", false, "write into the target's directory itself, replacing earlier results, instead of a new timestamped run directory.")
+	fs.BoolVar(&o.append, "append", false, "write into the target's directory itself, merging results into earlier ones, instead of a new timestamped run directory.")
+}
+
+// output is the directory a run writes its results to. Every result
+// file goes through it, named relative to the run directory with
+// forward slashes.
+type output struct {
+	mu     sync.Mutex
+	dir    string
+	append bool
+	files  map[string]bool
+}
+
+// newOutput sets up the run directory for target under opts.dir: a new
+// timestamped directory under the target's directory by default, or the
+// target's directory itself with -overwrite or -append.
+func newOutput(opts outputOptions, target string, now time.Time) (*output, error) {
+	if opts.overwrite && opts.append {
+		return nil, errors.New("-overwrite and -append can't be used together")
+	}
+	base := opts.dir
+	if base == "" {
+		base = "."
+	}
+	dir := filepath.Join(base, targetDirName(target))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	if opts.overwrite {
+		if err := clearOutput(dir); err != nil {
+			return nil, fmt.Errorf("unable to clear %s: %w", dir, err)
+		}
+	}
+	if opts.overwrite || opts.append {
+		return &output{dir: dir, append: opts.append}, nil
+	}
+
+	// runs started in the same second get a numbered directory; making
+	// the directory claims the name, even with other runs going at once
+	run := filepath.Join(dir, now.UTC().Format("20060102-150405"))
+	for i := 1; ; i++ {
+		name := run
+		if i > 1 {
+			name = fmt.Sprintf("%s-%d", run, i)
+		}
+		err = os.Mkdir(name, 0755)
+		if err == nil {
+			return &output{dir: name}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+	}
+}
+
+// clearOutput removes the results of the run recorded in dir's manifest,
+// so -overwrite doesn't leave any of an earlier run's files behind.
+// Files the manifest doesn't list, and directories that still hold
+// something, are kept.
+func clearOutput(dir string) error {
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("unable to read the earlier manifest: %w", err)
+	}
+
+	// the manifest goes last, so a failed clear can be run again
+	names := make([]string, 0, len(m.Files)+2)
+	for _, f := range m.Files {
+		names = append(names, f.Name)
+	}
+	names = append(names, "manifest.sig", "manifest.json")
+	dirs := make(map[string]bool)
+	for _, name := range names {
+		name = path.Clean(name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		err := os.Remove(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for d := path.Dir(name); d != "."; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+
+	// remove the directories left empty, deepest first
+	sorted := make([]string, 0, len(dirs))
+	for d := range dirs {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	for _, d := range sorted {
+		os.Remove(filepath.Join(dir, filepath.FromSlash(d)))
+	}
+	return nil
+}
+
+// setOutput sets up the run directory for the target, named after its
+// URL.
+func (g *ghost) setOutput(start time.Time) error {
+	out, err := newOutput(g.config.output, g.config.url, start)
+	if err != nil {
+		return err
+	}
+	g.out = out
+	g.infoLog.Printf("Writing results to %s\n", out.dir)
+	return nil
+}
+
+// targetDirName turns a target URL into a directory name: the host and
+// path, with anything unsafe replaced by underscores.
+func targetDirName(target string) string {
+	target = strings.ToLower(target)
+	for _, prefix := range []string{"https://", "http://"} {
+		target = strings.TrimPrefix(target, prefix)
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, strings.TrimRight(target, "/"))
+	name = strings.Trim(name, "._")
+	if name == "" {
+		return "target"
+	}
+	return name
+}
+
+// path returns where the result called name goes.
+func (o *output) path(name string) string {
+	return filepath.Join(o.dir, filepath.FromSlash(name))
+}
+
+// write saves a result, making any directories it needs. With -append,
+// a result that's already there is merged with the new one (see
+// mergeOutput); if it can't be, it's left as it was and the new result
+// isn't written.
+func (o *output) write(name string, data []byte) (string, error) {
+	p := o.path(name)
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return p, err
+	}
+	o.mu.Lock()
+	if o.files == nil {
+		o.files = make(map[string]bool)
+	}
+	o.files[name] = true
+	o.mu.Unlock()
+	if o.append {
+		// merges read and rewrite the file, so one at a time
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		old, err := os.ReadFile(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return p, err
+		}
+		if err == nil {
+			data, err = mergeOutput(name, old, data)
+			if err != nil {
+				return p, fmt.Errorf("unable to merge into %s, leaving it as it was: %w", p, err)
+			}
+		}
+	}
+	return p, writeFile(p, data)
+}
+
+// written returns the names of the results written so far, sorted.
+func (o *output) written() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	names := make([]string, 0, len(o.files))
+	for name := range o.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeFile writes data to the file called name, replacing it.
+func writeFile(name string, data []byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// mergeOutput combines an earlier result with a new one for -append.
+// CSV and JSON Lines files gain the new rows (CSV without its repeated
+// header), and text files gain any new lines. A result that can't be
+// read is an error, so nothing already there is lost.
+func mergeOutput(name string, old, data []byte) ([]byte, error) {
+	switch filepath.Ext(name) {
+	case ".json":
+		var a, b interface{}
+		if err := json.Unmarshal(old, &a); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, err
+		}
+		return json.Marshal(mergeJSON(a, b))
+	case ".jsonl":
+		var buf bytes.Buffer
+		for _, src := range [][]byte{old, data} {
+			dec := json.NewDecoder(bytes.NewReader(src))
+			for {
+				var v json.RawMessage
+				err := dec.Decode(&v)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				// rows are compacted to keep one per line
+				if err := json.Compact(&buf, v); err != nil {
+					return nil, err
+				}
+				buf.WriteByte('\n')
+			}
+		}
+		return buf.Bytes(), nil
+	case ".csv":
+		a, err := csv.NewReader(bytes.NewReader(old)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		b, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(a) > 0 && len(b) > 0 && equalStrings(a[0], b[0]) {
+			b = b[1:]
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.WriteAll(append(a, b...))
+		return buf.Bytes(), w.Error()
+	case ".txt":
+		seen := make(map[string]bool)
+		var buf bytes.Buffer
+		for _, src := range [][]byte{old, data} {
+			for _, line := range strings.Split(strings.TrimSuffix(string(src), "\n"), "\n") {
+				if line != "" && !seen[line] {
+					seen[line] = true
+					buf.WriteString(line)
+					buf.WriteByte('\n')
+				}
+			}
+		}
+		return buf.Bytes(), nil
+	}
+	return data, nil
+}
+
+// equalStrings reports whether a and b hold the same strings in the same
+// order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeJSON combines two decoded JSON values. Arrays are concatenated,
+// dropping entries already present (so a header row appears once);
+// objects are merged key by key; anything else takes the new value.
+func mergeJSON(a, b interface{}) interface{} {
+	switch bv := b.(type) {
+	case []interface{}:
+		av, ok := a.([]interface{})
+		if !ok {
+			return b
+		}
+		seen := make(map[string]bool)
+		out := make([]interface{}, 0, len(av)+len(bv))
+		for _, v := range append(av, bv...) {
+			key, _ := json.Marshal(v)
+			if seen[string(key)] {
+				continue
+			}
+			seen[string(key)] = true
+			out = append(out, v)
+		}
+		return out
+	case map[string]interface{}:
+		av, ok := a.(map[string]interface{})
+		if !ok {
+			return b
+		}
+		for k, v := range bv {
+			if old, ok := av[k]; ok {
+				v = mergeJSON(old, v)
+			}
+			av[k] = v
+		}
+		return av
+	}
+	return b
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeOutput(t *testing.T) {
+	long := `{"v":"` + strings.Repeat("x", 100000) + `"}`
+	tests := []struct {
+		name      string
+		old, data string
+		want      string
+	}{
+		{
+			"rows.csv",
+			"a,b\n1,2\n",
+			"a,b\n1,2\n3,\"multi\nline\"\n",
+			"a,b\n1,2\n1,2\n3,\"multi\nline\"\n",
+		},
+		{
+			"rows.csv",
+			"a,b\n1,2\n",
+			"c,d\n3,4\n",
+			"a,b\n1,2\nc,d\n3,4\n",
+		},
+		{"rows.csv", "", "a,b\n1,2\n", "a,b\n1,2\n"},
+		{
+			"rows.jsonl",
+			"{\"a\":1}\n",
+			"{\"a\":1}\n{\n \"b\": [1, 2]\n}\n" + long + "\n",
+			"{\"a\":1}\n{\"a\":1}\n{\"b\":[1,2]}\n" + long + "\n",
+		},
+		{"lines.txt", "a\nb\n", "b\nc", "a\nb\nc\n"},
+		{"results.json", `{"a":[1],"b":"x"}`, `{"a":[2],"b":"y"}`, `{"a":[1,2],"b":"y"}`},
+		{"results.db", "old", "new", "new"},
+	}
+	for _, tt := range tests {
+		got, err := mergeOutput(tt.name, []byte(tt.old), []byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ name, old, data string }{
+		{"rows.csv", "a,\"b\n", "a,b\n"},
+		{"rows.jsonl", "{\"a\":1}\n{broken\n", "{}\n"},
+		{"rows.jsonl", "{}\n", "[1,\n"},
+		{"results.json", "[1,", "[2]"},
+	} {
+		if _, err := mergeOutput(tt.name, []byte(tt.old), []byte(tt.data)); err == nil {
+			t.Errorf("%s: merging %q and %q: want an error", tt.name, tt.old, tt.data)
+		}
+	}
+}
+
+func TestAppendKeepsUnreadableResult(t *testing.T) {
+	o, err := newOutput(outputOptions{dir: t.TempDir(), append: true}, "https://example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := o.path("rows.jsonl")
+	if err := os.WriteFile(p, []byte("{broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.write("rows.jsonl", []byte("{}\n")); err == nil {
+		t.Error("write: want an error merging into an unreadable result")
+	}
+	if b, _ := os.ReadFile(p); string(b) != "{broken\n" {
+		t.Errorf("result changed to %q", b)
+	}
+}
+
+func TestOverwriteClearsEarlierRun(t *testing.T) {
+	base := t.TempDir()
+	opts := outputOptions{dir: base, overwrite: true}
+	o, err := newOutput(opts, "https://example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(base, "outside.txt")
+	files := map[string]string{
+		"snapshots.json":        "[]",
+		"sub/deep/file.txt":     "x",
+		"sub/kept.txt":          "x",
+		"manifest.sig":          "sig",
+		"manifest.json":         `{"files":[{"name":"snapshots.json"},{"name":"sub/deep/file.txt"},{"name":"../outside.txt"}]}`,
+		"../outside.txt":        "x",
+		"notlisted/notes.txt":   "x",
+		"sub/deep/../other.txt": "x",
+	}
+	for name, data := range files {
+		p := o.path(name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := newOutput(opts, "https://example.com", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"snapshots.json", "sub/deep", "manifest.sig", "manifest.json"} {
+		if _, err := os.Stat(o.path(name)); !os.IsNotExist(err) {
+			t.Errorf("%s wasn't removed", name)
+		}
+	}
+	for _, p := range []string{o.path("sub/kept.txt"), o.path("notlisted/notes.txt"), o.path("sub/other.txt"), outside} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s was removed", p)
+		}
+	}
+}
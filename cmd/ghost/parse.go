@@ -4,31 +4,37 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"unicode/utf8"
 )
 
 // parsePage takes in a page and searches its contents for whatever
 // query the user submitted (regular expression, a single search term,
-// or a list of terms supplied in a .txt file).
+// a list of terms supplied in a .txt file, or a set of rules).
 func (g *ghost) parsePage(page, url string, query interface{}) {
 	seen := make(map[string]bool)
 
 	switch q := query.(type) {
+	case *ruleSet:
+		g.scanRules(q, []byte(page), url)
 	case *regexp.Regexp:
-		results := q.FindAllString(string(page), -1)
+		results := q.FindAllStringIndex(page, -1)
 		if results == nil {
 			g.infoLog.Printf("Failed to find %v.\n", q)
 			return
 		}
-		for _, result := range results {
+		for _, loc := range results {
+			result := page[loc[0]:loc[1]]
 			if seen[result] {
 				continue
 			}
 			seen[result] = true
 			g.searches.store(result, url)
+			g.searches.storeHit(newSearchHit(result, url, page, loc[0], loc[1]))
 		}
 	case string:
-		if len(q) > 0 && strings.Contains(page, q) {
+		if i := strings.Index(page, q); len(q) > 0 && i >= 0 {
 			g.searches.store(q, url)
+			g.searches.storeHit(newSearchHit(q, url, page, i, i+len(q)))
 		} else {
 			g.infoLog.Printf("Failed to find %s.\n", q)
 		}
@@ -36,24 +42,28 @@ func (g *ghost) parsePage(page, url string, query interface{}) {
 		var wg sync.WaitGroup
 		for _, term := range q {
 			wg.Add(1)
-			go func(t string) {
+			t := term
+			g.goSafe(func() {
 				defer wg.Done()
-				if strings.Contains(page, t) {
+				if i := strings.Index(page, t); i >= 0 {
 					g.searches.store(t, url)
+					g.searches.storeHit(newSearchHit(t, url, page, i, i+len(t)))
 				} else {
 					g.infoLog.Printf("Failed to find %s.\n", t)
 				}
-			}(term)
+			})
 		}
 		wg.Wait()
 	}
 }
 
 // searchMap is a mutex-protected map that stores the search results
-// in the key-value form query: url(s).
+// in the key-value form query: url(s), along with the first match in
+// each page and the text around it.
 type searchMap struct {
 	mu       sync.Mutex
 	searches map[string][]string
+	hits     []searchHit
 }
 
 // newSearchMap returns a pointer to a new searchMap.
@@ -70,3 +80,58 @@ func (s *searchMap) store(term, url string) {
 	s.searches[term] = append(s.searches[term], url)
 	s.mu.Unlock()
 }
+
+// storeHit adds a match, with its context, to the searchMap.
+func (s *searchMap) storeHit(hit searchHit) {
+	s.mu.Lock()
+	s.hits = append(s.hits, hit)
+	s.mu.Unlock()
+}
+
+// searchContext is how many bytes of the page are kept on either side of
+// a match.
+const searchContext = 80
+
+// searchHit is a match in a snapshot, with the text around it.
+type searchHit struct {
+	Term      string
+	URL       string
+	Timestamp string
+	Before    string
+	Match     string
+	After     string
+}
+
+// whitespace matches a run of whitespace.
+var whitespace = regexp.MustCompile(`\s+`)
+
+// newSearchHit returns the match at page[start:end], keeping up to
+// searchContext bytes on either side, cut at character boundaries and
+// with runs of whitespace collapsed.
+func newSearchHit(term, url, page string, start, end int) searchHit {
+	from := start - searchContext
+	if from < 0 {
+		from = 0
+	}
+	for from > 0 && !utf8.RuneStart(page[from]) {
+		from--
+	}
+	to := end + searchContext
+	if to > len(page) {
+		to = len(page)
+	}
+	for to < len(page) && !utf8.RuneStart(page[to]) {
+		to++
+	}
+	hit := searchHit{
+		Term:   term,
+		URL:    url,
+		Before: whitespace.ReplaceAllString(page[from:start], " "),
+		Match:  page[start:end],
+		After:  whitespace.ReplaceAllString(page[end:to], " "),
+	}
+	if m := snapshotTimestamp.FindStringSubmatch(url); m != nil {
+		hit.Timestamp = m[1]
+	}
+	return hit
+}
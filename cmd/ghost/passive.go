@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// guard sits between ghost and the network. It records every outbound
+// connection for the audit log and, in passive mode, refuses any
+// connection to the target's hosts or addresses. Resolving the target
+// would query its nameservers, so the only addresses known to be the
+// target's are those given as IP literals.
+type guard struct {
+	mu      sync.Mutex
+	passive bool
+	hosts   map[string]bool
+	domains []string
+	ips     map[string]bool
+	events  []auditEvent
+}
+
+// auditEvent is a single outbound connection attempt.
+type auditEvent struct {
+	Time    time.Time `json:"time"`
+	Network string    `json:"network"`
+	Address string    `json:"address"`
+	Allowed bool      `json:"allowed"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// newGuard returns a pointer to a new guard.
+func newGuard(passive bool) *guard {
+	return &guard{
+		passive: passive,
+		hosts:   make(map[string]bool),
+		ips:     make(map[string]bool),
+	}
+}
+
+// blockTarget marks host, domain, and every subdomain of domain as off
+// limits in passive mode.
+func (gd *guard) blockTarget(host, domain string) {
+	gd.mu.Lock()
+	defer gd.mu.Unlock()
+	if host != "" {
+		gd.hosts[strings.ToLower(strings.TrimSuffix(host, "."))] = true
+		if ip := net.ParseIP(host); ip != nil {
+			gd.ips[ip.String()] = true
+		}
+	}
+	if domain != "" {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		gd.hosts[domain] = true
+		gd.domains = append(gd.domains, "."+domain)
+	}
+}
+
+// checkHost returns an error if host belongs to the target and the
+// guard is in passive mode.
+func (gd *guard) checkHost(host string) error {
+	if !gd.passive {
+		return nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	gd.mu.Lock()
+	defer gd.mu.Unlock()
+	if gd.hosts[host] {
+		return fmt.Errorf("passive mode: refusing to contact target host %s", host)
+	}
+	for _, d := range gd.domains {
+		if strings.HasSuffix(host, d) {
+			return fmt.Errorf("passive mode: refusing to contact target host %s", host)
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil && gd.ips[ip.String()] {
+		return fmt.Errorf("passive mode: refusing to contact target address %s", host)
+	}
+	return nil
+}
+
+// checkIP returns an error if ip belongs to the target and the guard is
+// in passive mode.
+func (gd *guard) checkIP(ip net.IP) error {
+	if !gd.passive {
+		return nil
+	}
+	gd.mu.Lock()
+	defer gd.mu.Unlock()
+	if gd.ips[ip.String()] {
+		return fmt.Errorf("passive mode: refusing to contact target address %s", ip)
+	}
+	return nil
+}
+
+// record adds an event to the audit log.
+func (gd *guard) record(network, address string, err error) {
+	e := auditEvent{Time: time.Now(), Network: network, Address: address, Allowed: err == nil}
+	if err != nil {
+		e.Reason = err.Error()
+	}
+	gd.mu.Lock()
+	gd.events = append(gd.events, e)
+	gd.mu.Unlock()
+}
+
+// dialContext checks the host and every address it resolves to before
+// dialing, so no packet is sent to a refused address.
+func (gd *guard) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if err := gd.checkHost(host); err != nil {
+		gd.record(network, address, err)
+		return nil, err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			gd.record(network, address, err)
+			return nil, err
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	for _, ip := range ips {
+		if err := gd.checkIP(ip); err != nil {
+			gd.record(network, address, err)
+			return nil, err
+		}
+	}
+
+	var d net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			gd.record(network, address, nil)
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses for %s", host)
+	}
+	gd.record(network, address, lastErr)
+	return nil, lastErr
+}
+
+// guardedTransport checks the host of every request (including
+// redirects) before handing it to the underlying transport. This catches
+// requests that would otherwise be sent through a proxy.
+type guardedTransport struct {
+	base  http.RoundTripper
+	guard *guard
+}
+
+func (t *guardedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.guard.checkHost(req.URL.Hostname()); err != nil {
+		t.guard.record("http", req.URL.Host, err)
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newClient returns an HTTP client whose connections all go through gd,
+// and whose requests are all recorded in log.
+func newClient(gd *guard, log *requestLog) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = gd.dialContext
+	return &http.Client{Transport: &recordingTransport{base: &guardedTransport{base: transport, guard: gd}, log: log}}
+}
+
+// auditHost summarizes the connections made to a single host.
+type auditHost struct {
+	Address string `json:"address"`
+	Allowed int    `json:"allowed"`
+	Refused int    `json:"refused"`
+}
+
+// auditLogWriter writes every outbound host contacted, along with the
+// individual connection attempts, to a file. It only runs in passive mode.
+func (g *ghost) auditLogWriter() {
+	if !g.guard.passive {
+		return
+	}
+	g.guard.mu.Lock()
+	events := append([]auditEvent{}, g.guard.events...)
+	g.guard.mu.Unlock()
+
+	byAddress := make(map[string]*auditHost)
+	for _, e := range events {
+		h, ok := byAddress[e.Address]
+		if !ok {
+			h = &auditHost{Address: e.Address}
+			byAddress[e.Address] = h
+		}
+		if e.Allowed {
+			h.Allowed++
+		} else {
+			h.Refused++
+		}
+	}
+	hosts := make([]*auditHost, 0, len(byAddress))
+	for _, h := range byAddress {
+		hosts = append(hosts, h)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Address < hosts[j].Address })
+
+	b, err := json.Marshal(struct {
+		Passive bool         `json:"passive"`
+		Hosts   []*auditHost `json:"hosts"`
+		Events  []auditEvent `json:"events"`
+	}{g.guard.passive, hosts, events})
+	if err != nil {
+		g.errorLog.Printf("Marshal error: %v\n", err)
+		return
+	}
+	g.writeData("audit.json", b)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestGuardCheckHost(t *testing.T) {
+	gd := newGuard(true)
+	gd.blockTarget("www.example.com", "example.com")
+	gd.blockTarget("192.0.2.10", "")
+	gd.blockTarget("2001:db8::1", "")
+
+	for _, host := range []string{
+		"www.example.com",
+		"WWW.Example.com.",
+		"example.com",
+		"api.example.com",
+		"a.b.example.com",
+		"192.0.2.10",
+		"2001:0db8:0:0::1",
+	} {
+		if err := gd.checkHost(host); err == nil || !strings.HasPrefix(err.Error(), "passive mode:") {
+			t.Errorf("checkHost(%q) = %v, want a refusal", host, err)
+		}
+	}
+	for _, host := range []string{
+		"web.archive.org",
+		"notexample.com",
+		"example.com.evil.net",
+		"example.co",
+		"192.0.2.11",
+	} {
+		if err := gd.checkHost(host); err != nil {
+			t.Errorf("checkHost(%q) = %v", host, err)
+		}
+	}
+
+	if err := gd.checkIP(net.ParseIP("192.0.2.10")); err == nil {
+		t.Error("checkIP allowed the target's address")
+	}
+	if err := gd.checkIP(net.ParseIP("192.0.2.11")); err != nil {
+		t.Errorf("checkIP(192.0.2.11) = %v", err)
+	}
+
+	// outside passive mode nothing is refused
+	open := newGuard(false)
+	open.blockTarget("www.example.com", "example.com")
+	if err := open.checkHost("www.example.com"); err != nil {
+		t.Errorf("checkHost outside passive mode = %v", err)
+	}
+}
+
+func TestGuardDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	gd := newGuard(true)
+	gd.blockTarget("www.example.com", "example.com")
+	conn, err := gd.dialContext(context.Background(), "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial to a host that isn't the target: %v", err)
+	}
+	conn.Close()
+	// refused before any lookup or connection
+	if _, err := gd.dialContext(context.Background(), "tcp", net.JoinHostPort("api.example.com", port)); err == nil {
+		t.Error("dial to a subdomain of the target was allowed")
+	}
+
+	// an IP target is refused through any name that resolves to it
+	gd = newGuard(true)
+	gd.blockTarget("127.0.0.1", "")
+	for _, address := range []string{l.Addr().String(), net.JoinHostPort("localhost", port)} {
+		if _, err := gd.dialContext(context.Background(), "tcp", address); err == nil {
+			t.Errorf("dial to %s was allowed", address)
+		}
+	}
+	for _, e := range gd.events {
+		if e.Allowed || !strings.Contains(e.Reason, "passive mode") {
+			t.Errorf("audit event = %+v", e)
+		}
+	}
+	if len(gd.events) != 2 {
+		t.Errorf("audit log has %d events, want 2", len(gd.events))
+	}
+}
+
+func TestGuardedClient(t *testing.T) {
+	gd := newGuard(true)
+	gd.blockTarget("www.example.com", "example.com")
+	client := newClient(gd, &requestLog{})
+	for _, u := range []string{"http://www.example.com/", "https://cdn.example.com/app.js"} {
+		resp, err := client.Get(u)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("request to %s was allowed", u)
+		} else if !strings.Contains(err.Error(), "passive mode: refusing to contact target host") {
+			t.Errorf("request to %s: %v", u, err)
+		}
+	}
+	if len(gd.events) != 2 || gd.events[0].Network != "http" || gd.events[0].Allowed {
+		t.Errorf("audit log = %+v", gd.events)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// suffixListURL is where the current Public Suffix List is published.
+const suffixListURL = "https://publicsuffix.org/list/public_suffix_list.dat"
+
+// suffixListFile is the name of the refreshed list in the cache directory.
+const suffixListFile = "public_suffix_list.dat"
+
+// suffixList is a Public Suffix List downloaded with -psl-refresh. Without
+// one, ghost uses the copy built into golang.org/x/net/publicsuffix.
+type suffixList struct {
+	rules      map[string]bool
+	exceptions map[string]bool
+}
+
+// parseSuffixList parses the Public Suffix List format: one rule per
+// line, with "*." marking wildcards and "!" marking exceptions. Rules
+// are stored in their ASCII (punycode) form.
+func parseSuffixList(data []byte) *suffixList {
+	l := &suffixList{rules: make(map[string]bool), exceptions: make(map[string]bool)}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+		rule := fields[0]
+		if strings.HasPrefix(rule, "!") {
+			l.exceptions[toASCII(rule[1:])] = true
+			continue
+		}
+		if strings.HasPrefix(rule, "*.") {
+			l.rules["*."+toASCII(rule[2:])] = true
+			continue
+		}
+		l.rules[toASCII(rule)] = true
+	}
+	return l
+}
+
+// publicSuffix returns the longest public suffix of domain. Exceptions
+// win over wildcards, and an unlisted TLD is its own public suffix.
+func (l *suffixList) publicSuffix(domain string) string {
+	labels := strings.Split(domain, ".")
+	for i := range labels {
+		candidate := strings.Join(labels[i:], ".")
+		if l.exceptions[candidate] {
+			return strings.Join(labels[i+1:], ".")
+		}
+		if l.rules[candidate] {
+			return candidate
+		}
+		if i+1 < len(labels) && l.rules["*."+strings.Join(labels[i+1:], ".")] {
+			return candidate
+		}
+	}
+	return labels[len(labels)-1]
+}
+
+// loadSuffixList returns the refreshed list in the cache directory, or
+// nil if there isn't one.
+func loadSuffixList() *suffixList {
+	dir, err := cacheDir("publicsuffix")
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, suffixListFile))
+	if err != nil {
+		return nil
+	}
+	return parseSuffixList(data)
+}
+
+// refreshSuffixList downloads the current Public Suffix List into the
+// cache directory and starts using it.
+func (g *ghost) refreshSuffixList(timeout int) {
+	dir, err := cacheDir("publicsuffix")
+	if err != nil {
+		g.errorLog.Printf("unable to refresh the public suffix list: %v\n", err)
+		return
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		g.errorLog.Printf("unable to make %s: %v\n", dir, err)
+		return
+	}
+	body, err := g.getData(suffixListURL, timeout)
+	if err != nil {
+		g.errorLog.Printf("unable to refresh the public suffix list: %v\n", err)
+		return
+	}
+	l := parseSuffixList(body)
+	if len(l.rules) == 0 {
+		g.errorLog.Println("the downloaded public suffix list is empty")
+		return
+	}
+	g.writeFileAt(filepath.Join(dir, suffixListFile), body)
+	g.psl = l
+}
+
+// publicSuffix returns the public suffix of an ASCII domain.
+func (g *ghost) publicSuffix(domain string) string {
+	if g.psl != nil {
+		return g.psl.publicSuffix(domain)
+	}
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix
+}
+
+// hostParts is a host name split at its public suffix. Domain is the
+// registrable domain (one label plus the public suffix); it's empty for
+// IP addresses and hosts that are themselves public suffixes.
+type hostParts struct {
+	Host      string `json:"host"`
+	Unicode   string `json:"unicode,omitempty"`
+	Subdomain string `json:"subdomain,omitempty"`
+	Domain    string `json:"domain,omitempty"`
+	Suffix    string `json:"suffix,omitempty"`
+}
+
+// splitHost normalizes host to lowercase ASCII (punycode) and splits it
+// into its subdomain, registrable domain, and public suffix.
+func (g *ghost) splitHost(host string) (hostParts, error) {
+	host = strings.TrimSuffix(hostWithoutPort(host), ".")
+	if host == "" {
+		return hostParts{}, errors.New("empty host")
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return hostParts{Host: ip.String()}, nil
+	}
+
+	p := hostParts{Host: toASCII(host)}
+	if u := toUnicode(p.Host); u != p.Host {
+		p.Unicode = u
+	}
+	p.Suffix = g.publicSuffix(p.Host)
+	if p.Suffix == p.Host {
+		return p, nil
+	}
+	rest := strings.TrimSuffix(p.Host, "."+p.Suffix)
+	if i := strings.LastIndexByte(rest, '.'); i >= 0 {
+		p.Subdomain, rest = rest[:i], rest[i+1:]
+	}
+	p.Domain = rest + "." + p.Suffix
+	return p, nil
+}
+
+// toASCII maps a host name to lowercase ASCII with UTS 46 processing,
+// punycode-encoding any labels that aren't ASCII. Names IDNA rejects,
+// such as ones with underscores, are only lowercased.
+func toASCII(host string) string {
+	a, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return strings.ToLower(host)
+	}
+	return a
+}
+
+// toUnicode decodes the punycode labels of a host name, leaving it as it
+// is if any of them don't decode.
+func toUnicode(host string) string {
+	u, err := idna.Lookup.ToUnicode(host)
+	if err != nil {
+		return host
+	}
+	return u
+}
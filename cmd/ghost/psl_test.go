@@ -0,0 +1,89 @@
+package main
+
+import "testing"
+
+func TestGetDomain(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com", "example.com"},
+		{"https://API.Dev.Example.COM:8443/path", "example.com"},
+		{"https://example.com.", "example.com"},
+		{"https://api.dev.example.co.uk", "example.co.uk"},
+		{"https://www.example.com.au", "example.com.au"},
+		{"https://co.uk", "co.uk"},
+		{"https://user.github.io", "user.github.io"},
+		{"https://a.b.user.github.io", "user.github.io"},
+		{"https://bücher.example", "xn--bcher-kva.example"},
+		{"https://www.bücher.de", "xn--bcher-kva.de"},
+		{"https://www.xn--bcher-kva.de", "xn--bcher-kva.de"},
+		{"https://WWW.BÜCHER.DE", "xn--bcher-kva.de"},
+		{"https://www.example.рф", "example.xn--p1ai"},
+		{"https://_dmarc.example.com", "example.com"},
+		{"https://192.0.2.1:8080", "192.0.2.1"},
+		{"https://[2001:db8::1]/", "2001:db8::1"},
+	}
+	g := &ghost{}
+	for _, tt := range tests {
+		got, err := g.getDomain(tt.url)
+		if err != nil {
+			t.Errorf("getDomain(%q): %v", tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("getDomain(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+
+	if _, err := g.getDomain("https://"); err == nil {
+		t.Error("getDomain with no host: want an error")
+	}
+}
+
+func TestSuffixList(t *testing.T) {
+	list := []byte(`// ===BEGIN ICANN DOMAINS===
+uk
+co.uk
+*.ck
+!www.ck
+рф
+// ===BEGIN PRIVATE DOMAINS===
+github.io
+`)
+	g := &ghost{psl: parseSuffixList(list)}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.example.co.uk", "example.co.uk"},
+		{"https://user.github.io", "user.github.io"},
+		{"https://a.b.c.ck", "b.c.ck"},
+		{"https://www.ck", "www.ck"},
+		{"https://a.www.ck", "www.ck"},
+		{"https://www.пример.рф", "xn--e1afmkfd.xn--p1ai"},
+		{"https://example.unlisted", "example.unlisted"},
+	}
+	for _, tt := range tests {
+		got, err := g.getDomain(tt.url)
+		if err != nil {
+			t.Errorf("getDomain(%q): %v", tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("getDomain(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSplitHostUnicode(t *testing.T) {
+	g := &ghost{}
+	p, err := g.splitHost("www.xn--bcher-kva.de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := hostParts{Host: "www.xn--bcher-kva.de", Unicode: "www.bücher.de", Subdomain: "www", Domain: "xn--bcher-kva.de", Suffix: "de"}
+	if p != want {
+		t.Errorf("splitHost = %+v, want %+v", p, want)
+	}
+}
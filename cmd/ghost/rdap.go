@@ -0,0 +1,508 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// rdapBootstrap holds a snapshot of the IANA RDAP bootstrap registry. Use
+// -rdap-refresh to replace it with the current files.
+//
+//go:embed rdap/*.json
+var rdapBootstrap embed.FS
+
+const (
+	// rdapBootstrapURL is where the current bootstrap files are published.
+	rdapBootstrapURL = "https://data.iana.org/rdap/"
+	// rdapFallback is asked about addresses and AS numbers missing from
+	// the bootstrap files. The RIRs redirect queries for resources they
+	// don't manage to the RIR that does.
+	rdapFallback = "https://rdap.arin.net/registry/"
+)
+
+// rdapBootstrapFiles are the bootstrap registries, by object type.
+var rdapBootstrapFiles = map[string]string{
+	"asn":    "asn.json",
+	"domain": "dns.json",
+	"ipv4":   "ipv4.json",
+	"ipv6":   "ipv6.json",
+}
+
+// bootstrapFile is an IANA RDAP bootstrap registry (RFC 9224). Each
+// service pairs a list of entries (TLDs, prefixes, or AS number ranges)
+// with the base URLs of the servers for them.
+type bootstrapFile struct {
+	Publication string       `json:"publication"`
+	Services    [][][]string `json:"services"`
+}
+
+// refreshBootstrap downloads the current bootstrap files from IANA into
+// the cache directory.
+func (g *ghost) refreshBootstrap(timeout int) {
+	dir, err := cacheDir("rdap")
+	if err != nil {
+		g.errorLog.Printf("unable to refresh RDAP bootstrap: %v\n", err)
+		return
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		g.errorLog.Printf("unable to make %s: %v\n", dir, err)
+		return
+	}
+	for _, name := range rdapBootstrapFiles {
+		body, err := g.getData(rdapBootstrapURL+name, timeout)
+		if err != nil {
+			g.errorLog.Printf("unable to refresh %s: %v\n", name, err)
+			continue
+		}
+		var b bootstrapFile
+		err = json.Unmarshal(body, &b)
+		if err != nil || len(b.Services) == 0 {
+			g.errorLog.Printf("%s is not a bootstrap file: %v\n", name, err)
+			continue
+		}
+		g.writeFileAt(filepath.Join(dir, name), body)
+	}
+}
+
+// loadBootstrap returns the bootstrap file for kind, preferring a
+// refreshed copy in the cache directory over the embedded snapshot.
+func loadBootstrap(kind string) (*bootstrapFile, error) {
+	name := rdapBootstrapFiles[kind]
+	var data []byte
+	if dir, err := cacheDir("rdap"); err == nil {
+		data, _ = os.ReadFile(filepath.Join(dir, name))
+	}
+	if data == nil {
+		var err error
+		data, err = rdapBootstrap.ReadFile("rdap/" + name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var b bootstrapFile
+	err := json.Unmarshal(data, &b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &b, nil
+}
+
+// baseURL returns the first HTTPS server of a service, or its first
+// server if none use HTTPS.
+func baseURL(urls []string) string {
+	for _, u := range urls {
+		if strings.HasPrefix(u, "https://") {
+			return u
+		}
+	}
+	if len(urls) > 0 {
+		return urls[0]
+	}
+	return ""
+}
+
+// domainServer returns the RDAP server for domain, matching the longest
+// suffix of its labels listed in the bootstrap file.
+func (b *bootstrapFile) domainServer(domain string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(domain, ".")), ".")
+	var best string
+	var bestLen int
+	for _, s := range b.Services {
+		if len(s) < 2 {
+			continue
+		}
+		for _, entry := range s[0] {
+			n := strings.Count(entry, ".") + 1
+			if n > len(labels) || n <= bestLen {
+				continue
+			}
+			if strings.Join(labels[len(labels)-n:], ".") == strings.ToLower(entry) {
+				best, bestLen = baseURL(s[1]), n
+			}
+		}
+	}
+	return best
+}
+
+// ipServer returns the RDAP server for ip, matching the longest prefix
+// listed in the bootstrap file.
+func (b *bootstrapFile) ipServer(ip net.IP) string {
+	var best string
+	bestLen := -1
+	for _, s := range b.Services {
+		if len(s) < 2 {
+			continue
+		}
+		for _, entry := range s[0] {
+			_, prefix, err := net.ParseCIDR(entry)
+			if err != nil || !prefix.Contains(ip) {
+				continue
+			}
+			if n, _ := prefix.Mask.Size(); n > bestLen {
+				best, bestLen = baseURL(s[1]), n
+			}
+		}
+	}
+	return best
+}
+
+// asnServer returns the RDAP server for the AS number asn.
+func (b *bootstrapFile) asnServer(asn int64) string {
+	for _, s := range b.Services {
+		if len(s) < 2 {
+			continue
+		}
+		for _, entry := range s[0] {
+			lo, hi, found := strings.Cut(entry, "-")
+			if !found {
+				hi = lo
+			}
+			start, err1 := strconv.ParseInt(lo, 10, 64)
+			end, err2 := strconv.ParseInt(hi, 10, 64)
+			if err1 == nil && err2 == nil && asn >= start && asn <= end {
+				return baseURL(s[1])
+			}
+		}
+	}
+	return ""
+}
+
+// rdapEntity is a contact in an RDAP response.
+type rdapEntity struct {
+	Handle     string        `json:"handle"`
+	Roles      []string      `json:"roles"`
+	VCardArray []interface{} `json:"vcardArray"`
+	Entities   []rdapEntity  `json:"entities"`
+}
+
+// rdapObject holds the fields of an RDAP domain, IP network, or autnum
+// response that ghost reports.
+type rdapObject struct {
+	ObjectClassName string   `json:"objectClassName"`
+	Handle          string   `json:"handle"`
+	LDHName         string   `json:"ldhName"`
+	Name            string   `json:"name"`
+	Type            string   `json:"type"`
+	StartAddress    string   `json:"startAddress"`
+	EndAddress      string   `json:"endAddress"`
+	IPVersion       string   `json:"ipVersion"`
+	StartAutnum     *int64   `json:"startAutnum"`
+	EndAutnum       *int64   `json:"endAutnum"`
+	Country         string   `json:"country"`
+	ParentHandle    string   `json:"parentHandle"`
+	OriginAutnums   []int64  `json:"arin_originas0_originautnums"`
+	Status          []string `json:"status"`
+	Events          []struct {
+		Action string `json:"eventAction"`
+		Date   string `json:"eventDate"`
+	} `json:"events"`
+	Entities    []rdapEntity `json:"entities"`
+	Nameservers []struct {
+		LDHName string `json:"ldhName"`
+	} `json:"nameservers"`
+	CIDRs []struct {
+		V4Prefix string `json:"v4prefix"`
+		V6Prefix string `json:"v6prefix"`
+		Length   int    `json:"length"`
+	} `json:"cidr0_cidrs"`
+}
+
+// rdapContact is a flattened RDAP entity.
+type rdapContact struct {
+	Handle       string   `json:"handle,omitempty"`
+	Roles        []string `json:"roles"`
+	Kind         string   `json:"kind,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Organization string   `json:"organization,omitempty"`
+	Emails       []string `json:"emails,omitempty"`
+	Phones       []string `json:"phones,omitempty"`
+}
+
+// rdapResult is a single lookup in rdap.json. If the RDAP lookup failed
+// and whois answered instead, Whois is set.
+type rdapResult struct {
+	Query       string            `json:"query"`
+	Server      string            `json:"server,omitempty"`
+	Class       string            `json:"class,omitempty"`
+	Handle      string            `json:"handle,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Type        string            `json:"type,omitempty"`
+	Country     string            `json:"country,omitempty"`
+	Parent      string            `json:"parent,omitempty"`
+	Range       string            `json:"range,omitempty"`
+	CIDRs       []string          `json:"cidrs,omitempty"`
+	OriginASNs  []int64           `json:"origin_asns,omitempty"`
+	Status      []string          `json:"status,omitempty"`
+	Events      map[string]string `json:"events,omitempty"`
+	NameServers []string          `json:"name_servers,omitempty"`
+	AbuseEmails []string          `json:"abuse_emails,omitempty"`
+	Contacts    []rdapContact     `json:"contacts,omitempty"`
+	Whois       *whoisRecord      `json:"whois,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// rdapReport is a mutex-protected set of lookups, written to rdap.json.
+type rdapReport struct {
+	mu     sync.Mutex
+	Domain *rdapResult   `json:"domain,omitempty"`
+	IPs    []*rdapResult `json:"ips,omitempty"`
+	ASNs   []*rdapResult `json:"asns,omitempty"`
+}
+
+// vcardContact fills c from a jCard (RFC 7095) array.
+func vcardContact(c *rdapContact, vcard []interface{}) {
+	if len(vcard) < 2 {
+		return
+	}
+	props, ok := vcard[1].([]interface{})
+	if !ok {
+		return
+	}
+	for _, p := range props {
+		prop, ok := p.([]interface{})
+		if !ok || len(prop) < 4 {
+			continue
+		}
+		name, _ := prop[0].(string)
+		value, ok := prop[3].(string)
+		if !ok {
+			// org and adr may be lists
+			if list, isList := prop[3].([]interface{}); isList && len(list) > 0 {
+				value, _ = list[0].(string)
+			}
+		}
+		if value == "" {
+			continue
+		}
+		switch name {
+		case "fn":
+			c.Name = value
+		case "org":
+			c.Organization = value
+		case "kind":
+			c.Kind = value
+		case "email":
+			c.Emails = append(c.Emails, value)
+		case "tel":
+			c.Phones = append(c.Phones, strings.TrimPrefix(value, "tel:"))
+		}
+	}
+}
+
+// flattenEntities turns nested entities into a list of contacts.
+func flattenEntities(entities []rdapEntity) []rdapContact {
+	var contacts []rdapContact
+	for _, e := range entities {
+		c := rdapContact{Handle: e.Handle, Roles: e.Roles}
+		vcardContact(&c, e.VCardArray)
+		contacts = append(contacts, c)
+		contacts = append(contacts, flattenEntities(e.Entities)...)
+	}
+	return contacts
+}
+
+// newRDAPResult summarizes an RDAP response.
+func newRDAPResult(query, server string, o *rdapObject) *rdapResult {
+	r := &rdapResult{
+		Query:      query,
+		Server:     server,
+		Class:      o.ObjectClassName,
+		Handle:     o.Handle,
+		Name:       o.Name,
+		Type:       o.Type,
+		Country:    o.Country,
+		Parent:     o.ParentHandle,
+		OriginASNs: o.OriginAutnums,
+		Status:     o.Status,
+		Contacts:   flattenEntities(o.Entities),
+	}
+	if o.LDHName != "" {
+		r.Name = strings.ToLower(o.LDHName)
+	}
+	if o.StartAddress != "" {
+		r.Range = o.StartAddress + " - " + o.EndAddress
+	}
+	if o.StartAutnum != nil && o.EndAutnum != nil {
+		r.Range = fmt.Sprintf("AS%d - AS%d", *o.StartAutnum, *o.EndAutnum)
+	}
+	for _, c := range o.CIDRs {
+		prefix := c.V4Prefix
+		if prefix == "" {
+			prefix = c.V6Prefix
+		}
+		r.CIDRs = append(r.CIDRs, fmt.Sprintf("%s/%d", prefix, c.Length))
+	}
+	if len(o.Events) > 0 {
+		r.Events = make(map[string]string)
+		for _, e := range o.Events {
+			r.Events[e.Action] = e.Date
+		}
+	}
+	for _, ns := range o.Nameservers {
+		r.NameServers = append(r.NameServers, strings.ToLower(ns.LDHName))
+	}
+	abuse := make(map[string]bool)
+	for _, c := range r.Contacts {
+		for _, role := range c.Roles {
+			if role == "abuse" {
+				for _, e := range c.Emails {
+					abuse[e] = true
+				}
+			}
+		}
+	}
+	r.AbuseEmails = sortedKeys(abuse)
+	return r
+}
+
+// rdapQuery fetches and parses path (e.g. "domain/example.com") from the
+// RDAP server at base.
+func (g *ghost) rdapQuery(base, path string, timeout int) (*rdapObject, error) {
+	u := strings.TrimSuffix(base, "/") + "/" + path
+	g.infoLog.Printf("checking: %s", u)
+	body, err := g.getData(u, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var o rdapObject
+	err = json.Unmarshal(body, &o)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal error: %w", err)
+	}
+	return &o, nil
+}
+
+// rdapDomain looks up domain with RDAP, falling back to whois if there is
+// no RDAP server for its TLD or the lookup fails.
+func (g *ghost) rdapDomain(wg *sync.WaitGroup, domain string, timeout int) {
+	defer wg.Done()
+
+	result := &rdapResult{Query: domain}
+	defer func() {
+		g.rdap.mu.Lock()
+		g.rdap.Domain = result
+		g.rdap.mu.Unlock()
+	}()
+
+	b, err := loadBootstrap("domain")
+	if err != nil {
+		g.errorLog.Printf("unable to load RDAP bootstrap: %v\n", err)
+	}
+	var server string
+	if b != nil {
+		server = b.domainServer(domain)
+	}
+	if server != "" {
+		o, err := g.rdapQuery(server, "domain/"+domain, timeout)
+		if err == nil {
+			result = newRDAPResult(domain, server, o)
+			return
+		}
+		g.errorLog.Printf("RDAP lookup for %s failed: %v\n", domain, err)
+		result.Error = err.Error()
+	} else {
+		g.infoLog.Printf("No RDAP server for %s.\n", domain)
+	}
+
+	g.infoLog.Println("Falling back to whois.")
+	result.Whois = g.saveWhois(domain, timeout)
+}
+
+// rdapIPs looks up each address, and every AS number the responses list
+// as announcing it, with RDAP. Addresses fall back to whois.
+func (g *ghost) rdapIPs(ips []net.IP, timeout int) {
+	v4, err := loadBootstrap("ipv4")
+	if err != nil {
+		g.errorLog.Printf("unable to load RDAP bootstrap: %v\n", err)
+		return
+	}
+	v6, err := loadBootstrap("ipv6")
+	if err != nil {
+		g.errorLog.Printf("unable to load RDAP bootstrap: %v\n", err)
+		return
+	}
+
+	asns := make(map[int64]bool)
+	for _, ip := range ips {
+		b := v6
+		if ip.To4() != nil {
+			b = v4
+		}
+		server := b.ipServer(ip)
+		if server == "" {
+			server = rdapFallback
+		}
+
+		var result *rdapResult
+		o, err := g.rdapQuery(server, "ip/"+ip.String(), timeout)
+		if err == nil {
+			result = newRDAPResult(ip.String(), server, o)
+			for _, asn := range o.OriginAutnums {
+				asns[asn] = true
+			}
+		} else {
+			g.errorLog.Printf("RDAP lookup for %s failed: %v\n", ip, err)
+			result = &rdapResult{Query: ip.String(), Error: err.Error()}
+			record, _, werr := g.whois(ip.String(), timeout)
+			if werr != nil {
+				g.errorLog.Printf("whois lookup for %s failed: %v\n", ip, werr)
+			}
+			result.Whois = record
+		}
+
+		g.rdap.mu.Lock()
+		g.rdap.IPs = append(g.rdap.IPs, result)
+		g.rdap.mu.Unlock()
+	}
+
+	if len(asns) == 0 {
+		return
+	}
+	b, err := loadBootstrap("asn")
+	if err != nil {
+		g.errorLog.Printf("unable to load RDAP bootstrap: %v\n", err)
+		return
+	}
+	for asn := range asns {
+		server := b.asnServer(asn)
+		if server == "" {
+			server = rdapFallback
+		}
+		query := strconv.FormatInt(asn, 10)
+		var result *rdapResult
+		o, err := g.rdapQuery(server, "autnum/"+query, timeout)
+		if err == nil {
+			result = newRDAPResult("AS"+query, server, o)
+		} else {
+			g.errorLog.Printf("RDAP lookup for AS%s failed: %v\n", query, err)
+			result = &rdapResult{Query: "AS" + query, Error: err.Error()}
+		}
+		g.rdap.mu.Lock()
+		g.rdap.ASNs = append(g.rdap.ASNs, result)
+		g.rdap.mu.Unlock()
+	}
+}
+
+// rdapWriter writes the RDAP lookups to rdap.json.
+func (g *ghost) rdapWriter() {
+	g.rdap.mu.Lock()
+	defer g.rdap.mu.Unlock()
+	sort.Slice(g.rdap.IPs, func(i, j int) bool { return g.rdap.IPs[i].Query < g.rdap.IPs[j].Query })
+	sort.Slice(g.rdap.ASNs, func(i, j int) bool { return g.rdap.ASNs[i].Query < g.rdap.ASNs[j].Query })
+
+	b, err := json.Marshal(g.rdap)
+	if err != nil {
+		g.errorLog.Printf("rdap marshal error: %v\n", err)
+		return
+	}
+	g.writeData("rdap.json", b)
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportAsset is a well-known file in the report, with its parsed
+// summary as indented JSON.
+type reportAsset struct {
+	assetResult
+	Summary string
+}
+
+// reportData is everything the HTML report shows.
+type reportData struct {
+	URL       string
+	Host      string
+	Domain    string
+	Dir       string
+	Started   string
+	Finished  string
+	Took      string
+	Command   string
+	Query     string
+	CDX       string
+	Passive   bool
+	Snapshots int
+
+	Chart *captureChart
+	Hits  []searchHit
+	Rules []ruleRow
+
+	Tree     []*urlNode
+	Archived int
+
+	Assets      []reportAsset
+	Robots      *robotsHistory
+	Sitemaps    []sitemapVersion
+	SitemapURLs int
+
+	Whois   *whoisRecord
+	DNS     *dnsReport
+	IPs     []ipInfo
+	Related *relatedReport
+	RDAP    string
+}
+
+// ruleRow is a rule match in the report.
+type ruleRow struct {
+	Rule    string
+	Source  string
+	Strings string
+}
+
+// reportWriter writes a single, self-contained HTML report of the run to
+// report.html: the run's details, a chart of when the target was
+// captured, search hits in context, the archived URLs as a tree, and the
+// well-known file, robots.txt, sitemap, whois, DNS, and IP results. Results
+// other than the snapshots and search hits are read back from the files
+// already written to the run directory, so the report shows whatever the
+// run found.
+func (g *ghost) reportWriter(start time.Time, snaps [][]string) {
+	finished := time.Now()
+	data := reportData{
+		URL:       g.config.url,
+		Started:   start.UTC().Format(time.RFC3339),
+		Finished:  finished.UTC().Format(time.RFC3339),
+		Took:      finished.Sub(start).Round(time.Millisecond).String(),
+		Command:   strings.Join(append([]string{"ghost"}, os.Args[1:]...), " "),
+		Query:     g.queryString(),
+		CDX:       g.formURL(g.config.url, g.config.filters),
+		Passive:   g.config.passive,
+		Snapshots: len(snaps),
+	}
+	data.Host, _ = g.getHost(g.config.url)
+	data.Domain, _ = g.getDomain(g.config.url)
+	if g.out != nil {
+		data.Dir = g.out.dir
+	}
+
+	timestamps := make([]string, 0, len(snaps))
+	for _, s := range snaps {
+		if len(s) > 1 {
+			timestamps = append(timestamps, s[1])
+		}
+	}
+	data.Chart = newCaptureChart(timestamps)
+
+	data.Hits = append(data.Hits, g.searches.hits...)
+	sort.Slice(data.Hits, func(i, j int) bool {
+		if data.Hits[i].Term != data.Hits[j].Term {
+			return data.Hits[i].Term < data.Hits[j].Term
+		}
+		return data.Hits[i].Timestamp < data.Hits[j].Timestamp
+	})
+	for _, r := range ruleTable(g.ruleMatches.matches).rows {
+		row := ruleRow{Rule: r[0].(string), Source: r[1].(string)}
+		if r[2] != nil {
+			row.Strings = fmt.Sprintf("%s × %d", r[2], r[3])
+		}
+		if n := len(data.Rules); n > 0 && data.Rules[n-1].Rule == row.Rule && data.Rules[n-1].Source == row.Source {
+			data.Rules[n-1].Strings += ", " + row.Strings
+			continue
+		}
+		data.Rules = append(data.Rules, row)
+	}
+
+	var archived [][]string
+	if g.readResult("archivedURLs.json", &archived) {
+		data.Tree, data.Archived = newURLTree(archived)
+	}
+
+	assets := append([]assetResult{}, g.assetResults.results...)
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Path < assets[j].Path })
+	for _, a := range assets {
+		ra := reportAsset{assetResult: a}
+		if a.Parsed != nil {
+			if b, err := json.MarshalIndent(a.Parsed, "", "  "); err == nil {
+				ra.Summary = string(b)
+			}
+		}
+		data.Assets = append(data.Assets, ra)
+	}
+
+	var robots robotsHistory
+	if g.readResult("robotsHistory.json", &robots) {
+		data.Robots = &robots
+	}
+	g.readResult("sitemaps.json", &data.Sitemaps)
+	var sitemapURLs []sitemapURL
+	if g.readResult("sitemapURLs.json", &sitemapURLs) {
+		data.SitemapURLs = len(sitemapURLs)
+	}
+
+	var whois whoisRecord
+	if g.readResult("whois.json", &whois) {
+		data.Whois = &whois
+	}
+	var dns dnsReport
+	if g.readResult("dns.json", &dns) {
+		data.DNS = &dns
+	}
+	g.readResult("ipinfo.json", &data.IPs)
+	var related relatedReport
+	if g.readResult("related.json", &related) {
+		data.Related = &related
+	}
+	var rdap interface{}
+	if g.readResult("rdap.json", &rdap) {
+		if b, err := json.MarshalIndent(rdap, "", "  "); err == nil {
+			data.RDAP = string(b)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := reportTemplate.Execute(&buf, data)
+	if err != nil {
+		g.errorLog.Printf("report error: %v\n", err)
+		return
+	}
+	g.writeData("report.html", buf.Bytes())
+}
+
+// readResult decodes the JSON result called name from the run directory
+// into v, reporting whether there was one.
+func (g *ghost) readResult(name string, v interface{}) bool {
+	if g.out == nil {
+		return false
+	}
+	b, err := os.ReadFile(g.out.path(name))
+	if err != nil {
+		return false
+	}
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		g.errorLog.Printf("unable to read %s for the report: %v\n", name, err)
+		return false
+	}
+	return true
+}
+
+// queryString describes the run's query.
+func (g *ghost) queryString() string {
+	switch {
+	case g.config.rules != "":
+		return "rules from " + g.config.rules
+	case g.config.regex != "":
+		return "regex " + g.config.regex
+	case g.config.terms != "":
+		return "terms from " + g.config.terms
+	case g.config.term != "":
+		return "term " + g.config.term
+	}
+	return ""
+}
+
+// captureChart is a bar chart of the number of captures per month, or
+// per year when the captures span more than ten years.
+type captureChart struct {
+	Width, Height int
+	LabelY        int
+	Unit          string
+	First, Last   string
+	Max           int
+	Bars          []chartBar
+	Labels        []chartLabel
+}
+
+// chartBar is a single bar of a captureChart.
+type chartBar struct {
+	X, Y, W, H float64
+	Label      string
+	Count      int
+}
+
+// chartLabel is an axis label of a captureChart.
+type chartLabel struct {
+	X    float64
+	Text string
+}
+
+// newCaptureChart counts the captures at each timestamp by month or year
+// and lays them out as bars, or returns nil if there aren't any.
+func newCaptureChart(timestamps []string) *captureChart {
+	const width, height, axis = 900, 160, 20
+
+	months := make(map[int]int)
+	first, last := 0, 0
+	for _, ts := range timestamps {
+		if len(ts) < 6 {
+			continue
+		}
+		t, err := time.Parse("200601", ts[:6])
+		if err != nil {
+			continue
+		}
+		m := t.Year()*12 + int(t.Month()) - 1
+		months[m]++
+		if first == 0 || m < first {
+			first = m
+		}
+		if m > last {
+			last = m
+		}
+	}
+	if len(months) == 0 {
+		return nil
+	}
+
+	c := &captureChart{Width: width, Height: height + axis, LabelY: height + axis - 5, Unit: "month"}
+	label := func(m int) string { return fmt.Sprintf("%d-%02d", m/12, m%12+1) }
+	c.First, c.Last = label(first), label(last)
+
+	// bucket by year for long histories, so the bars stay visible
+	step := 1
+	if last-first >= 120 {
+		c.Unit = "year"
+		step = 12
+		first -= first % 12
+		last -= last % 12
+		label = func(m int) string { return fmt.Sprint(m / 12) }
+	}
+	counts := make(map[int]int)
+	for m, n := range months {
+		counts[m-(m-first)%step] += n
+	}
+	for _, n := range counts {
+		if n > c.Max {
+			c.Max = n
+		}
+	}
+
+	buckets := (last-first)/step + 1
+	w := float64(width) / float64(buckets)
+	for i := 0; i < buckets; i++ {
+		m := first + i*step
+		n := counts[m]
+		h := float64(height-10) * float64(n) / float64(c.Max)
+		c.Bars = append(c.Bars, chartBar{
+			X: float64(i) * w, Y: float64(height) - h, W: w, H: h,
+			Label: label(m), Count: n,
+		})
+		// label each year on a monthly chart, thinning out either kind
+		// of label so they don't overlap
+		if c.Unit == "month" && m%12 != 0 && i != 0 {
+			continue
+		}
+		if n := len(c.Labels); n > 0 && float64(i)*w-c.Labels[n-1].X < 40 {
+			continue
+		}
+		text := label(m)
+		if c.Unit == "month" {
+			text = fmt.Sprint(m / 12)
+		}
+		c.Labels = append(c.Labels, chartLabel{X: float64(i) * w, Text: text})
+	}
+	return c
+}
+
+// urlNode is a host, path segment, or URL in the archived URL tree.
+// Link is set when the archive has a capture of the URL ending at the
+// node.
+type urlNode struct {
+	Name     string
+	Count    int
+	Link     string
+	Children []*urlNode
+	children map[string]*urlNode
+}
+
+// child returns the child called name, adding it if needed.
+func (n *urlNode) child(name string) *urlNode {
+	if c, ok := n.children[name]; ok {
+		return c
+	}
+	if n.children == nil {
+		n.children = make(map[string]*urlNode)
+	}
+	c := &urlNode{Name: name}
+	n.children[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// sort orders the tree's children by name.
+func (n *urlNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// newURLTree arranges archived URLs (a header row followed by rows of
+// CDX fields) by host and path, and returns the hosts and the number of
+// URLs.
+func newURLTree(rows [][]string) ([]*urlNode, int) {
+	if len(rows) < 2 {
+		return nil, 0
+	}
+	original, timestamp := -1, -1
+	for i, f := range rows[0] {
+		switch f {
+		case "original":
+			original = i
+		case "timestamp":
+			timestamp = i
+		}
+	}
+	if original < 0 {
+		return nil, 0
+	}
+
+	root := &urlNode{}
+	count := 0
+	for _, r := range rows[1:] {
+		if original >= len(r) {
+			continue
+		}
+		u, err := url.Parse(r[original])
+		if err != nil || u.Host == "" {
+			continue
+		}
+		count++
+		n := root.child(strings.ToLower(u.Host))
+		n.Count++
+		segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if segments[0] == "" {
+			segments = nil
+		}
+		for i, s := range segments {
+			if i == len(segments)-1 && u.RawQuery != "" {
+				s += "?" + u.RawQuery
+			}
+			n = n.child(s)
+			n.Count++
+		}
+		if len(segments) == 0 && u.RawQuery != "" {
+			n = n.child("?" + u.RawQuery)
+			n.Count++
+		}
+		ts := "*"
+		if timestamp >= 0 && timestamp < len(r) {
+			ts = r[timestamp]
+		}
+		n.Link = fmt.Sprintf("https://web.archive.org/web/%s/%s", ts, r[original])
+	}
+	root.sort()
+	return root.Children, count
+}
+
+// reportTemplate is the HTML report. Everything it needs is inline, so
+// the file can be passed around on its own.
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ghost report: {{.URL}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; max-width: 1100px; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: 4px; margin-top: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { text-align: left; vertical-align: top; padding: 2px 8px; border-bottom: 1px solid #eee; font-size: 14px; }
+th { background: #f4f4f4; }
+table.meta th { background: none; width: 10em; }
+pre, code, .ctx { font-family: monospace; font-size: 12px; }
+pre { background: #f8f8f8; padding: 8px; overflow-x: auto; white-space: pre-wrap; word-break: break-all; }
+.ctx { word-break: break-all; }
+mark { background: #fe6; }
+.n { color: #888; font-size: 12px; }
+.tree ul { list-style: none; padding-left: 1.2em; margin: 0; }
+.tree { list-style: none; padding-left: 0; }
+.tree li { font-family: monospace; font-size: 13px; }
+svg rect { fill: #58a; }
+svg rect:hover { fill: #e83; }
+svg text { font-size: 11px; fill: #555; }
+</style>
+</head>
+<body>
+<h1>{{.URL}}</h1>
+
+<h2>Run</h2>
+<table class="meta">
+<tr><th>Target</th><td>{{.URL}}</td></tr>
+{{if .Host}}<tr><th>Host</th><td>{{.Host}}</td></tr>{{end}}
+{{if .Domain}}<tr><th>Domain</th><td>{{.Domain}}</td></tr>{{end}}
+<tr><th>Started</th><td>{{.Started}}</td></tr>
+<tr><th>Finished</th><td>{{.Finished}} ({{.Took}})</td></tr>
+<tr><th>Command</th><td><code>{{.Command}}</code></td></tr>
+{{if .Query}}<tr><th>Query</th><td>{{.Query}}</td></tr>{{end}}
+<tr><th>CDX query</th><td><code>{{.CDX}}</code></td></tr>
+{{if .Passive}}<tr><th>Passive</th><td>yes</td></tr>{{end}}
+<tr><th>Snapshots</th><td>{{.Snapshots}}</td></tr>
+{{if .Dir}}<tr><th>Run directory</th><td><code>{{.Dir}}</code></td></tr>{{end}}
+</table>
+
+<h2>Captures</h2>
+{{with .Chart}}
+<p>Captures per {{.Unit}}, {{.First}} to {{.Last}} (most in one {{.Unit}}: {{.Max}}).</p>
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" xmlns="http://www.w3.org/2000/svg">
+{{range .Bars}}<rect x="{{printf "%.2f" .X}}" y="{{printf "%.2f" .Y}}" width="{{printf "%.2f" .W}}" height="{{printf "%.2f" .H}}"><title>{{.Label}}: {{.Count}}</title></rect>
+{{end}}{{range .Labels}}<text x="{{printf "%.2f" .X}}" y="{{$.Chart.LabelY}}">{{.Text}}</text>
+{{end}}</svg>
+{{else}}<p>No captures.</p>{{end}}
+
+{{if .Hits}}
+<h2>Search hits</h2>
+<table>
+<tr><th>Term</th><th>Snapshot</th><th>Context</th></tr>
+{{range .Hits}}<tr><td>{{.Term}}</td><td><a href="{{.URL}}">{{if .Timestamp}}{{.Timestamp}}{{else}}{{.URL}}{{end}}</a></td><td class="ctx">&hellip;{{.Before}}<mark>{{.Match}}</mark>{{.After}}&hellip;</td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Rules}}
+<h2>Rule matches</h2>
+<table>
+<tr><th>Rule</th><th>Source</th><th>Strings</th></tr>
+{{range .Rules}}<tr><td>{{.Rule}}</td><td><a href="{{.Source}}">{{.Source}}</a></td><td><code>{{.Strings}}</code></td></tr>
+{{end}}</table>
+{{end}}
+
+{{if .Tree}}
+<h2>Archived URLs</h2>
+<p>{{.Archived}} archived URL(s).</p>
+<ul class="tree">
+{{range .Tree}}{{template "node" .}}{{end}}
+</ul>
+{{end}}
+
+{{if .Assets}}
+<h2>Well-known files</h2>
+<table>
+<tr><th>Path</th><th>Status</th><th>Capture</th><th>Summary</th></tr>
+{{range .Assets}}<tr><td>{{.Path}}</td><td>{{.Status}}{{if .Error}}: {{.Error}}{{end}}</td><td>{{if .Archived}}<a href="{{.Archived}}">{{or .Timestamp .Archived}}</a>{{end}}</td><td>{{if .Summary}}<details><summary>parsed</summary><pre>{{.Summary}}</pre></details>{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{with .Robots}}
+<h2>robots.txt history</h2>
+<p>{{len .Versions}} version(s).</p>
+{{if .Changes}}<table>
+<tr><th>Version</th><th>Added</th><th>Removed</th></tr>
+{{range .Changes}}<tr><td>{{.Timestamp}}</td><td><pre>{{range .Added}}{{.}}
+{{end}}</pre></td><td><pre>{{range .Removed}}{{.}}
+{{end}}</pre></td></tr>
+{{end}}</table>{{end}}
+{{end}}
+
+{{if .Sitemaps}}
+<h2>Sitemaps</h2>
+<p>{{.SitemapURLs}} URL(s) in {{len .Sitemaps}} sitemap version(s).</p>
+<table>
+<tr><th>Sitemap</th><th>Capture</th><th>Kind</th><th>URLs</th><th>Children</th></tr>
+{{range .Sitemaps}}<tr><td>{{.Sitemap}}</td><td>{{.Timestamp}}</td><td>{{.Kind}}</td><td>{{.URLs}}</td><td>{{.Children}}</td></tr>
+{{end}}</table>
+{{end}}
+
+{{with .Whois}}
+<h2>Whois</h2>
+<table class="meta">
+{{if .Domain}}<tr><th>Domain</th><td>{{.Domain}}</td></tr>{{end}}
+{{if .Registrar}}<tr><th>Registrar</th><td>{{.Registrar}}{{if .RegistrarURL}} ({{.RegistrarURL}}){{end}}</td></tr>{{end}}
+{{if .AbuseEmail}}<tr><th>Abuse contact</th><td>{{.AbuseEmail}}</td></tr>{{end}}
+{{with .Registrant}}{{if or .Name .Organization .Email .Country}}<tr><th>Registrant</th><td>{{.Name}} {{.Organization}} {{.Email}} {{.Country}}</td></tr>{{end}}{{end}}
+{{if .Created}}<tr><th>Created</th><td>{{.Created}}</td></tr>{{end}}
+{{if .Updated}}<tr><th>Updated</th><td>{{.Updated}}</td></tr>{{end}}
+{{if .Expires}}<tr><th>Expires</th><td>{{.Expires}}</td></tr>{{end}}
+{{if .NameServers}}<tr><th>Name servers</th><td>{{range .NameServers}}{{.}}<br>{{end}}</td></tr>{{end}}
+{{if .Status}}<tr><th>Status</th><td>{{range .Status}}{{.}}<br>{{end}}</td></tr>{{end}}
+{{if .Servers}}<tr><th>Servers</th><td>{{range .Servers}}{{.}}<br>{{end}}</td></tr>{{end}}
+</table>
+{{end}}
+
+{{if .RDAP}}
+<h2>RDAP</h2>
+<details><summary>rdap.json</summary><pre>{{.RDAP}}</pre></details>
+{{end}}
+
+{{with .DNS}}
+<h2>DNS</h2>
+<p>Resolver: {{.Resolver}}</p>
+{{if .CNAMEChain}}<p>CNAME chain: {{range $i, $n := .CNAMEChain}}{{if $i}} &rarr; {{end}}{{$n}}{{end}}</p>{{end}}
+<table>
+<tr><th>Name</th><th>Type</th><th>TTL</th><th>Value</th></tr>
+{{range .Records}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.TTL}}</td><td class="ctx">{{.Value}}</td></tr>
+{{end}}</table>
+{{if .SPF}}<p>SPF: {{range .SPF}}<code>{{.}}</code> {{end}}</p>{{end}}
+{{if .DMARC}}<p>DMARC: {{range .DMARC}}<code>{{.}}</code> {{end}}</p>{{end}}
+{{if .Verification}}<p>Verification tokens: {{range .Verification}}{{.Service}} {{end}}</p>{{end}}
+{{if .Errors}}<p>Errors: {{range .Errors}}{{.}}<br>{{end}}</p>{{end}}
+{{end}}
+
+{{if or .IPs .Related}}
+<h2>IP addresses</h2>
+{{if .IPs}}<table>
+<tr><th>IP</th><th>AS</th><th>Country</th><th>City</th><th>Provider</th><th>Range</th></tr>
+{{range .IPs}}<tr><td>{{.IP}}</td><td>{{if .ASN}}AS{{.ASN}} {{end}}{{.ASOrg}}</td><td>{{.Country}}</td><td>{{.City}}</td><td>{{.Provider}}{{if .Kind}} ({{.Kind}}){{end}}</td><td>{{.Range}}</td></tr>
+{{end}}</table>{{end}}
+{{with .Related}}
+{{if .PTR}}<table>
+<tr><th>IP</th><th>PTR names</th><th>Patterns</th></tr>
+{{range .PTR}}<tr><td>{{.IP}}</td><td>{{range .Names}}{{.}}<br>{{end}}</td><td>{{range .Patterns}}<code>{{.}}</code><br>{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{range .Pivots}}<p>Pivot on {{.Suffix}}: {{if .Skipped}}skipped ({{.Skipped}}){{else}}{{len .Hosts}} related host(s){{end}}.</p>
+{{end}}
+{{end}}
+{{end}}
+
+</body>
+</html>
+{{define "node"}}<li>{{if .Children}}<details><summary>{{.Name}} <span class="n">{{.Count}}</span>{{if .Link}} <a href="{{.Link}}">&#8599;</a>{{end}}</summary><ul>{{range .Children}}{{template "node" .}}{{end}}</ul></details>{{else}}{{if .Link}}<a href="{{.Link}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}{{end}}</li>
+{{end}}`))
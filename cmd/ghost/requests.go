@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -39,44 +40,66 @@ func (g *ghost) randomUA() string {
 	return userAgents[rando]
 }
 
-// checkAsset checks if the Wayback Machine has a snapshot for a given URL.
-// If it does, checkAsset will get the snapshot and write its contents to
-// a file.
-func (g *ghost) checkAsset(wg *sync.WaitGroup, url, filename string, timeout int) {
+// checkAsset checks if the Wayback Machine has a snapshot for a given
+// asset (robots.txt, sitemap.xml, or another well-known file) under a URL.
+// If it does, checkAsset will get the snapshot, write its contents to a
+// file, and parse it for the well-known report.
+func (g *ghost) checkAsset(wg *sync.WaitGroup, url string, asset wellKnownFile, timeout int) {
 	defer wg.Done()
 
-	// call function to parse filename and create URL
-	u := g.createURL(url, filename)
+	u := g.createURL(url, asset.path)
+	result := assetResult{Path: asset.path}
+	defer func() { g.assetResults.store(result) }()
 
 	available := g.checkAvailable(u, timeout)
 	if available == "" {
 		g.errorLog.Printf("unable to get %s\n", u)
+		result.Status = "not archived"
 		return
 	}
+	result.Archived = available
+	// fetch the archived copy, never the live asset
+	archived := available
+	if m := snapshotTimestamp.FindStringSubmatch(available); m != nil {
+		result.Timestamp = m[1]
+		archived = archivedFile{Timestamp: m[1], Original: u}.rawURL()
+	}
 
-	body, err := g.getData(u, timeout)
+	body, err := g.getData(archived, timeout)
 	if err != nil {
-		g.errorLog.Printf("unable to get %s: %v\n", u, err)
+		g.errorLog.Printf("unable to get %s: %v\n", archived, err)
+		result.Status = "error"
+		result.Error = err.Error()
 		return
 	}
-	if len(body) > 0 {
-		g.writeData(filename, body)
-	} else {
-		g.errorLog.Printf("no data at %s\n", u)
+	if len(body) == 0 {
+		g.errorLog.Printf("no data at %s\n", archived)
+		result.Status = "empty"
+		return
 	}
+
+	g.writeData(asset.filename, body)
+	result.Status = "found"
+	result.File = asset.filename
+
+	if asset.parse != nil {
+		parsed, err := asset.parse(body)
+		if err != nil {
+			g.errorLog.Printf("unable to parse %s: %v\n", u, err)
+			result.Error = err.Error()
+		} else {
+			result.Parsed = parsed
+		}
+	}
+
+	g.scanAsset(body, archived)
 }
 
-// createURL takes in a URL and a filename and uses the filename to
-// determine whether to return URL/robots.txt/ or URL/sitemap.xml/.
-func (g *ghost) createURL(url, filename string) string {
+// createURL takes in a URL and the path of an asset and returns
+// URL/path.
+func (g *ghost) createURL(url, path string) string {
 	url = strings.TrimSuffix(url, "/")
-	var u string
-	if strings.HasSuffix(filename, "robots.txt") {
-		u = fmt.Sprintf("%s/robots.txt", url)
-	} else {
-		u = fmt.Sprintf("%s/sitemap.xml", url)
-	}
-	return u
+	return fmt.Sprintf("%s/%s", url, path)
 }
 
 // wayback struct for storing the information coming back
@@ -122,7 +145,7 @@ func (g *ghost) checkAvailable(url string, timeout int) string {
 }
 
 // getData takes in a url and a timeout and returns the response body as
-// a slice of bytes.
+// a slice of bytes. Requests go through the guarded client.
 func (g *ghost) getData(url string, timeout int) ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
 	defer cancel()
@@ -135,7 +158,7 @@ func (g *ghost) getData(url string, timeout int) ([]byte, error) {
 	uAgent := g.randomUA()
 	req.Header.Set("User-Agent", uAgent)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := g.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -150,10 +173,74 @@ func (g *ghost) getData(url string, timeout int) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("unable to read response body: %w", err)
 	}
+	if g.evidence != nil {
+		// the capture is the one redirected to, which may have a
+		// different timestamp than the one asked for
+		g.captureEvidence(url, resp.Request.URL.String(), body)
+	}
 
 	return body, nil
 }
 
+// archivedFile is a single capture of a file listed by the CDX server.
+type archivedFile struct {
+	Timestamp string `json:"timestamp"`
+	Original  string `json:"original"`
+	Digest    string `json:"digest"`
+}
+
+// rawURL returns the Wayback Machine URL for the original, unmodified
+// (id_) content of the capture.
+func (f archivedFile) rawURL() string {
+	return fmt.Sprintf("https://web.archive.org/web/%sid_/%s", f.Timestamp, f.Original)
+}
+
+// listArchived queries the CDX server for distinct captures of URLs under
+// domain (including subdomains) whose original URL matches pattern, a CDX
+// filter regex. At most limit captures are returned.
+func (g *ghost) listArchived(domain, pattern string, limit, timeout int) ([]archivedFile, error) {
+	params := fmt.Sprintf("url=%s&matchType=domain&filter=original:%s&limit=%d", domain, url.QueryEscape(pattern), limit)
+	return g.listCaptures(params, timeout)
+}
+
+// listCaptures queries the CDX server with params for distinct (by
+// digest) successful captures.
+func (g *ghost) listCaptures(params string, timeout int) ([]archivedFile, error) {
+	const base = "http://web.archive.org/cdx/search/cdx?output=json&fl=timestamp,original,digest&collapse=digest&filter=statuscode:200"
+	u := fmt.Sprintf("%s&%s", base, params)
+	g.infoLog.Printf("checking: %s", u)
+
+	body, err := g.getData(u, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	var rows [][]string
+	err = json.Unmarshal(body, &rows)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal error: %w", err)
+	}
+	if len(rows) < 2 {
+		return nil, nil
+	}
+
+	// skip the key
+	var files []archivedFile
+	for _, r := range rows[1:] {
+		if len(r) < 3 {
+			continue
+		}
+		files = append(files, archivedFile{Timestamp: r[0], Original: r[1], Digest: r[2]})
+		if g.evidence != nil {
+			g.evidence.addDigest(r[0], r[1], r[2])
+		}
+	}
+	return files, nil
+}
+
 // getSnaps takes in a byte slice (obtained from the cdx server), unmarshals
 // it, and returns the wayback machine snapshots in a slice.
 func (g *ghost) getSnaps(data []byte) ([][]string, error) {
@@ -166,7 +253,11 @@ func (g *ghost) getSnaps(data []byte) ([][]string, error) {
 		return nil, errors.New("no wayback machine snapshots found. If using limit=-1, try limit=-2")
 	}
 
-	g.writeData("data/snaps.json", data)
+	g.writeData("snaps.json", data)
+	if g.evidence != nil {
+		g.evidence.addDigests(snaps)
+	}
+	g.storeTable(cdxTable("snapshots", snaps, "statuscode", "length"))
 
 	g.infoLog.Printf("Found %d snapshot(s).", len(snaps[1:]))
 
@@ -176,7 +267,8 @@ func (g *ghost) getSnaps(data []byte) ([][]string, error) {
 
 // archivedURLs leverages the Wayback Machine API responsible for populating
 // all captured URLs associated with a given URL prefix. The data is written
-// to an archivedURLs.json file.
+// to an archivedURLs.json file, then sorted, mined for endpoints, and
+// classified for interesting paths.
 func (g *ghost) archivedURLs(wg *sync.WaitGroup, url string, timeout int) {
 	defer wg.Done()
 	now := time.Now()
@@ -190,7 +282,13 @@ func (g *ghost) archivedURLs(wg *sync.WaitGroup, url string, timeout int) {
 	}
 	if len(body) > 0 {
 		g.sortData(body)
-		g.writeData("data/archivedURLs.json", body)
+		g.mineURLs(body)
+		g.classifyURLs(body)
+		g.writeData("archivedURLs.json", body)
+		var rows [][]string
+		if json.Unmarshal(body, &rows) == nil {
+			g.storeTable(cdxTable("archived_urls", rows, "groupcount", "uniqcount"))
+		}
 	} else {
 		g.errorLog.Println("no archived links on web.archive.org")
 	}
@@ -232,7 +330,7 @@ func (g *ghost) sortData(data []byte) {
 			g.errorLog.Printf("sortData marshal error: %v\n", err)
 			return
 		}
-		g.writeData("data/unique.json", b)
+		g.writeData("unique.json", b)
 	}
 	if len(multiple) > 0 {
 		b, err := g.JSON(multiple)
@@ -240,7 +338,7 @@ func (g *ghost) sortData(data []byte) {
 			g.errorLog.Printf("sortData marshal error: %v\n", err)
 			return
 		}
-		g.writeData("data/multiple.json", b)
+		g.writeData("multiple.json", b)
 	}
 }
 
@@ -259,53 +357,13 @@ func (g *ghost) JSON(data [][]string) ([]byte, error) {
 	return bytes.TrimRight(buf.Bytes(), "\n"), err
 }
 
-// whoisLookup checks the "whois.iana.org" server to find information about the domain. Any
-// results are then written to "whois.txt" via the writeData function.
-func (g *ghost) whoisLookup(wg *sync.WaitGroup, domain string, timeout int) {
-	defer wg.Done()
-	// incorporate lookup if there eventually becomes a need past iana
-	port := "43"
-	server := "whois.iana.org"
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
-	defer cancel()
-
-	var d = &net.Dialer{}
-	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(server, port))
-	if err != nil {
-		g.errorLog.Printf("whois connection failure: %v\n", err)
-		return
-	}
-
-	defer conn.Close()
-
-	_, err = conn.Write([]byte(domain + "\r\n"))
-	if err != nil {
-		g.errorLog.Printf("send to whois failure: %v\n", err)
-		return
-	}
-
-	buff, err := io.ReadAll(conn)
-	if err != nil {
-		g.errorLog.Printf("whois read failure: %v\n", err)
-		return
-	}
-
-	if len(buff) > 0 {
-		g.writeData("data/whois.txt", buff)
-	} else {
-		g.infoLog.Println("No results for whois.")
-	}
-}
-
-// getIP takes in a host and writes the IPv4 and IPv6 addresses
-// to a file.
-func (g *ghost) getIP(wg *sync.WaitGroup, host string) {
-	defer wg.Done()
+// getIP takes in a host, writes the IPv4 and IPv6 addresses to a file,
+// and returns them.
+func (g *ghost) getIP(host string) []net.IP {
 	ips, err := net.LookupIP(host)
 	if err != nil {
 		g.errorLog.Println("unable to look up IP")
-		return
+		return nil
 	}
 
 	var ipByte []byte
@@ -313,12 +371,13 @@ func (g *ghost) getIP(wg *sync.WaitGroup, host string) {
 		b, err := ip.MarshalText()
 		if err != nil {
 			g.errorLog.Println("marshal error in getIP")
-			return
+			return nil
 		}
 		ipByte = append(ipByte, b...)
 		// add breaks
 		ipByte = append(ipByte, byte(0x0A))
 	}
 
-	g.writeData("data/ip.txt", ipByte)
+	g.writeData("ip.txt", ipByte)
+	return ips
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/publicsuffix"
+)
+
+// ptrNumber matches the numbers that vary between hosts sharing a reverse
+// DNS naming scheme. Single digits stuck to letters, like the 2 in ec2,
+// are part of the scheme's name and stay.
+var ptrNumber = regexp.MustCompile(`[0-9]{2,}|\b[0-9]\b`)
+
+// ptrHexGroup matches the groups of an IPv6 address spelled out in a
+// label.
+var ptrHexGroup = regexp.MustCompile(`[0-9a-f]+`)
+
+// ptrName is the reverse DNS of one address.
+type ptrName struct {
+	IP       string   `json:"ip"`
+	Names    []string `json:"names,omitempty"`
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// relatedHost is a host the archive has seen under a PTR suffix.
+// MatchesPattern is set when its name follows the same scheme as the
+// target's PTR name, not just the same suffix.
+type relatedHost struct {
+	*subdomain
+	MatchesPattern bool `json:"matches_pattern"`
+}
+
+// pivot is a group of PTR names sharing a suffix, and the other hosts
+// the archive has captured under it.
+type pivot struct {
+	Suffix   string         `json:"suffix"`
+	Patterns []string       `json:"patterns"`
+	IPs      []string       `json:"ips"`
+	Skipped  string         `json:"skipped,omitempty"`
+	Hosts    []*relatedHost `json:"hosts,omitempty"`
+}
+
+// relatedReport is the result written to related.json.
+type relatedReport struct {
+	Resolver string    `json:"resolver"`
+	PTR      []ptrName `json:"ptr"`
+	Pivots   []*pivot  `json:"pivots,omitempty"`
+	Errors   []string  `json:"errors,omitempty"`
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of ip.
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	const hex = "0123456789abcdef"
+	ip16 := ip.To16()
+	var b strings.Builder
+	for i := len(ip16) - 1; i >= 0; i-- {
+		b.WriteByte(hex[ip16[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hex[ip16[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa")
+	return b.String()
+}
+
+// encodesAddress reports whether a PTR label spells out (part of) ip,
+// like ec2-192-0-2-1, 1-2-0-192, c0000201, or a bare octet.
+func encodesAddress(label string, ip net.IP) bool {
+	if label != "" && strings.Trim(label, "0123456789") == "" {
+		return true
+	}
+	ip4 := ip.To4()
+	if ip4 == nil {
+		// IPv6 names usually spell out groups separated by dashes
+		return strings.Count(label, "-") >= 3 && strings.Trim(label, "0123456789abcdef-") == ""
+	}
+	forms := []string{
+		fmt.Sprintf("%d-%d-%d-%d", ip4[0], ip4[1], ip4[2], ip4[3]),
+		fmt.Sprintf("%d-%d-%d-%d", ip4[3], ip4[2], ip4[1], ip4[0]),
+		fmt.Sprintf("%03d%03d%03d%03d", ip4[0], ip4[1], ip4[2], ip4[3]),
+		fmt.Sprintf("%02x%02x%02x%02x", ip4[0], ip4[1], ip4[2], ip4[3]),
+	}
+	for _, f := range forms {
+		if strings.Contains(label, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// ptrPattern splits a PTR name into the part naming this one address and
+// the suffix shared with its neighbours, and generalizes the former by
+// replacing its numbers with *. For ec2-192-0-2-1.compute-1.amazonaws.com
+// that's ec2-*-*-*-*.compute-1.amazonaws.com and compute-1.amazonaws.com.
+// Names with no address in them lose only their first label.
+func ptrPattern(name string, ip net.IP) (pattern, suffix string) {
+	labels := strings.Split(strings.ToLower(name), ".")
+	last := 0
+	for i, l := range labels {
+		if encodesAddress(l, ip) {
+			last = i
+		}
+	}
+	if last+1 >= len(labels) {
+		return "", ""
+	}
+	prefix := make([]string, last+1)
+	for i, l := range labels[:last+1] {
+		if ip.To4() == nil && encodesAddress(l, ip) {
+			prefix[i] = ptrHexGroup.ReplaceAllString(l, "*")
+			continue
+		}
+		prefix[i] = ptrNumber.ReplaceAllString(l, "*")
+	}
+	suffix = strings.Join(labels[last+1:], ".")
+	return strings.Join(prefix, ".") + "." + suffix, suffix
+}
+
+// patternRegexp compiles a PTR pattern, with * matching a run of digits
+// (or hex digits, for IPv6 names).
+func patternRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, "[0-9a-f]+") + "$")
+}
+
+// reverseDNS looks up the PTR names of each address through the
+// -resolver and works out the naming pattern each one follows. With
+// -pivot, it also asks the archive for every host it has seen under each
+// PTR suffix, marking those that follow the same pattern: other servers
+// on the same hosting platform or network. Suffixes that are ICANN
+// public suffixes, or belong to the target's own domain, aren't pivoted
+// on. The report is written to related.json.
+func (g *ghost) reverseDNS(ips []net.IP, domain string, timeout int) {
+	if len(ips) == 0 {
+		return
+	}
+	r, err := parseResolver(g.config.resolver)
+	if err != nil {
+		g.errorLog.Printf("invalid resolver: %v\n", err)
+		return
+	}
+	report := relatedReport{Resolver: r.String()}
+
+	pivots := make(map[string]*pivot)
+	for _, ip := range ips {
+		entry := ptrName{IP: ip.String()}
+		answers, err := g.dnsQuery(r, reverseName(ip), dnsmessage.TypePTR, timeout)
+		if err != nil {
+			msg := fmt.Sprintf("%s PTR: %v", ip, err)
+			g.errorLog.Println(msg)
+			report.Errors = append(report.Errors, msg)
+		}
+		for _, a := range answers {
+			rec, ok := dnsRecordFrom(a)
+			if !ok || rec.Type != "PTR" {
+				continue
+			}
+			name := strings.ToLower(rec.Value)
+			entry.Names = append(entry.Names, name)
+
+			pattern, suffix := ptrPattern(name, ip)
+			if pattern == "" {
+				continue
+			}
+			entry.Patterns = append(entry.Patterns, pattern)
+			p, ok := pivots[suffix]
+			if !ok {
+				p = &pivot{Suffix: suffix}
+				pivots[suffix] = p
+				report.Pivots = append(report.Pivots, p)
+			}
+			if !contains(p.Patterns, pattern) {
+				p.Patterns = append(p.Patterns, pattern)
+			}
+			if !contains(p.IPs, entry.IP) {
+				p.IPs = append(p.IPs, entry.IP)
+			}
+		}
+		if len(entry.Names) > 0 {
+			g.infoLog.Printf("%s has PTR %s\n", entry.IP, strings.Join(entry.Names, ", "))
+		}
+		report.PTR = append(report.PTR, entry)
+	}
+
+	if g.config.pivot {
+		for _, p := range report.Pivots {
+			// private suffixes like compute-1.amazonaws.com are exactly
+			// what's worth pivoting on; TLDs like co.uk aren't
+			if ps, icann := publicsuffix.PublicSuffix(p.Suffix); icann && ps == p.Suffix {
+				p.Skipped = "public suffix"
+				continue
+			}
+			if parts, err := g.splitHost(p.Suffix); err == nil && domain != "" && parts.Domain == domain {
+				p.Skipped = "target domain"
+				continue
+			}
+
+			hosts, err := g.archivedHosts(p.Suffix, timeout)
+			if err != nil {
+				msg := fmt.Sprintf("pivot on %s: %v", p.Suffix, err)
+				g.errorLog.Println(msg)
+				report.Errors = append(report.Errors, msg)
+				continue
+			}
+			res := make([]*regexp.Regexp, len(p.Patterns))
+			for i, pattern := range p.Patterns {
+				res[i] = patternRegexp(pattern)
+			}
+			var matching int
+			for _, h := range hosts {
+				rh := &relatedHost{subdomain: h}
+				for _, re := range res {
+					if re.MatchString(h.Host) {
+						rh.MatchesPattern = true
+						matching++
+						break
+					}
+				}
+				p.Hosts = append(p.Hosts, rh)
+			}
+			sort.SliceStable(p.Hosts, func(i, j int) bool {
+				return p.Hosts[i].MatchesPattern && !p.Hosts[j].MatchesPattern
+			})
+			g.infoLog.Printf("Found %d archived host(s) under %s, %d matching %s.\n",
+				len(p.Hosts), p.Suffix, matching, strings.Join(p.Patterns, ", "))
+		}
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		g.errorLog.Printf("reverseDNS marshal error: %v\n", err)
+		return
+	}
+	g.writeData("related.json", b)
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
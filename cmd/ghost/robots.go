@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// robotsGroup is a set of rules that applies to one or more user agents.
+type robotsGroup struct {
+	UserAgents []string `json:"user_agents"`
+	Allow      []string `json:"allow,omitempty"`
+	Disallow   []string `json:"disallow,omitempty"`
+	CrawlDelay string   `json:"crawl_delay,omitempty"`
+}
+
+// robotsFile is a parsed robots.txt.
+type robotsFile struct {
+	Groups   []*robotsGroup `json:"groups"`
+	Sitemaps []string       `json:"sitemaps,omitempty"`
+}
+
+// parseRobots parses the contents of a robots.txt. Consecutive
+// User-agent lines share a group; Sitemap lines apply to the whole file.
+func parseRobots(data []byte) *robotsFile {
+	rf := &robotsFile{}
+	var cur *robotsGroup
+	inAgents := false
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				cur = &robotsGroup{}
+				rf.Groups = append(rf.Groups, cur)
+				inAgents = true
+			}
+			cur.UserAgents = append(cur.UserAgents, value)
+			continue
+		case "sitemap":
+			rf.Sitemaps = append(rf.Sitemaps, value)
+			continue
+		}
+
+		inAgents = false
+		if cur == nil {
+			// rules before any User-agent line apply to everyone
+			cur = &robotsGroup{UserAgents: []string{"*"}}
+			rf.Groups = append(rf.Groups, cur)
+		}
+		switch key {
+		case "allow":
+			if value != "" {
+				cur.Allow = append(cur.Allow, value)
+			}
+		case "disallow":
+			if value != "" {
+				cur.Disallow = append(cur.Disallow, value)
+			}
+		case "crawl-delay":
+			cur.CrawlDelay = value
+		}
+	}
+	return rf
+}
+
+// directives flattens a robots.txt into lines like "Disallow: /admin
+// [*]" so versions can be compared.
+func (rf *robotsFile) directives() map[string]bool {
+	d := make(map[string]bool)
+	for _, g := range rf.Groups {
+		agents := strings.Join(g.UserAgents, ", ")
+		for _, p := range g.Allow {
+			d[fmt.Sprintf("Allow: %s [%s]", p, agents)] = true
+		}
+		for _, p := range g.Disallow {
+			d[fmt.Sprintf("Disallow: %s [%s]", p, agents)] = true
+		}
+		if g.CrawlDelay != "" {
+			d[fmt.Sprintf("Crawl-delay: %s [%s]", g.CrawlDelay, agents)] = true
+		}
+	}
+	for _, s := range rf.Sitemaps {
+		d["Sitemap: "+s] = true
+	}
+	return d
+}
+
+// robotsVersion is a single distinct capture of robots.txt.
+type robotsVersion struct {
+	Timestamp string `json:"timestamp"`
+	URL       string `json:"url"`
+	*robotsFile
+}
+
+// robotsChange lists the directives added and removed between two
+// versions of robots.txt.
+type robotsChange struct {
+	Timestamp string   `json:"timestamp"`
+	Previous  string   `json:"previous,omitempty"`
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+}
+
+// robotsHistory is the result written to robotsHistory.json.
+type robotsHistory struct {
+	Versions []robotsVersion `json:"versions"`
+	Changes  []robotsChange  `json:"changes"`
+	// Captures maps each disallowed path to the archived URLs found
+	// under it, if -robotscdx is set.
+	Captures map[string][]archivedFile `json:"captures,omitempty"`
+}
+
+// robotsHistory fetches every distinct archived version of the target's
+// robots.txt, parses each one, and records when directives were added
+// or removed. All disallowed paths are written to robotsDisallowed.txt;
+// with -robotscdx, each is also searched for in the archive.
+func (g *ghost) robotsHistory(wg *sync.WaitGroup, target string, timeout int) {
+	defer wg.Done()
+
+	u, err := url.Parse(target)
+	if err != nil {
+		g.errorLog.Printf("robotsHistory: %v\n", err)
+		return
+	}
+	robots := u.Host + "/robots.txt"
+
+	files, err := g.listCaptures("url="+robots, timeout)
+	if err != nil {
+		g.errorLog.Printf("unable to list robots.txt captures: %v\n", err)
+		return
+	}
+	if len(files) == 0 {
+		g.infoLog.Println("No archived robots.txt found.")
+		return
+	}
+	g.infoLog.Printf("Found %d version(s) of robots.txt.\n", len(files))
+
+	versions := make([]robotsVersion, len(files))
+	var rwg sync.WaitGroup
+	tokens := make(chan struct{}, g.config.gophers)
+	for i, f := range files {
+		tokens <- struct{}{}
+		rwg.Add(1)
+		i, f := i, f
+		g.goSafe(func() {
+			defer rwg.Done()
+			body, err := g.getData(f.rawURL(), timeout)
+			<-tokens
+			if err != nil {
+				g.errorLog.Printf("getData error for %s: %v\n", f.rawURL(), err)
+				return
+			}
+			g.scanAsset(body, f.rawURL())
+			versions[i] = robotsVersion{
+				Timestamp:  f.Timestamp,
+				URL:        fmt.Sprintf("https://web.archive.org/web/%s/%s", f.Timestamp, f.Original),
+				robotsFile: parseRobots(body),
+			}
+		})
+	}
+	rwg.Wait()
+
+	var h robotsHistory
+	disallowed := make(map[string]bool)
+	var prev map[string]bool
+	var prevTimestamp string
+	for _, v := range versions {
+		// skip versions that failed to download
+		if v.robotsFile == nil {
+			continue
+		}
+		h.Versions = append(h.Versions, v)
+		for _, group := range v.Groups {
+			for _, p := range group.Disallow {
+				disallowed[p] = true
+			}
+		}
+
+		cur := v.directives()
+		change := robotsChange{Timestamp: v.Timestamp, Previous: prevTimestamp}
+		for d := range cur {
+			if !prev[d] {
+				change.Added = append(change.Added, d)
+			}
+		}
+		for d := range prev {
+			if !cur[d] {
+				change.Removed = append(change.Removed, d)
+			}
+		}
+		sort.Strings(change.Added)
+		sort.Strings(change.Removed)
+		if len(change.Added) > 0 || len(change.Removed) > 0 {
+			h.Changes = append(h.Changes, change)
+		}
+		prev, prevTimestamp = cur, v.Timestamp
+	}
+
+	paths := sortedKeys(disallowed)
+	g.writeLines("robotsDisallowed.txt", paths)
+
+	if g.config.robotsCDX {
+		h.Captures = g.disallowedCaptures(u.Host, paths, timeout)
+	}
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		g.errorLog.Printf("robotsHistory marshal error: %v\n", err)
+		return
+	}
+	g.writeData("robotsHistory.json", b)
+}
+
+// maxDisallowedCaptures caps the captures listed for each disallowed path.
+const maxDisallowedCaptures = 1000
+
+// disallowedCaptures searches the archive for captures under each
+// disallowed path on host. Wildcards end the path, and "/" is skipped
+// since it covers the whole site.
+func (g *ghost) disallowedCaptures(host string, paths []string, timeout int) map[string][]archivedFile {
+	captures := make(map[string][]archivedFile)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, g.config.gophers)
+	for _, p := range paths {
+		prefix := strings.TrimSuffix(p, "$")
+		if i := strings.IndexByte(prefix, '*'); i >= 0 {
+			prefix = prefix[:i]
+		}
+		if prefix == "" || prefix == "/" {
+			continue
+		}
+
+		tokens <- struct{}{}
+		wg.Add(1)
+		p, prefix := p, prefix
+		g.goSafe(func() {
+			defer wg.Done()
+			params := fmt.Sprintf("url=%s&matchType=prefix&limit=%d", url.QueryEscape(host+prefix), maxDisallowedCaptures)
+			files, err := g.listCaptures(params, timeout)
+			<-tokens
+			if err != nil {
+				g.errorLog.Printf("unable to search for %s: %v\n", p, err)
+				return
+			}
+			if len(files) > 0 {
+				g.infoLog.Printf("Found %d capture(s) under disallowed path %s.\n", len(files), p)
+				mu.Lock()
+				captures[p] = files
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return captures
+}
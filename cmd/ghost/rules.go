@@ -0,0 +1,1019 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// A rule file holds one or more YARA-style rules. Each rule names a set
+// of strings and a boolean condition over them:
+//
+//	rule admin_panel {
+//	    strings:
+//	        $a = "admin" nocase
+//	        $b = { 3C 66 6F 72 6D ?? }
+//	        $c = /log(in|out)\.php/i
+//	    condition:
+//	        $a and ($b or #c > 2)
+//	}
+//
+// Text strings accept the nocase, wide, and ascii modifiers; as in YARA,
+// nocase only folds ASCII letters. Hex strings support ?? and nibble
+// wildcards plus [n] and [n-m] jumps. Conditions
+// support and, or, not, parentheses, $id, #id counts, filesize, integer
+// comparisons, and "N of them", "any of ($a*)", "all of ($a, $b)" sets.
+
+// ruleSet is the parsed contents of a rule file.
+type ruleSet struct {
+	rules []*rule
+}
+
+// rule is a single named rule with its strings and condition.
+type rule struct {
+	name      string
+	meta      map[string]string
+	strings   []*ruleString
+	condition condNode
+}
+
+// ruleString is a named string within a rule.
+type ruleString struct {
+	id      string
+	matcher stringMatcher
+}
+
+// stringMatcher counts the occurrences of a string within data.
+type stringMatcher interface {
+	count(data *scanData) int
+}
+
+// scanData holds the data being scanned along with a lazily computed
+// lowercase copy for nocase matching.
+type scanData struct {
+	raw   []byte
+	lower []byte
+}
+
+// lowered returns the lowercase copy of the scanned data. Only ASCII
+// letters are folded, so binary and non-UTF-8 data keep their bytes and
+// offsets.
+func (s *scanData) lowered() []byte {
+	if s.lower == nil {
+		s.lower = asciiLower(s.raw)
+	}
+	return s.lower
+}
+
+// asciiLower returns a copy of b with A-Z mapped to a-z.
+func asciiLower(b []byte) []byte {
+	lower := make([]byte, len(b))
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return lower
+}
+
+// textMatcher matches literal text, optionally ignoring case and in
+// UTF-16LE ("wide") form.
+type textMatcher struct {
+	patterns [][]byte
+	nocase   bool
+}
+
+func (t *textMatcher) count(data *scanData) int {
+	haystack := data.raw
+	if t.nocase {
+		haystack = data.lowered()
+	}
+	var n int
+	for _, p := range t.patterns {
+		n += countOverlapping(haystack, p)
+	}
+	return n
+}
+
+// countOverlapping returns the number of offsets in data at which
+// pattern begins.
+func countOverlapping(data, pattern []byte) int {
+	if len(pattern) == 0 {
+		return 0
+	}
+	var n, pos int
+	for {
+		i := bytes.Index(data[pos:], pattern)
+		if i < 0 {
+			return n
+		}
+		n++
+		pos += i + 1
+	}
+}
+
+// regexMatcher matches a regular expression.
+type regexMatcher struct {
+	re *regexp.Regexp
+}
+
+func (r *regexMatcher) count(data *scanData) int {
+	return len(r.re.FindAllIndex(data.raw, -1))
+}
+
+// hexToken is a single element of a hex string: either a (possibly
+// masked) byte or a jump of between min and max arbitrary bytes.
+type hexToken struct {
+	value, mask byte
+	jump        bool
+	min, max    int
+}
+
+// hexMatcher matches a hex string with wildcards and jumps.
+type hexMatcher struct {
+	tokens []hexToken
+}
+
+func (h *hexMatcher) count(data *scanData) int {
+	var n int
+	// whether the tokens after a jump match at a position doesn't depend
+	// on where the match started, so the failures are shared by every start
+	failed := make(map[[2]int]bool)
+	for i := range data.raw {
+		if h.matchAt(data.raw, i, 0, failed) {
+			n++
+		}
+	}
+	return n
+}
+
+// matchAt reports whether the tokens starting at t match data at pos.
+// Each jump remembers the (token, position) pairs it has already seen
+// fail in failed, so that runs of jumps take polynomial time.
+func (h *hexMatcher) matchAt(data []byte, pos, t int, failed map[[2]int]bool) bool {
+	for ; t < len(h.tokens); t++ {
+		tok := h.tokens[t]
+		if tok.jump {
+			for skip := tok.min; skip <= tok.max && pos+skip <= len(data); skip++ {
+				key := [2]int{t + 1, pos + skip}
+				if failed[key] {
+					continue
+				}
+				if h.matchAt(data, pos+skip, t+1, failed) {
+					return true
+				}
+				failed[key] = true
+			}
+			return false
+		}
+		if pos >= len(data) || data[pos]&tok.mask != tok.value&tok.mask {
+			return false
+		}
+		pos++
+	}
+	return true
+}
+
+// readRuleFile reads and parses the rule file at name.
+func (g *ghost) readRuleFile(name string) (*ruleSet, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(string(b))
+}
+
+// parseRules parses the contents of a rule file.
+func parseRules(src string) (*ruleSet, error) {
+	p := &ruleParser{src: src}
+	rs := &ruleSet{}
+	seen := make(map[string]bool)
+	for {
+		p.skipSpace()
+		if p.eof() {
+			break
+		}
+		r, err := p.parseRule()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line(), err)
+		}
+		if seen[r.name] {
+			return nil, fmt.Errorf("duplicate rule %q", r.name)
+		}
+		seen[r.name] = true
+		rs.rules = append(rs.rules, r)
+	}
+	if len(rs.rules) == 0 {
+		return nil, errors.New("no rules found")
+	}
+	return rs, nil
+}
+
+// ruleParser is a hand-rolled scanner over the rule file source.
+type ruleParser struct {
+	src string
+	pos int
+}
+
+func (p *ruleParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+// line returns the current line number, for error messages.
+func (p *ruleParser) line() int {
+	return strings.Count(p.src[:p.pos], "\n") + 1
+}
+
+// skipSpace skips whitespace along with // and /* */ comments.
+func (p *ruleParser) skipSpace() {
+	for !p.eof() {
+		switch {
+		case unicode.IsSpace(rune(p.src[p.pos])):
+			p.pos++
+		case strings.HasPrefix(p.src[p.pos:], "//"):
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end
+			}
+		case strings.HasPrefix(p.src[p.pos:], "/*"):
+			end := strings.Index(p.src[p.pos+2:], "*/")
+			if end < 0 {
+				p.pos = len(p.src)
+			} else {
+				p.pos += end + 4
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next non-space byte without consuming it.
+func (p *ruleParser) peek() byte {
+	p.skipSpace()
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// expect consumes s or returns an error.
+func (p *ruleParser) expect(s string) error {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], s) {
+		return fmt.Errorf("expected %q", s)
+	}
+	p.pos += len(s)
+	return nil
+}
+
+// ident reads an identifier of ASCII letters, digits, and underscores,
+// which may start with $ or #.
+func (p *ruleParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for !p.eof() {
+		c := p.src[p.pos]
+		if c == '_' || c == '$' || c == '#' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.src[start:p.pos]
+}
+
+// parseRule parses a single rule, from the rule keyword to its closing brace.
+func (p *ruleParser) parseRule() (*rule, error) {
+	if kw := p.ident(); kw != "rule" {
+		return nil, fmt.Errorf("expected rule, got %q", kw)
+	}
+	r := &rule{name: p.ident(), meta: make(map[string]string)}
+	if r.name == "" {
+		return nil, errors.New("missing rule name")
+	}
+	// tags are accepted but not used
+	if p.peek() == ':' {
+		p.pos++
+		for p.peek() != '{' && !p.eof() {
+			if p.ident() == "" {
+				return nil, fmt.Errorf("rule %s: bad tag", r.name)
+			}
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, fmt.Errorf("rule %s: %w", r.name, err)
+	}
+
+	for {
+		section := p.ident()
+		if err := p.expect(":"); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.name, err)
+		}
+		switch section {
+		case "meta":
+			if err := p.parseMeta(r); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.name, err)
+			}
+		case "strings":
+			if err := p.parseStrings(r); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.name, err)
+			}
+		case "condition":
+			tokens, err := p.condTokens()
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.name, err)
+			}
+			cond, err := parseCondition(tokens, r)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.name, err)
+			}
+			r.condition = cond
+			return r, nil
+		default:
+			return nil, fmt.Errorf("rule %s: unknown section %q", r.name, section)
+		}
+	}
+}
+
+// parseMeta parses key = value pairs until the next section.
+func (p *ruleParser) parseMeta(r *rule) error {
+	for {
+		save := p.pos
+		key := p.ident()
+		if p.peek() != '=' {
+			p.pos = save
+			return nil
+		}
+		p.pos++
+		var value string
+		if p.peek() == '"' {
+			s, err := p.quoted()
+			if err != nil {
+				return err
+			}
+			value = s
+		} else {
+			value = p.ident()
+		}
+		r.meta[key] = value
+	}
+}
+
+// parseStrings parses $id = <string> [modifiers] lines until the next section.
+func (p *ruleParser) parseStrings(r *rule) error {
+	for p.peek() == '$' {
+		id := p.ident()
+		if err := p.expect("="); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		for _, s := range r.strings {
+			if s.id == id {
+				return fmt.Errorf("duplicate string %s", id)
+			}
+		}
+
+		var (
+			m   stringMatcher
+			err error
+		)
+		switch p.peek() {
+		case '"':
+			var text string
+			text, err = p.quoted()
+			if err == nil {
+				m, err = newTextMatcher(text, p.modifiers())
+			}
+		case '{':
+			m, err = p.hexString()
+			if err == nil && len(p.modifiers()) > 0 {
+				err = errors.New("hex strings take no modifiers")
+			}
+		case '/':
+			m, err = p.regexString()
+		default:
+			err = errors.New("expected text, hex, or regex string")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		r.strings = append(r.strings, &ruleString{id: id, matcher: m})
+	}
+	return nil
+}
+
+// modifiers reads any string modifiers following a string definition.
+func (p *ruleParser) modifiers() []string {
+	var mods []string
+	for {
+		save := p.pos
+		switch m := p.ident(); m {
+		case "nocase", "wide", "ascii":
+			mods = append(mods, m)
+		default:
+			p.pos = save
+			return mods
+		}
+	}
+}
+
+// quoted reads a double-quoted string, interpreting escapes.
+func (p *ruleParser) quoted() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	p.pos++
+	for !p.eof() {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '\n':
+			return "", errors.New("unterminated string")
+		case '"':
+			p.pos++
+			return strconv.Unquote(p.src[start:p.pos])
+		}
+		p.pos++
+	}
+	return "", errors.New("unterminated string")
+}
+
+// hexString reads a { ... } hex string, skipping any comments in it.
+func (p *ruleParser) hexString() (*hexMatcher, error) {
+	p.pos++
+	var body strings.Builder
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, errors.New("unterminated hex string")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		if c == '}' {
+			break
+		}
+		body.WriteByte(c)
+	}
+	return parseHex(body.String())
+}
+
+// parseHex converts the body of a hex string into tokens.
+func parseHex(body string) (*hexMatcher, error) {
+	h := &hexMatcher{}
+	s := strings.Join(strings.Fields(body), "")
+	for i := 0; i < len(s); {
+		if s[i] == '[' {
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, errors.New("unterminated jump")
+			}
+			lo, hi, found := strings.Cut(s[i+1:i+end], "-")
+			min, err := strconv.Atoi(lo)
+			if err != nil {
+				return nil, fmt.Errorf("bad jump %q", s[i:i+end+1])
+			}
+			max := min
+			if found {
+				if max, err = strconv.Atoi(hi); err != nil || max < min {
+					return nil, fmt.Errorf("bad jump %q", s[i:i+end+1])
+				}
+			}
+			h.tokens = append(h.tokens, hexToken{jump: true, min: min, max: max})
+			i += end + 1
+			continue
+		}
+		if i+1 >= len(s) {
+			return nil, errors.New("odd number of hex digits")
+		}
+		var tok hexToken
+		for j, c := range []byte{s[i], s[i+1]} {
+			shift := 4 * (1 - j)
+			if c == '?' {
+				continue
+			}
+			v, err := strconv.ParseUint(string(c), 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("bad hex digit %q", c)
+			}
+			tok.value |= byte(v) << shift
+			tok.mask |= 0xF << shift
+		}
+		h.tokens = append(h.tokens, tok)
+		i += 2
+	}
+	if len(h.tokens) == 0 || h.tokens[0].jump || h.tokens[len(h.tokens)-1].jump {
+		return nil, errors.New("hex string must begin and end with a byte")
+	}
+	return h, nil
+}
+
+// regexString reads a /pattern/flags regular expression.
+func (p *ruleParser) regexString() (*regexMatcher, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.src[p.pos] == '\n' {
+			return nil, errors.New("unterminated regex")
+		}
+		c := p.src[p.pos]
+		if c == '\\' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '/' {
+			b.WriteByte('/')
+			p.pos += 2
+			continue
+		}
+		p.pos++
+		if c == '/' {
+			break
+		}
+		b.WriteByte(c)
+	}
+	var flags string
+	for !p.eof() && (p.src[p.pos] == 'i' || p.src[p.pos] == 's') {
+		flags += string(p.src[p.pos])
+		p.pos++
+	}
+	for _, m := range p.modifiers() {
+		switch m {
+		case "nocase":
+			flags += "i"
+		default:
+			return nil, fmt.Errorf("modifier %s not supported for regex", m)
+		}
+	}
+	pattern := b.String()
+	if flags != "" {
+		pattern = fmt.Sprintf("(?%s)%s", flags, pattern)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &regexMatcher{re: re}, nil
+}
+
+// newTextMatcher builds a textMatcher for text with the given modifiers.
+func newTextMatcher(text string, mods []string) (*textMatcher, error) {
+	var ascii, wide, nocase bool
+	for _, m := range mods {
+		switch m {
+		case "ascii":
+			ascii = true
+		case "wide":
+			wide = true
+		case "nocase":
+			nocase = true
+		}
+	}
+	if text == "" {
+		return nil, errors.New("empty string")
+	}
+	if nocase {
+		text = string(asciiLower([]byte(text)))
+	}
+	t := &textMatcher{nocase: nocase}
+	if ascii || !wide {
+		t.patterns = append(t.patterns, []byte(text))
+	}
+	if wide {
+		t.patterns = append(t.patterns, toWide(text))
+	}
+	return t, nil
+}
+
+// toWide encodes s as UTF-16LE, the way YARA's wide modifier does.
+func toWide(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		if r > 0xFFFF {
+			r = unicode.ReplacementChar
+		}
+		b = append(b, byte(r), byte(r>>8))
+	}
+	return b
+}
+
+// condNode is a node in a parsed condition. Every node evaluates to an
+// integer; booleans are 0 or 1 and any non-zero value is true.
+type condNode interface {
+	eval(ctx *condContext) int64
+}
+
+// condContext holds the string counts for the data being evaluated.
+type condContext struct {
+	counts   map[string]int
+	filesize int
+}
+
+type (
+	condLiteral  int64
+	condFilesize struct{}
+	condMatch    string
+	condCount    string
+	condNot      struct{ x condNode }
+	condBinary   struct {
+		op   string
+		l, r condNode
+	}
+	condOf struct {
+		quantifier string // "any", "all", "none", or empty for a number
+		n          int
+		ids        []string
+	}
+)
+
+func (c condLiteral) eval(*condContext) int64 { return int64(c) }
+
+func (condFilesize) eval(ctx *condContext) int64 { return int64(ctx.filesize) }
+
+func (c condMatch) eval(ctx *condContext) int64 { return boolInt(ctx.counts[string(c)] > 0) }
+
+func (c condCount) eval(ctx *condContext) int64 { return int64(ctx.counts[string(c)]) }
+
+func (c condNot) eval(ctx *condContext) int64 { return boolInt(c.x.eval(ctx) == 0) }
+
+func (c condBinary) eval(ctx *condContext) int64 {
+	switch c.op {
+	case "and":
+		return boolInt(c.l.eval(ctx) != 0 && c.r.eval(ctx) != 0)
+	case "or":
+		return boolInt(c.l.eval(ctx) != 0 || c.r.eval(ctx) != 0)
+	}
+	l, r := c.l.eval(ctx), c.r.eval(ctx)
+	switch c.op {
+	case "==":
+		return boolInt(l == r)
+	case "!=":
+		return boolInt(l != r)
+	case "<":
+		return boolInt(l < r)
+	case "<=":
+		return boolInt(l <= r)
+	case ">":
+		return boolInt(l > r)
+	case ">=":
+		return boolInt(l >= r)
+	}
+	return 0
+}
+
+func (c condOf) eval(ctx *condContext) int64 {
+	var matched int
+	for _, id := range c.ids {
+		if ctx.counts[id] > 0 {
+			matched++
+		}
+	}
+	switch c.quantifier {
+	case "any":
+		return boolInt(matched > 0)
+	case "all":
+		return boolInt(matched == len(c.ids))
+	case "none":
+		return boolInt(matched == 0)
+	}
+	return boolInt(matched >= c.n)
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// condParser is a recursive descent parser over condition tokens.
+type condParser struct {
+	tokens []string
+	pos    int
+	rule   *rule
+}
+
+// condTokenRE matches the identifier, number, operator, or punctuation
+// at the start of a condition.
+var condTokenRE = regexp.MustCompile(`^(?:[$#][A-Za-z0-9_]*\*?|[A-Za-z_][A-Za-z0-9_]*|\d+|==|!=|<=|>=|[<>(),]|\S)`)
+
+// condTokens reads the tokens of a condition through the closing brace of
+// its rule. Comments are skipped and quoted strings are read whole, so a
+// brace inside either doesn't end the rule.
+func (p *ruleParser) condTokens() ([]string, error) {
+	var tokens []string
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, errors.New("missing closing brace")
+		}
+		switch p.src[p.pos] {
+		case '}':
+			p.pos++
+			return tokens, nil
+		case '"':
+			start := p.pos
+			if _, err := p.quoted(); err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, p.src[start:p.pos])
+			continue
+		}
+		tok := condTokenRE.FindString(p.src[p.pos:])
+		tokens = append(tokens, tok)
+		p.pos += len(tok)
+	}
+}
+
+// parseCondition parses the condition tokens of r.
+func parseCondition(tokens []string, r *rule) (condNode, error) {
+	p := &condParser{tokens: tokens, rule: r}
+	if len(p.tokens) == 0 {
+		return nil, errors.New("empty condition")
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition", p.tokens[p.pos])
+	}
+	return n, nil
+}
+
+func (p *condParser) next() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *condParser) parseOr() (condNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "or" {
+		p.pos++
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = condBinary{op: "or", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *condParser) parseAnd() (condNode, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.next() == "and" {
+		p.pos++
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = condBinary{op: "and", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *condParser) parseNot() (condNode, error) {
+	if p.next() == "not" {
+		p.pos++
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return condNot{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *condParser) parseCompare() (condNode, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.next(); op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		p.pos++
+		r, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return condBinary{op: op, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *condParser) parsePrimary() (condNode, error) {
+	tok := p.next()
+	if tok == "" {
+		return nil, errors.New("unexpected end of condition")
+	}
+	p.pos++
+	switch {
+	case tok == "(":
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return n, nil
+	case tok == "true":
+		return condLiteral(1), nil
+	case tok == "false":
+		return condLiteral(0), nil
+	case tok == "filesize":
+		return condFilesize{}, nil
+	case tok == "any" || tok == "all" || tok == "none":
+		return p.parseOf(tok, 0)
+	case tok[0] >= '0' && tok[0] <= '9':
+		n, err := strconv.Atoi(tok)
+		if err != nil {
+			return nil, err
+		}
+		if p.next() == "of" {
+			return p.parseOf("", n)
+		}
+		return condLiteral(n), nil
+	case tok[0] == '$':
+		if err := p.checkString(tok); err != nil {
+			return nil, err
+		}
+		return condMatch(tok), nil
+	case tok[0] == '#':
+		id := "$" + tok[1:]
+		if err := p.checkString(id); err != nil {
+			return nil, err
+		}
+		return condCount(id), nil
+	}
+	return nil, fmt.Errorf("unexpected %q in condition", tok)
+}
+
+// parseOf parses the "of them" or "of ($a, $b*)" following a quantifier.
+func (p *condParser) parseOf(quantifier string, n int) (condNode, error) {
+	if p.next() != "of" {
+		return nil, fmt.Errorf("expected of after %s", quantifier)
+	}
+	p.pos++
+
+	var patterns []string
+	switch p.next() {
+	case "them":
+		p.pos++
+		patterns = []string{"$*"}
+	case "(":
+		p.pos++
+		for {
+			tok := p.next()
+			if tok == "" || tok[0] != '$' {
+				return nil, errors.New("expected string identifier in set")
+			}
+			patterns = append(patterns, tok)
+			p.pos++
+			if p.next() == ")" {
+				p.pos++
+				break
+			}
+			if p.next() != "," {
+				return nil, errors.New("expected , or ) in set")
+			}
+			p.pos++
+		}
+	default:
+		return nil, errors.New("expected them or a string set after of")
+	}
+
+	var ids []string
+	for _, s := range p.rule.strings {
+		for _, pattern := range patterns {
+			if s.id == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(s.id, strings.TrimSuffix(pattern, "*"))) {
+				ids = append(ids, s.id)
+				break
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("string set %v matches no strings", patterns)
+	}
+	if quantifier == "" && n > len(ids) {
+		return nil, fmt.Errorf("%d of a set of %d strings can never match", n, len(ids))
+	}
+	return condOf{quantifier: quantifier, n: n, ids: ids}, nil
+}
+
+// checkString verifies that the rule defines the string id.
+func (p *condParser) checkString(id string) error {
+	for _, s := range p.rule.strings {
+		if s.id == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("undefined string %s", id)
+}
+
+// ruleMatch records a rule that matched a source, along with the
+// number of times each of its strings was found.
+type ruleMatch struct {
+	Source  string         `json:"source"`
+	Strings map[string]int `json:"strings"`
+}
+
+// scan evaluates every rule against data and returns the matches.
+func (rs *ruleSet) scan(data []byte, source string) map[string]ruleMatch {
+	sd := &scanData{raw: data}
+	matches := make(map[string]ruleMatch)
+	for _, r := range rs.rules {
+		ctx := &condContext{counts: make(map[string]int), filesize: len(data)}
+		for _, s := range r.strings {
+			ctx.counts[s.id] = s.matcher.count(sd)
+		}
+		if r.condition.eval(ctx) == 0 {
+			continue
+		}
+		found := make(map[string]int)
+		for id, n := range ctx.counts {
+			if n > 0 {
+				found[id] = n
+			}
+		}
+		matches[r.name] = ruleMatch{Source: source, Strings: found}
+	}
+	return matches
+}
+
+// ruleMatchMap is a mutex-protected map that stores rule matches in
+// the key-value form rule: matches.
+type ruleMatchMap struct {
+	mu      sync.Mutex
+	matches map[string][]ruleMatch
+}
+
+// newRuleMatchMap returns a pointer to a new ruleMatchMap.
+func newRuleMatchMap() *ruleMatchMap {
+	return &ruleMatchMap{
+		matches: make(map[string][]ruleMatch),
+	}
+}
+
+// store adds the matches for a single scan to the ruleMatchMap.
+func (m *ruleMatchMap) store(matches map[string]ruleMatch) {
+	m.mu.Lock()
+	for name, match := range matches {
+		m.matches[name] = append(m.matches[name], match)
+	}
+	m.mu.Unlock()
+}
+
+// scanAsset runs the user's rules, if they gave any, over an archived
+// file fetched alongside the snapshots: a well-known file, a robots.txt
+// or sitemap capture, or a JavaScript file.
+func (g *ghost) scanAsset(data []byte, source string) {
+	if rules, ok := g.query.(*ruleSet); ok {
+		g.scanRules(rules, data, source)
+	}
+}
+
+// scanRules runs the rule set against data, logging and storing any matches.
+func (g *ghost) scanRules(rs *ruleSet, data []byte, source string) {
+	matches := rs.scan(data, source)
+	if len(matches) == 0 {
+		g.infoLog.Printf("No rules matched %s.\n", source)
+		return
+	}
+	names := make([]string, 0, len(matches))
+	for name := range matches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	g.infoLog.Printf("%s matched: %s\n", source, strings.Join(names, ", "))
+	g.ruleMatches.store(matches)
+}
+
+// ruleMatchWriter marshals the rule matches and writes them to a JSON file.
+func (g *ghost) ruleMatchWriter(data map[string][]ruleMatch) {
+	for _, matches := range data {
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Source < matches[j].Source })
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		g.errorLog.Printf("Marshal error: %v\n", err)
+		return
+	}
+	g.writeData("ruleResults.json", b)
+	g.storeTable(ruleTable(data))
+}
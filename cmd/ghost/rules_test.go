@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanOne parses src, scans data with it, and returns the strings found
+// by the rule named "t", or nil if it didn't match.
+func scanOne(t *testing.T, src string, data []byte) map[string]int {
+	t.Helper()
+	rs, err := parseRules(src)
+	if err != nil {
+		t.Fatalf("parseRules(%q): %v", src, err)
+	}
+	m, ok := rs.scan(data, "test")["t"]
+	if !ok {
+		return nil
+	}
+	return m.Strings
+}
+
+func TestRuleStrings(t *testing.T) {
+	tests := []struct {
+		name    string
+		strings string
+		data    string
+		want    map[string]int
+	}{
+		{"text", `$a = "admin"`, "admin admin", map[string]int{"$a": 2}},
+		{"text overlapping", `$a = "aa"`, "aaaa", map[string]int{"$a": 3}},
+		{"text escapes", `$a = "a\"b\n"`, "a\"b\n", map[string]int{"$a": 1}},
+		{"text case", `$a = "Admin"`, "admin", nil},
+		{"nocase", `$a = "Admin" nocase`, "ADMIN admin aDmIn", map[string]int{"$a": 3}},
+		{"nocase ascii only", `$a = "straße" nocase`, "STRAßE", map[string]int{"$a": 1}},
+		{"nocase non-ascii", `$a = "é" nocase`, "É", nil},
+		{"nocase binary", `$a = "\xffAB" nocase`, "\x00\xffab\x80", map[string]int{"$a": 1}},
+		{"wide", `$a = "hi" wide`, "h\x00i\x00", map[string]int{"$a": 1}},
+		{"wide only", `$a = "hi" wide`, "hi", nil},
+		{"wide ascii", `$a = "hi" wide ascii`, "hi h\x00i\x00", map[string]int{"$a": 2}},
+		{"wide nocase", `$a = "hi" wide nocase`, "H\x00I\x00", map[string]int{"$a": 1}},
+		{"hex", `$a = { 3C 66 6F 72 6D }`, "<form>", map[string]int{"$a": 1}},
+		{"hex lowercase", `$a = { 3c 66 }`, "<f", map[string]int{"$a": 1}},
+		{"hex wildcard", `$a = { 41 ?? 43 }`, "ABC AXC AC", map[string]int{"$a": 2}},
+		{"hex nibble", `$a = { 4? 6? }`, "Ab", map[string]int{"$a": 1}},
+		{"hex nibble low", `$a = { ?1 }`, "AQ", map[string]int{"$a": 2}},
+		{"hex jump", `$a = { 41 [2] 44 }`, "ABCD ABD", map[string]int{"$a": 1}},
+		{"hex range", `$a = { 41 [0-2] 44 }`, "AD ABD ABCD ABCXD", map[string]int{"$a": 3}},
+		{"hex comment", `$a = { 41 /* } */ 42 // }
+		}`, "AB", map[string]int{"$a": 1}},
+		{"regex", `$a = /log(in|out)\.php/`, "login.php logout.php", map[string]int{"$a": 2}},
+		{"regex flags", `$a = /ADMIN/i`, "admin", map[string]int{"$a": 1}},
+		{"regex nocase", `$a = /ADMIN/ nocase`, "admin", map[string]int{"$a": 1}},
+		{"regex slash", `$a = /a\/b/`, "a/b", map[string]int{"$a": 1}},
+		{"regex brace", `$a = /x{2}}/`, "xx}", map[string]int{"$a": 1}},
+	}
+	for _, tt := range tests {
+		src := "rule t { strings: " + tt.strings + " condition: any of them }"
+		got := scanOne(t, src, []byte(tt.data))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRuleConditions(t *testing.T) {
+	const strs = `strings: $a1 = "a" $a2 = "b" $c = "c" `
+	tests := []struct {
+		condition string
+		data      string
+		want      bool
+	}{
+		{"$a1", "a", true},
+		{"$a1", "x", false},
+		{"$a1 and $a2", "ab", true},
+		{"$a1 and $a2", "a", false},
+		{"$a1 or $a2", "b", true},
+		{"$a1 or $a2", "c", false},
+		{"not $a1", "b", true},
+		{"not $a1", "a", false},
+		{"not not $a1", "a", true},
+		{"$a1 and not $a2", "a", true},
+		{"$a1 and ($a2 or $c)", "ac", true},
+		{"$a1 and ($a2 or $c)", "a", false},
+		{"$a1 or $a2 and $c", "a", true},
+		{"($a1 or $a2) and $c", "a", false},
+		{"#a1 > 2", "aaa", true},
+		{"#a1 > 2", "aa", false},
+		{"#a1 == 2 and #c == 0", "aa", true},
+		{"#a1 >= 2", "aa", true},
+		{"#a1 <= 1", "aa", false},
+		{"#a1 != 1", "aa", true},
+		{"#a1 < 1", "", true},
+		{"filesize < 3", "ab", true},
+		{"filesize < 3", "abc", false},
+		{"2 of them", "ab", true},
+		{"2 of them", "a", false},
+		{"3 of them", "abc", true},
+		{"any of them", "c", true},
+		{"any of them", "x", false},
+		{"all of them", "abc", true},
+		{"all of them", "ab", false},
+		{"none of them", "x", true},
+		{"none of them", "a", false},
+		{"any of ($a*)", "b", true},
+		{"all of ($a*)", "ab", true},
+		{"all of ($a*)", "ac", false},
+		{"1 of ($a1, $c)", "c", true},
+		{"all of ($a1, $c)", "c", false},
+		{"true", "", true},
+		{"false", "abc", false},
+		{"$a1 // }\n or /* } */ $c", "c", true},
+	}
+	for _, tt := range tests {
+		src := "rule t { " + strs + "condition: " + tt.condition + " }"
+		got := scanOne(t, src, []byte(tt.data)) != nil
+		if got != tt.want {
+			t.Errorf("%q on %q: matched = %v, want %v", tt.condition, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestHexJumps(t *testing.T) {
+	// every way of spreading the jumps over the data fails at the last
+	// byte, which takes exponential time unless failures are remembered
+	src := "rule t { strings: $a = { 41" + strings.Repeat(" [0-50] 41", 12) + " 42 } condition: $a }"
+	done := make(chan map[string]int, 1)
+	go func() { done <- scanOne(t, src, bytes.Repeat([]byte("A"), 10000)) }()
+	select {
+	case got := <-done:
+		if got != nil {
+			t.Errorf("got %v, want no match", got)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("hex string with many jumps is still scanning")
+	}
+
+	got := scanOne(t, src, append(bytes.Repeat([]byte("A"), 13), 'B'))
+	if want := map[string]int{"$a": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rs, err := parseRules(`
+// a comment before the rules
+rule first : tag1 tag2 {
+	meta:
+		author = "someone {with braces}"
+		level = 3
+	strings:
+		$a = "x}y"
+	condition:
+		$a
+}
+
+/* another comment { */
+rule second { condition: true }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs.rules) != 2 || rs.rules[0].name != "first" || rs.rules[1].name != "second" {
+		t.Fatalf("got rules %v", rs.rules)
+	}
+	if want := map[string]string{"author": "someone {with braces}", "level": "3"}; !reflect.DeepEqual(rs.rules[0].meta, want) {
+		t.Errorf("meta = %v, want %v", rs.rules[0].meta, want)
+	}
+	matches := rs.scan([]byte("x}y"), "test")
+	if len(matches) != 2 {
+		t.Errorf("scan matched %v, want both rules", matches)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"", "no rules found"},
+		{"// nothing but a comment", "no rules found"},
+		{"rules t { condition: true }", "expected rule"},
+		{"rule { condition: true }", "missing rule name"},
+		{"rule t condition: true }", `expected "{"`},
+		{"rule tä { condition: true }", `expected "{"`},
+		{"rule t { condition: true", "missing closing brace"},
+		{"rule t { condition: true } rule t { condition: true }", "duplicate rule"},
+		{"rule t { other: true }", "unknown section"},
+		{"rule t { condition: }", "empty condition"},
+		{"rule t { condition: $a }", "undefined string $a"},
+		{"rule t { condition: #a > 1 }", "undefined string $a"},
+		{`rule t { strings: $a = "x" condition: $a and }`, "unexpected end of condition"},
+		{`rule t { strings: $a = "x" condition: ($a }`, "missing closing parenthesis"},
+		{`rule t { strings: $a = "x" condition: $a $a }`, "unexpected"},
+		{`rule t { strings: $a = "x" condition: "}" }`, "unexpected"},
+		{`rule t { strings: $a = "x" condition: 2 of them }`, "can never match"},
+		{`rule t { strings: $a = "x" condition: any of ($b*) }`, "matches no strings"},
+		{`rule t { strings: $a = "x" condition: any of ($a }`, "expected , or )"},
+		{`rule t { strings: $a = "x" condition: any of $a }`, "expected them"},
+		{`rule t { strings: $a = "x" $a = "y" condition: $a }`, "duplicate string"},
+		{`rule t { strings: $a = "" condition: $a }`, "empty string"},
+		{`rule t { strings: $a = "x condition: $a }`, "unterminated string"},
+		{`rule t { strings: $a = x condition: $a }`, "expected text, hex, or regex"},
+		{`rule t { strings: $a = { 4 } condition: $a }`, "odd number of hex digits"},
+		{`rule t { strings: $a = { 4G } condition: $a }`, "bad hex digit"},
+		{`rule t { strings: $a = { [2] 41 } condition: $a }`, "must begin and end with a byte"},
+		{`rule t { strings: $a = { 41 [3-1] 42 } condition: $a }`, "bad jump"},
+		{`rule t { strings: $a = { 41 [x] 42 } condition: $a }`, "bad jump"},
+		{`rule t { strings: $a = { 41 [2 42 } condition: $a }`, "unterminated jump"},
+		{`rule t { strings: $a = { 41 42 condition: $a`, "unterminated hex string"},
+		{`rule t { strings: $a = { 41 } nocase condition: $a }`, "hex strings take no modifiers"},
+		{`rule t { strings: $a = /abc condition: $a }`, "unterminated regex"},
+		{`rule t { strings: $a = /(/ condition: $a }`, "missing closing )"},
+		{`rule t { strings: $a = /a/ wide condition: $a }`, "not supported for regex"},
+	}
+	for _, tt := range tests {
+		_, err := parseRules(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseRules(%q) error = %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
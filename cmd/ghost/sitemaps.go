@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// sitemapPattern matches the original URL of sitemaps, including
+	// gzipped and text sitemaps.
+	sitemapPattern = `.*sitemap[^/]*\.(xml|txt)(\.gz)?(\?.*)?$`
+	// maxSitemapDepth limits how far sitemap indexes are followed.
+	maxSitemapDepth = 3
+	// maxSitemaps caps the number of sitemap captures fetched.
+	maxSitemaps = 500
+	// maxSitemapSize is the largest sitemap allowed once gunzipped, as
+	// set by sitemaps.org.
+	maxSitemapSize = 50 << 20
+)
+
+// sitemapEntry is a <url> or <sitemap> element.
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// sitemapXML covers both <urlset> and <sitemapindex> documents.
+type sitemapXML struct {
+	XMLName  xml.Name
+	URLs     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+// parseSitemap parses an XML or text sitemap, gunzipping it first if
+// needed (and refusing it if that's over maxSitemapSize). It returns the kind of sitemap ("urlset", "index", or "text"),
+// the listed URLs, and any child sitemaps.
+func parseSitemap(data []byte) (string, []sitemapEntry, []sitemapEntry, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, maxSitemapSize+1))
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("gunzip: %w", err)
+		}
+		if len(data) > maxSitemapSize {
+			return "", nil, nil, fmt.Errorf("gunzipped sitemap is over %d MB", maxSitemapSize>>20)
+		}
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		var urls []sitemapEntry
+		s := bufio.NewScanner(bytes.NewReader(trimmed))
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if u, err := url.Parse(line); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+				urls = append(urls, sitemapEntry{Loc: line})
+			}
+		}
+		return "text", urls, nil, s.Err()
+	}
+
+	var sm sitemapXML
+	err := xml.Unmarshal(trimmed, &sm)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	for _, list := range [][]sitemapEntry{sm.URLs, sm.Sitemaps} {
+		for i := range list {
+			list[i].Loc = strings.TrimSpace(list[i].Loc)
+			list[i].LastMod = strings.TrimSpace(list[i].LastMod)
+		}
+	}
+	switch sm.XMLName.Local {
+	case "sitemapindex":
+		return "index", nil, sm.Sitemaps, nil
+	case "urlset":
+		return "urlset", sm.URLs, nil, nil
+	}
+	return "", nil, nil, fmt.Errorf("unexpected root element <%s>", sm.XMLName.Local)
+}
+
+// sitemapVersion is a single archived sitemap that was fetched and parsed.
+type sitemapVersion struct {
+	Sitemap   string `json:"sitemap"`
+	Timestamp string `json:"timestamp"`
+	Kind      string `json:"kind"`
+	URLs      int    `json:"urls"`
+	Children  int    `json:"children,omitempty"`
+	Parent    string `json:"parent,omitempty"`
+}
+
+// sitemapListing is a sitemap version that listed a URL.
+type sitemapListing struct {
+	Sitemap   string `json:"sitemap"`
+	Timestamp string `json:"timestamp"`
+	LastMod   string `json:"lastmod,omitempty"`
+}
+
+// sitemapURL is a URL discovered in one or more sitemaps.
+type sitemapURL struct {
+	URL      string           `json:"url"`
+	LastMods []string         `json:"lastmods,omitempty"`
+	Listed   []sitemapListing `json:"listed_in"`
+}
+
+// sitemapJob is a sitemap capture waiting to be fetched.
+type sitemapJob struct {
+	file   archivedFile
+	parent string
+}
+
+// sitemapHistory fetches every distinct archived sitemap for domain,
+// follows sitemap indexes into their children (fetched from the archive
+// at the index's timestamp), and merges every version into a single set
+// of URLs. The URLs are written to sitemapURLs.txt and, with their
+// lastmod dates and the sitemap versions listing them, to
+// sitemapURLs.json. The sitemaps themselves are listed in sitemaps.json.
+func (g *ghost) sitemapHistory(wg *sync.WaitGroup, domain string, timeout int) {
+	defer wg.Done()
+
+	files, err := g.listArchived(domain, sitemapPattern, maxSitemaps, timeout)
+	if err != nil {
+		g.errorLog.Printf("unable to list sitemaps: %v\n", err)
+		return
+	}
+	if len(files) == 0 {
+		g.infoLog.Println("No archived sitemaps found.")
+		return
+	}
+	g.infoLog.Printf("Found %d archived sitemap(s).\n", len(files))
+
+	var (
+		mu       sync.Mutex
+		versions []sitemapVersion
+		urls     = make(map[string]*sitemapURL)
+		seen     = make(map[[sha256.Size]byte]bool)
+		fetched  int
+	)
+
+	var level []sitemapJob
+	for _, f := range files {
+		level = append(level, sitemapJob{file: f})
+	}
+
+	for depth := 0; depth <= maxSitemapDepth && len(level) > 0; depth++ {
+		var next []sitemapJob
+		var swg sync.WaitGroup
+		tokens := make(chan struct{}, g.config.gophers)
+		for _, job := range level {
+			if fetched >= maxSitemaps {
+				g.infoLog.Printf("Reached the limit of %d sitemaps.\n", maxSitemaps)
+				break
+			}
+			fetched++
+
+			tokens <- struct{}{}
+			swg.Add(1)
+			job := job
+			g.goSafe(func() {
+				defer swg.Done()
+				body, err := g.getData(job.file.rawURL(), timeout)
+				<-tokens
+				if err != nil {
+					g.errorLog.Printf("getData error for %s: %v\n", job.file.rawURL(), err)
+					return
+				}
+				g.scanAsset(body, job.file.rawURL())
+
+				kind, entries, children, err := parseSitemap(body)
+				if err != nil {
+					g.errorLog.Printf("unable to parse sitemap %s: %v\n", job.file.Original, err)
+					return
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				// the same content may be reached more than once
+				sum := sha256.Sum256(body)
+				if seen[sum] {
+					return
+				}
+				seen[sum] = true
+
+				versions = append(versions, sitemapVersion{
+					Sitemap:   job.file.Original,
+					Timestamp: job.file.Timestamp,
+					Kind:      kind,
+					URLs:      len(entries),
+					Children:  len(children),
+					Parent:    job.parent,
+				})
+				for _, e := range entries {
+					su, ok := urls[e.Loc]
+					if !ok {
+						su = &sitemapURL{URL: e.Loc}
+						urls[e.Loc] = su
+					}
+					su.Listed = append(su.Listed, sitemapListing{
+						Sitemap:   job.file.Original,
+						Timestamp: job.file.Timestamp,
+						LastMod:   e.LastMod,
+					})
+				}
+				for _, c := range children {
+					if c.Loc == "" {
+						continue
+					}
+					next = append(next, sitemapJob{
+						file:   archivedFile{Timestamp: job.file.Timestamp, Original: c.Loc},
+						parent: job.file.Original,
+					})
+				}
+			})
+		}
+		swg.Wait()
+		level = next
+	}
+
+	out := make([]*sitemapURL, 0, len(urls))
+	for _, su := range urls {
+		lastmods := make(map[string]bool)
+		for _, l := range su.Listed {
+			if l.LastMod != "" {
+				lastmods[l.LastMod] = true
+			}
+		}
+		su.LastMods = sortedKeys(lastmods)
+		sort.Slice(su.Listed, func(i, j int) bool { return su.Listed[i].Timestamp < su.Listed[j].Timestamp })
+		out = append(out, su)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Sitemap != versions[j].Sitemap {
+			return versions[i].Sitemap < versions[j].Sitemap
+		}
+		return versions[i].Timestamp < versions[j].Timestamp
+	})
+
+	g.infoLog.Printf("Found %d URL(s) in %d sitemap version(s).\n", len(out), len(versions))
+
+	lines := make([]string, len(out))
+	for i, su := range out {
+		lines[i] = su.URL
+	}
+	g.writeLines("sitemapURLs.txt", lines)
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		g.errorLog.Printf("sitemapHistory marshal error: %v\n", err)
+		return
+	}
+	g.writeData("sitemapURLs.json", b)
+
+	b, err = json.Marshal(versions)
+	if err != nil {
+		g.errorLog.Printf("sitemapHistory marshal error: %v\n", err)
+		return
+	}
+	g.writeData("sitemaps.json", b)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+// gzipped returns data compressed with gzip.
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseSitemap(t *testing.T) {
+	urlset := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> https://example.com/a </loc><lastmod>2020-01-02</lastmod></url>
+  <url><loc>https://example.com/b</loc></url>
+</urlset>`)
+	for _, data := range [][]byte{urlset, gzipped(t, urlset)} {
+		kind, urls, children, err := parseSitemap(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != "urlset" || len(urls) != 2 || len(children) != 0 || urls[0] != (sitemapEntry{"https://example.com/a", "2020-01-02"}) {
+			t.Errorf("parseSitemap = %s, %v, %v", kind, urls, children)
+		}
+	}
+
+	kind, urls, _, err := parseSitemap([]byte("https://example.com/a\nnot a url\nhttps://example.com/b\n"))
+	if err != nil || kind != "text" || len(urls) != 2 {
+		t.Errorf("text sitemap = %s, %v, %v", kind, urls, err)
+	}
+
+	// a gzip bomb is refused rather than decompressed in full
+	bomb := gzipped(t, make([]byte, maxSitemapSize+1))
+	if _, _, _, err := parseSitemap(bomb); err == nil || !strings.Contains(err.Error(), "over 50 MB") {
+		t.Errorf("oversized sitemap: err = %v", err)
+	}
+	if _, _, _, err := parseSitemap(gzipped(t, []byte("https://example.com/\n"+strings.Repeat(" ", maxSitemapSize-30)))); err != nil {
+		t.Errorf("sitemap under the limit: %v", err)
+	}
+}
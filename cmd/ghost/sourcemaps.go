@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// mapPattern matches the original URL of source map files.
+const mapPattern = `.*\.js\.map(\?.*)?$`
+
+// sourceMappingURL matches the comment pointing a script at its source map.
+var sourceMappingURL = regexp.MustCompile(`(?m)^\s*//[#@]\s*sourceMappingURL=(\S+)\s*$`)
+
+// mapRef is a source map to rebuild: either an archived capture to
+// fetch, or a map inlined into a script as a data URI.
+type mapRef struct {
+	archivedFile
+	inline []byte
+}
+
+// sourceMapRef looks for a sourceMappingURL comment in src, a capture of
+// the JavaScript file f. Relative references are resolved against the
+// script's original URL and paired with the script's timestamp, leaving
+// the Wayback Machine to redirect to the closest capture of the map.
+func sourceMapRef(src []byte, f archivedFile) (mapRef, bool) {
+	matches := sourceMappingURL.FindAllSubmatch(src, -1)
+	if len(matches) == 0 {
+		return mapRef{}, false
+	}
+	// the last comment wins, as in browsers
+	ref := string(matches[len(matches)-1][1])
+
+	if strings.HasPrefix(ref, "data:") {
+		data, err := decodeDataURI(ref)
+		if err != nil {
+			return mapRef{}, false
+		}
+		// inline maps are labeled with the script they came from
+		return mapRef{archivedFile: f, inline: data}, true
+	}
+
+	base, err := url.Parse(f.Original)
+	if err != nil {
+		return mapRef{}, false
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return mapRef{}, false
+	}
+	return mapRef{archivedFile: archivedFile{Timestamp: f.Timestamp, Original: u.String()}}, true
+}
+
+// decodeDataURI returns the payload of a base64 or percent-encoded data URI.
+func decodeDataURI(uri string) ([]byte, error) {
+	meta, payload, found := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !found {
+		return nil, fmt.Errorf("malformed data URI")
+	}
+	if strings.HasSuffix(meta, ";base64") {
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	s, err := url.PathUnescape(payload)
+	return []byte(s), err
+}
+
+// sourceMap holds the fields of a source map needed to rebuild sources.
+type sourceMap struct {
+	Version        int       `json:"version"`
+	SourceRoot     string    `json:"sourceRoot"`
+	Sources        []string  `json:"sources"`
+	SourcesContent []*string `json:"sourcesContent"`
+}
+
+// sourceMaps finds the archived source maps for domain, adds those
+// referenced by sourceMappingURL comments (refs), and rebuilds the
+// original source tree of each under sourcemaps/<timestamp>/ in the run
+// directory.
+// If the user submitted a query, each rebuilt file is searched with it.
+func (g *ghost) sourceMaps(domain string, refs []mapRef, timeout int) {
+	files, err := g.listArchived(domain, mapPattern, g.config.jsLimit, timeout)
+	if err != nil {
+		g.errorLog.Printf("unable to list source maps: %v\n", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, r := range refs {
+		seen[r.Timestamp+r.Original] = true
+	}
+	for _, f := range files {
+		if !seen[f.Timestamp+f.Original] {
+			refs = append(refs, mapRef{archivedFile: f})
+		}
+	}
+	if len(refs) == 0 {
+		g.infoLog.Println("No source maps found.")
+		return
+	}
+	g.infoLog.Printf("Found %d source map(s).\n", len(refs))
+
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, g.config.gophers)
+	for _, r := range refs {
+		tokens <- struct{}{}
+		wg.Add(1)
+		r := r
+		g.goSafe(func() {
+			defer wg.Done()
+			data := r.inline
+			if data == nil {
+				var err error
+				data, err = g.getData(r.rawURL(), timeout)
+				if err != nil {
+					<-tokens
+					g.errorLog.Printf("getData error for %s: %v\n", r.rawURL(), err)
+					return
+				}
+			}
+			<-tokens
+			g.rebuildSources(r.archivedFile, data)
+		})
+	}
+	wg.Wait()
+}
+
+// rebuildSources writes the sourcesContent of a source map to disk,
+// under a directory named for the capture's timestamp and map file.
+func (g *ghost) rebuildSources(f archivedFile, data []byte) {
+	var sm sourceMap
+	err := json.Unmarshal(data, &sm)
+	if err != nil {
+		g.errorLog.Printf("%s is not a source map: %v\n", f.Original, err)
+		return
+	}
+	if len(sm.SourcesContent) == 0 {
+		g.infoLog.Printf("%s has no sourcesContent.\n", f.Original)
+		return
+	}
+
+	mapName := path.Base(strings.SplitN(f.Original, "?", 2)[0])
+	dir := path.Join("sourcemaps", f.Timestamp, sanitizeSourcePath(mapName))
+
+	var n int
+	names := sm.fileNames()
+	for i, content := range sm.SourcesContent {
+		if content == nil || i >= len(sm.Sources) {
+			continue
+		}
+		g.writeData(path.Join(dir, names[i]), []byte(*content))
+		n++
+
+		if g.query != nil {
+			g.parsePage(*content, f.rawURL()+"#"+sm.Sources[i], g.query)
+		}
+	}
+	g.infoLog.Printf("Rebuilt %d source file(s) from %s.\n", n, f.Original)
+}
+
+// fileNames returns the relative file name to write each source to:
+// the source joined to the sourceRoot, made safe with sanitizeSourcePath.
+// Sources that end up with the same name, like ./a.js and a.js?v=2, get
+// their index added to keep them apart.
+func (sm sourceMap) fileNames() []string {
+	names := make([]string, len(sm.Sources))
+	used := make(map[string]bool)
+	for i, src := range sm.Sources {
+		name := sanitizeSourcePath(joinSourceRoot(sm.SourceRoot, src))
+		if used[name] {
+			ext := path.Ext(name)
+			base := strings.TrimSuffix(name, ext)
+			for j := i; used[name]; j++ {
+				name = fmt.Sprintf("%s-%d%s", base, j, ext)
+			}
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// joinSourceRoot prepends a source map's sourceRoot to a source, adding
+// a slash between them if the root doesn't end in one. Sources that are
+// already absolute URLs are left alone.
+func joinSourceRoot(root, src string) string {
+	if root == "" || strings.Contains(src, "://") {
+		return src
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return root + src
+}
+
+// sanitizeSourcePath turns a source path like webpack:///./src/app.js
+// into a relative path that cannot escape the output directory.
+func sanitizeSourcePath(p string) string {
+	if i := strings.Index(p, "://"); i >= 0 {
+		p = p[i+3:]
+	}
+	p = strings.SplitN(p, "?", 2)[0]
+	p = strings.ReplaceAll(p, ":", "_")
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		p = "_"
+	}
+	return p
+}
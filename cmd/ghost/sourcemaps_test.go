@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSourceMapFileNames(t *testing.T) {
+	tests := []struct {
+		root    string
+		sources []string
+		want    []string
+	}{
+		{"", []string{"src/app.js"}, []string{"src/app.js"}},
+		{"src", []string{"app.js"}, []string{"src/app.js"}},
+		{"src/", []string{"app.js"}, []string{"src/app.js"}},
+		{"webpack:///", []string{"./src/app.js"}, []string{"src/app.js"}},
+		{"/lib", []string{"https://cdn.example.com/x.js"}, []string{"cdn.example.com/x.js"}},
+		{"", []string{"../../../etc/passwd"}, []string{"etc/passwd"}},
+		{
+			"",
+			[]string{"./a.js", "a.js", "a.js?v=2", "b", "b"},
+			[]string{"a.js", "a-1.js", "a-2.js", "b", "b-4"},
+		},
+		{
+			"",
+			[]string{"a.js", "a-1.js", "a.js"},
+			[]string{"a.js", "a-1.js", "a-2.js"},
+		},
+	}
+	for _, tt := range tests {
+		got := sourceMap{SourceRoot: tt.root, Sources: tt.sources}.fileNames()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("root %q, sources %q: got %q, want %q", tt.root, tt.sources, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// sqlitePageSize is the page size of the databases ghost writes.
+const sqlitePageSize = 4096
+
+// sqliteFile builds a SQLite 3 database file in memory. It only writes:
+// each table is laid out once, as a table b-tree packed left to right,
+// with no indexes and no free pages.
+type sqliteFile struct {
+	pages [][]byte // pages[0] is page 1
+}
+
+// alloc adds a page and returns its number.
+func (f *sqliteFile) alloc() int {
+	f.pages = append(f.pages, make([]byte, sqlitePageSize))
+	return len(f.pages)
+}
+
+// page returns the page numbered n.
+func (f *sqliteFile) page(n int) []byte {
+	return f.pages[n-1]
+}
+
+// buildSQLite writes tables into a new database, one SQLite table each,
+// with rowids numbered from 1 in row order.
+func buildSQLite(tables []*table) ([]byte, error) {
+	f := &sqliteFile{}
+	f.alloc() // page 1 holds the file header and the root of the schema
+
+	var schema [][]byte
+	for i, t := range tables {
+		records := make([][]byte, len(t.rows))
+		for j, row := range t.rows {
+			records[j] = sqliteRecord(row)
+		}
+		root := f.buildTable(records)
+
+		cols := make([]string, len(t.columns))
+		for j, c := range t.columns {
+			cols[j] = fmt.Sprintf("%s %s", sqliteQuote(c.name), c.typ)
+		}
+		sql := fmt.Sprintf("CREATE TABLE %s (%s)", sqliteQuote(t.name), strings.Join(cols, ", "))
+		rec := sqliteRecord([]interface{}{"table", t.name, t.name, int64(root), sql})
+		schema = append(schema, f.leafCell(int64(i+1), rec))
+	}
+
+	// the schema's root has to be page 1, after the file header: a leaf
+	// if the schema fits, or else an interior page over leaves of it
+	need := 100 + 8
+	for _, c := range schema {
+		need += len(c) + 2
+	}
+	if need <= sqlitePageSize {
+		writeLeafPage(f.page(1), 100, schema)
+	} else {
+		leaves := f.packLeaves(schema)
+		cells := make([][]byte, 0, len(leaves)-1)
+		need := 100 + 12
+		for _, c := range leaves[:len(leaves)-1] {
+			cell := interiorCell(c)
+			cells = append(cells, cell)
+			need += len(cell) + 2
+		}
+		if need > sqlitePageSize {
+			return nil, errors.New("too many tables for the schema")
+		}
+		writeInteriorPage(f.page(1), 100, cells, leaves[len(leaves)-1].page)
+	}
+
+	h := f.page(1)
+	copy(h, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(h[16:], sqlitePageSize)
+	h[18], h[19] = 1, 1 // legacy journal mode
+	h[21], h[22], h[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(h[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(h[28:], uint32(len(f.pages)))
+	binary.BigEndian.PutUint32(h[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(h[44:], 4) // schema format
+	binary.BigEndian.PutUint32(h[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(h[92:], 1) // version-valid-for
+	binary.BigEndian.PutUint32(h[96:], 3045000)
+
+	out := make([]byte, 0, len(f.pages)*sqlitePageSize)
+	for _, p := range f.pages {
+		out = append(out, p...)
+	}
+	return out, nil
+}
+
+// sqliteChild is a page of a table b-tree, as seen from its parent.
+type sqliteChild struct {
+	page  int
+	rowid int64 // the largest rowid under the page
+}
+
+// interiorCell returns the table interior cell pointing at c.
+func interiorCell(c sqliteChild) []byte {
+	cell := make([]byte, 4, 4+9)
+	binary.BigEndian.PutUint32(cell, uint32(c.page))
+	return append(cell, sqliteVarint(uint64(c.rowid))...)
+}
+
+// buildTable lays out a table b-tree holding records as rows 1 to n and
+// returns its root page.
+func (f *sqliteFile) buildTable(records [][]byte) int {
+	cells := make([][]byte, len(records))
+	for i, rec := range records {
+		cells[i] = f.leafCell(int64(i+1), rec)
+	}
+	level := f.packLeaves(cells)
+
+	// add interior levels until there's a single root
+	for len(level) > 1 {
+		var next []sqliteChild
+		for i := 0; i < len(level); {
+			var cells [][]byte
+			used := 12
+			j := i
+			// the last child of each page goes in its right-most pointer
+			for j+1 < len(level) {
+				cell := interiorCell(level[j])
+				if used+len(cell)+2 > sqlitePageSize {
+					break
+				}
+				cells = append(cells, cell)
+				used += len(cell) + 2
+				j++
+			}
+			n := f.alloc()
+			writeInteriorPage(f.page(n), 0, cells, level[j].page)
+			next = append(next, sqliteChild{n, level[j].rowid})
+			i = j + 1
+		}
+		level = next
+	}
+	return level[0].page
+}
+
+// packLeaves packs leaf cells for rows 1 to n into as few leaf pages as
+// they fit in, and returns the pages. There's always at least one.
+func (f *sqliteFile) packLeaves(cells [][]byte) []sqliteChild {
+	var level []sqliteChild
+	var page [][]byte
+	used := 8
+	flush := func(rowid int64) {
+		n := f.alloc()
+		writeLeafPage(f.page(n), 0, page)
+		level = append(level, sqliteChild{n, rowid})
+		page, used = nil, 8
+	}
+	for i, cell := range cells {
+		if used+len(cell)+2 > sqlitePageSize {
+			flush(int64(i))
+		}
+		page = append(page, cell)
+		used += len(cell) + 2
+	}
+	if len(page) > 0 || len(level) == 0 {
+		flush(int64(len(cells)))
+	}
+	return level
+}
+
+// leafCell returns a table leaf cell for a row, spilling any payload too
+// big for the page into overflow pages.
+func (f *sqliteFile) leafCell(rowid int64, payload []byte) []byte {
+	cell := sqliteVarint(uint64(len(payload)))
+	cell = append(cell, sqliteVarint(uint64(rowid))...)
+
+	const usable = sqlitePageSize
+	maxLocal := usable - 35
+	if len(payload) <= maxLocal {
+		return append(cell, payload...)
+	}
+	minLocal := (usable-12)*32/255 - 23
+	local := minLocal + (len(payload)-minLocal)%(usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	cell = append(cell, payload[:local]...)
+
+	rest := payload[local:]
+	first := 0
+	prev := 0
+	for len(rest) > 0 {
+		n := f.alloc()
+		if prev == 0 {
+			first = n
+		} else {
+			binary.BigEndian.PutUint32(f.page(prev), uint32(n))
+		}
+		chunk := rest
+		if len(chunk) > usable-4 {
+			chunk = chunk[:usable-4]
+		}
+		copy(f.page(n)[4:], chunk)
+		rest = rest[len(chunk):]
+		prev = n
+	}
+	var next [4]byte
+	binary.BigEndian.PutUint32(next[:], uint32(first))
+	return append(cell, next[:]...)
+}
+
+// writeLeafPage writes a table leaf page, with its header at offset hdr
+// (100 on page 1) and its cells packed at the end of the page.
+func writeLeafPage(p []byte, hdr int, cells [][]byte) {
+	p[hdr] = 0x0d
+	binary.BigEndian.PutUint16(p[hdr+3:], uint16(len(cells)))
+	off := sqlitePageSize
+	for i, c := range cells {
+		off -= len(c)
+		copy(p[off:], c)
+		binary.BigEndian.PutUint16(p[hdr+8+2*i:], uint16(off))
+	}
+	binary.BigEndian.PutUint16(p[hdr+5:], uint16(off))
+}
+
+// writeInteriorPage writes a table interior page, with its header at
+// offset hdr (100 on page 1).
+func writeInteriorPage(p []byte, hdr int, cells [][]byte, right int) {
+	p[hdr] = 0x05
+	binary.BigEndian.PutUint16(p[hdr+3:], uint16(len(cells)))
+	binary.BigEndian.PutUint32(p[hdr+8:], uint32(right))
+	off := sqlitePageSize
+	for i, c := range cells {
+		off -= len(c)
+		copy(p[off:], c)
+		binary.BigEndian.PutUint16(p[hdr+12+2*i:], uint16(off))
+	}
+	binary.BigEndian.PutUint16(p[hdr+5:], uint16(off))
+}
+
+// sqliteRecord encodes a row in the SQLite record format. Values may be
+// nil, int64, float64, string, or []byte.
+func sqliteRecord(values []interface{}) []byte {
+	var types, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = append(types, 0)
+		case int64:
+			switch {
+			case v == 0:
+				types = append(types, 8)
+			case v == 1:
+				types = append(types, 9)
+			default:
+				typ, size := sqliteIntType(v)
+				types = append(types, typ)
+				var b [8]byte
+				binary.BigEndian.PutUint64(b[:], uint64(v))
+				body = append(body, b[8-size:]...)
+			}
+		case float64:
+			types = append(types, 7)
+			var b [8]byte
+			binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+			body = append(body, b[:]...)
+		case string:
+			types = append(types, sqliteVarint(uint64(13+2*len(v)))...)
+			body = append(body, v...)
+		case []byte:
+			types = append(types, sqliteVarint(uint64(12+2*len(v)))...)
+			body = append(body, v...)
+		}
+	}
+
+	// the header size counts itself
+	size := len(types) + 1
+	for len(sqliteVarint(uint64(size))) != size-len(types) {
+		size++
+	}
+	rec := sqliteVarint(uint64(size))
+	rec = append(rec, types...)
+	return append(rec, body...)
+}
+
+// sqliteIntType returns the serial type and size of the smallest integer
+// encoding that holds v.
+func sqliteIntType(v int64) (byte, int) {
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return 1, 1
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return 2, 2
+	case v >= -1<<23 && v < 1<<23:
+		return 3, 3
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return 4, 4
+	case v >= -1<<47 && v < 1<<47:
+		return 5, 6
+	}
+	return 6, 8
+}
+
+// sqliteVarint encodes v as a SQLite variable-length integer: big-endian
+// groups of seven bits, with the ninth byte (if any) holding eight.
+func sqliteVarint(v uint64) []byte {
+	if v <= 0x7f {
+		return []byte{byte(v)}
+	}
+	if v > 1<<56-1 {
+		b := make([]byte, 9)
+		b[8] = byte(v)
+		v >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+		return b
+	}
+	var b []byte
+	for v > 0 {
+		b = append([]byte{byte(v&0x7f) | 0x80}, b...)
+		v >>= 7
+	}
+	b[len(b)-1] &^= 0x80
+	return b
+}
+
+// sqliteQuote quotes an identifier.
+func sqliteQuote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readVarint decodes a SQLite varint from b, returning it and its length.
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v<<8 | uint64(b[8]), 9
+}
+
+// decodeRecord decodes a record into nil, int64, float64, string, and
+// []byte values.
+func decodeRecord(t *testing.T, rec []byte) []interface{} {
+	t.Helper()
+	size, n := readVarint(rec)
+	var types []uint64
+	for pos := n; pos < int(size); {
+		typ, n := readVarint(rec[pos:])
+		types = append(types, typ)
+		pos += n
+	}
+	body := rec[size:]
+	var values []interface{}
+	for _, typ := range types {
+		switch {
+		case typ == 0:
+			values = append(values, nil)
+		case typ >= 1 && typ <= 6:
+			size := []int{0, 1, 2, 3, 4, 6, 8}[typ]
+			var v int64
+			for i, c := range body[:size] {
+				if i == 0 {
+					v = int64(int8(c))
+				} else {
+					v = v<<8 | int64(c)
+				}
+			}
+			values = append(values, v)
+			body = body[size:]
+		case typ == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(body)))
+			body = body[8:]
+		case typ == 8, typ == 9:
+			values = append(values, int64(typ-8))
+		case typ >= 12 && typ%2 == 0:
+			n := int(typ-12) / 2
+			values = append(values, append([]byte{}, body[:n]...))
+			body = body[n:]
+		case typ >= 13:
+			n := int(typ-13) / 2
+			values = append(values, string(body[:n]))
+			body = body[n:]
+		default:
+			t.Fatalf("bad serial type %d", typ)
+		}
+	}
+	if len(body) != 0 {
+		t.Fatalf("record has %d bytes left over", len(body))
+	}
+	return values
+}
+
+// readTable walks the table b-tree rooted at page root and returns its
+// rows' records in rowid order, checking the rowids run from 1.
+func readTable(t *testing.T, db []byte, root int) [][]byte {
+	t.Helper()
+	var rows [][]byte
+	page := func(n int) []byte { return db[(n-1)*sqlitePageSize : n*sqlitePageSize] }
+	var walk func(n int)
+	walk = func(pn int) {
+		p := page(pn)
+		hdr := 0
+		if pn == 1 {
+			hdr = 100
+		}
+		cells := int(binary.BigEndian.Uint16(p[hdr+3:]))
+		switch p[hdr] {
+		case 0x05:
+			for i := 0; i < cells; i++ {
+				off := binary.BigEndian.Uint16(p[hdr+12+2*i:])
+				walk(int(binary.BigEndian.Uint32(p[off:])))
+			}
+			walk(int(binary.BigEndian.Uint32(p[hdr+8:])))
+		case 0x0d:
+			for i := 0; i < cells; i++ {
+				off := int(binary.BigEndian.Uint16(p[hdr+8+2*i:]))
+				size, n := readVarint(p[off:])
+				off += n
+				rowid, n := readVarint(p[off:])
+				off += n
+				if rowid != uint64(len(rows)+1) {
+					t.Fatalf("page %d: rowid %d after %d rows", pn, rowid, len(rows))
+				}
+
+				const usable = sqlitePageSize
+				local := int(size)
+				if local > usable-35 {
+					min := (usable-12)*32/255 - 23
+					local = min + (int(size)-min)%(usable-4)
+					if local > usable-35 {
+						local = min
+					}
+				}
+				rec := append([]byte{}, p[off:off+local]...)
+				if local < int(size) {
+					next := int(binary.BigEndian.Uint32(p[off+local:]))
+					for next != 0 {
+						o := page(next)
+						chunk := int(size) - len(rec)
+						if chunk > usable-4 {
+							chunk = usable - 4
+						}
+						rec = append(rec, o[4:4+chunk]...)
+						next = int(binary.BigEndian.Uint32(o))
+					}
+				}
+				if len(rec) != int(size) {
+					t.Fatalf("row %d has %d bytes, want %d", rowid, len(rec), size)
+				}
+				rows = append(rows, rec)
+			}
+		default:
+			t.Fatalf("page %d has type %#x", pn, p[hdr])
+		}
+	}
+	walk(root)
+	return rows
+}
+
+// checkSQLite checks the file header of db and that the schema and every
+// table read back as tables.
+func checkSQLite(t *testing.T, db []byte, tables []*table) {
+	t.Helper()
+	if len(db)%sqlitePageSize != 0 {
+		t.Fatalf("file is %d bytes, not a whole number of pages", len(db))
+	}
+	h := db[:100]
+	if !bytes.Equal(h[:16], []byte("SQLite format 3\x00")) {
+		t.Errorf("header string = %q", h[:16])
+	}
+	if size := binary.BigEndian.Uint16(h[16:]); size != sqlitePageSize {
+		t.Errorf("page size = %d", size)
+	}
+	if !bytes.Equal(h[18:24], []byte{1, 1, 0, 64, 32, 32}) {
+		t.Errorf("header bytes 18-23 = %v", h[18:24])
+	}
+	if n := binary.BigEndian.Uint32(h[28:]); int(n) != len(db)/sqlitePageSize {
+		t.Errorf("header says %d pages, file has %d", n, len(db)/sqlitePageSize)
+	}
+	if f := binary.BigEndian.Uint32(h[44:]); f != 4 {
+		t.Errorf("schema format = %d", f)
+	}
+	if e := binary.BigEndian.Uint32(h[56:]); e != 1 {
+		t.Errorf("text encoding = %d, want UTF-8", e)
+	}
+	if v := binary.BigEndian.Uint32(h[92:]); v != binary.BigEndian.Uint32(h[24:]) {
+		t.Errorf("version-valid-for %d doesn't match the change counter", v)
+	}
+
+	schema := readTable(t, db, 1)
+	if len(schema) != len(tables) {
+		t.Fatalf("schema has %d rows, want %d", len(schema), len(tables))
+	}
+	for i, tb := range tables {
+		row := decodeRecord(t, schema[i])
+		if len(row) != 5 {
+			t.Fatalf("schema row %d = %v", i, row)
+		}
+		cols := make([]string, len(tb.columns))
+		for j, c := range tb.columns {
+			cols[j] = sqliteQuote(c.name) + " " + c.typ
+		}
+		sql := fmt.Sprintf("CREATE TABLE %s (%s)", sqliteQuote(tb.name), strings.Join(cols, ", "))
+		if row[0] != "table" || row[1] != tb.name || row[2] != tb.name || row[4] != sql {
+			t.Errorf("schema row %d = %v, want %s", i, row, sql)
+		}
+		root, ok := row[3].(int64)
+		if !ok || root < 2 || int(root) > len(db)/sqlitePageSize {
+			t.Fatalf("table %s has root page %v", tb.name, row[3])
+		}
+		records := readTable(t, db, int(root))
+		if len(records) != len(tb.rows) {
+			t.Fatalf("table %s has %d rows, want %d", tb.name, len(records), len(tb.rows))
+		}
+		for j, rec := range records {
+			if got := decodeRecord(t, rec); !reflect.DeepEqual(got, tb.rows[j]) {
+				t.Fatalf("table %s row %d = %v, want %v", tb.name, j+1, got, tb.rows[j])
+			}
+		}
+	}
+}
+
+func TestSQLiteVarint(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want []byte
+	}{
+		{0, []byte{0}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x81, 0x00}},
+		{0x3fff, []byte{0xff, 0x7f}},
+		{0x4000, []byte{0x81, 0x80, 0x00}},
+		{1<<56 - 1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{1 << 56, []byte{0x80, 0xc0, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}},
+		{math.MaxUint64, bytes.Repeat([]byte{0xff}, 9)},
+	}
+	for _, tt := range tests {
+		got := sqliteVarint(tt.v)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("sqliteVarint(%#x) = %x, want %x", tt.v, got, tt.want)
+		}
+		if v, n := readVarint(got); v != tt.v || n != len(got) {
+			t.Errorf("varint %x reads back as %#x (%d bytes)", got, v, n)
+		}
+	}
+}
+
+func TestSQLiteRecord(t *testing.T) {
+	values := []interface{}{
+		nil, int64(0), int64(1), int64(-1), int64(200), int64(-40000),
+		int64(1 << 30), int64(1 << 40), int64(math.MinInt64), 1.5, "hé", []byte{0, 1},
+	}
+	rec := sqliteRecord(values)
+	// header size, then a serial type for each value
+	wantHeader := []byte{13, 0, 8, 9, 1, 2, 3, 4, 5, 6, 7, 19, 16}
+	if !bytes.Equal(rec[:len(wantHeader)], wantHeader) {
+		t.Errorf("record header = %v, want %v", rec[:len(wantHeader)], wantHeader)
+	}
+	if got := decodeRecord(t, rec); !reflect.DeepEqual(got, values) {
+		t.Errorf("record decodes to %v, want %v", got, values)
+	}
+
+	// a header long enough that its own size takes two bytes
+	long := make([]interface{}, 200)
+	for i := range long {
+		long[i] = fmt.Sprint(i)
+	}
+	if got := decodeRecord(t, sqliteRecord(long)); !reflect.DeepEqual(got, long) {
+		t.Errorf("long record decodes to %v", got)
+	}
+}
+
+func TestBuildSQLite(t *testing.T) {
+	snapshots := &table{
+		name:    "snapshots",
+		columns: []column{{"timestamp", "TEXT"}, {"statuscode", "INTEGER"}, {"note", "TEXT"}},
+	}
+	for i := 0; i < 5000; i++ {
+		var note interface{}
+		switch i % 3 {
+		case 1:
+			note = fmt.Sprintf("note %d", i)
+		case 2:
+			note = strings.Repeat("y", i%700)
+		}
+		snapshots.rows = append(snapshots.rows, []interface{}{fmt.Sprintf("2020%010d", i), int64(200 + i%5), note})
+	}
+	big := &table{
+		name:    `odd "name"`,
+		columns: []column{{"value", "TEXT"}},
+		rows: [][]interface{}{
+			{strings.Repeat("z", 3*sqlitePageSize)},
+			{strings.Repeat("w", sqlitePageSize-40)},
+			{"small"},
+		},
+	}
+	empty := &table{name: "empty", columns: []column{{"a", "TEXT"}}}
+	if got := sqliteQuote(big.name); got != `"odd ""name"""` {
+		t.Errorf("sqliteQuote(%q) = %s", big.name, got)
+	}
+
+	for _, tables := range [][]*table{nil, {empty}, {snapshots, big, empty}} {
+		db, err := buildSQLite(tables)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSQLite(t, db, tables)
+	}
+}
+
+func TestBuildSQLiteLargeSchema(t *testing.T) {
+	var tables []*table
+	for i := 0; i < 400; i++ {
+		tables = append(tables, &table{
+			name:    fmt.Sprintf("table_%d_%s", i, strings.Repeat("x", 30)),
+			columns: []column{{"a", "TEXT"}, {"b", "INTEGER"}},
+			rows:    [][]interface{}{{fmt.Sprint(i), int64(i)}},
+		})
+	}
+	db, err := buildSQLite(tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db[100] != 0x05 {
+		t.Errorf("page 1 has type %#x, want an interior page", db[100])
+	}
+	checkSQLite(t, db, tables)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// subdomain is a host under the target domain seen in the archive.
+type subdomain struct {
+	Host      string `json:"host"`
+	Unicode   string `json:"unicode,omitempty"`
+	Subdomain string `json:"subdomain,omitempty"`
+	First     string `json:"first"`
+	Last      string `json:"last"`
+	URLs      int    `json:"urls"`
+	Captures  int    `json:"captures"`
+}
+
+// subdomains queries the CDX server for every URL under the registrable
+// domain and its subdomains, collapsed by URL key, and pulls out the
+// hosts. Each host is written to subdomains.txt, and its subdomain part,
+// first and last capture dates, number of distinct URLs, and number of
+// captures are written to subdomains.json.
+func (g *ghost) subdomains(wg *sync.WaitGroup, domain string, timeout int) {
+	defer wg.Done()
+
+	out, err := g.archivedHosts(domain, timeout)
+	if err != nil {
+		g.errorLog.Printf("unable to list subdomains: %v\n", err)
+		return
+	}
+	if len(out) == 0 {
+		g.infoLog.Println("No archived subdomains found.")
+		return
+	}
+
+	lines := make([]string, len(out))
+	for i, s := range out {
+		lines[i] = s.Host
+	}
+	g.writeLines("subdomains.txt", lines)
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		g.errorLog.Printf("subdomains marshal error: %v\n", err)
+		return
+	}
+	g.writeData("subdomains.json", b)
+}
+
+// archivedHosts lists the hosts the archive has seen under domain (which
+// needn't be a registrable domain), sorted by name.
+func (g *ghost) archivedHosts(domain string, timeout int) ([]*subdomain, error) {
+	// the skip count and last skipped timestamp give the number of
+	// captures and the last capture date for each collapsed URL
+	const base = "http://web.archive.org/cdx/search/cdx?output=json&matchType=domain&collapse=urlkey&fl=original,timestamp&showSkipCount=true&lastSkipTimestamp=true"
+	u := fmt.Sprintf("%s&url=%s&limit=%d", base, url.QueryEscape(domain), g.config.subLimit)
+	g.infoLog.Printf("checking: %s", u)
+
+	body, err := g.getData(u, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]string
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &rows)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal error: %w", err)
+		}
+	}
+	if len(rows) < 2 {
+		return nil, nil
+	}
+
+	// the key names the columns
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	field := func(r []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(r) {
+			return ""
+		}
+		return r[i]
+	}
+
+	domain = strings.ToLower(domain)
+	hosts := make(map[string]*subdomain)
+	for _, r := range rows[1:] {
+		original := field(r, "original")
+		if !strings.Contains(original, "://") {
+			original = "http://" + original
+		}
+		parsed, err := url.Parse(original)
+		if err != nil {
+			continue
+		}
+		host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			continue
+		}
+
+		first := field(r, "timestamp")
+		last := field(r, "endtimestamp")
+		if last == "" || last == "-" {
+			last = first
+		}
+		captures := 1
+		if n, err := strconv.Atoi(field(r, "skipcount")); err == nil {
+			captures += n
+		}
+
+		s, ok := hosts[host]
+		if !ok {
+			s = &subdomain{Host: host, First: first, Last: last}
+			if p, err := g.splitHost(host); err == nil {
+				s.Unicode, s.Subdomain = p.Unicode, p.Subdomain
+			}
+			hosts[host] = s
+		}
+		s.URLs++
+		s.Captures += captures
+		if first < s.First {
+			s.First = first
+		}
+		if last > s.Last {
+			s.Last = last
+		}
+	}
+
+	out := make([]*subdomain, 0, len(hosts))
+	for _, s := range hosts {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+
+	g.infoLog.Printf("Found %d host(s) under %s.\n", len(out), domain)
+	if len(rows)-1 >= g.config.subLimit {
+		g.infoLog.Printf("Reached the limit of %d URLs; use -subl to list more.\n", g.config.subLimit)
+	}
+
+	return out, nil
+}
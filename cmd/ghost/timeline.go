@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+// runTimeline implements "ghost timeline": it groups every capture of a
+// URL by CDX digest, compares the SimHash of each version's visible text
+// with the version before it, and writes the resulting stable periods and
+// significant change events to a file.
+func runTimeline(args []string) {
+	var config config
+	var threshold float64
+	var max int
+	fs := flag.NewFlagSet("timeline", flag.ExitOnError)
+	fs.IntVar(&config.gophers, "g", 10, "number of goroutines (default is 10).")
+	fs.IntVar(&max, "max", 500, "maximum number of versions to fetch, spread evenly over the timeline; 0 fetches them all (default is 500).")
+	fs.Float64Var(&threshold, "sim", 0.9, "similarity (0-1) at or above which a change is cosmetic (default is 0.9).")
+	fs.IntVar(&config.timeout, "time", 5000, "timeout in milliseconds (default is 5000).")
+	fs.StringVar(&config.url, "u", "", "url for searching")
+	filterFlags(fs, &config.filters)
+	outputFlags(fs, &config.output)
+	fs.Parse(args)
+
+	start := time.Now()
+
+	// stable periods need every capture, not just the first of each run,
+	// so captures are only collapsed if -collapse is given
+	collapse := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "collapse" {
+			collapse = true
+		}
+	})
+	if !collapse {
+		config.filters.collapse = ""
+	}
+
+	g := newGhost(config)
+
+	if config.url != "" {
+		err := g.validateURL(config.url)
+		if err != nil {
+			g.errorLog.Fatal(err)
+		}
+	} else {
+		g.getInputURL()
+	}
+
+	err := g.setOutput(start)
+	if err != nil {
+		g.errorLog.Fatalf("unable to make output directory: %v", err)
+	}
+
+	u := g.formURL(g.config.url, config.filters)
+	g.infoLog.Printf("Wayback Machine URL: %s\n", u)
+
+	body, err := g.getData(u, config.timeout)
+	if err != nil {
+		g.fatal(start, err)
+	}
+	snaps, err := g.getSnaps(body)
+	if err != nil {
+		g.fatal(start, err)
+	}
+
+	versions := groupVersions(snaps)
+	g.infoLog.Printf("Found %d version(s) across %d capture(s).\n", len(versions), len(snaps))
+
+	captures := sampleVersions(versions, max)
+	if len(captures) < len(versions) {
+		g.infoLog.Printf("Fetching %d of them (-max %d).\n", len(captures), max)
+	}
+	g.fetchCaptures(captures, nil, true)
+
+	tl := buildTimeline(versions, threshold)
+	g.infoLog.Printf("Found %d significant change(s) over %d stable period(s).\n", len(tl.Events), len(tl.Periods))
+
+	b, err := json.Marshal(tl)
+	if err != nil {
+		g.fatal(start, fmt.Errorf("marshal error: %w", err))
+	}
+	g.writeData("timeline.json", b)
+
+	g.infoLog.Printf("Took: %f seconds\n", time.Since(start).Seconds())
+	g.manifestWriter(start, len(snaps), "")
+}
+
+// version is a run of consecutive captures sharing the same digest.
+type version struct {
+	Digest   string `json:"digest"`
+	First    string `json:"first"`
+	Last     string `json:"last"`
+	Captures int    `json:"captures"`
+	SimHash  string `json:"simhash,omitempty"`
+	// Similarity to the previous fetched version, from 0 to 1.
+	Similarity *float64 `json:"similarity,omitempty"`
+	Change     string   `json:"change,omitempty"`
+	// Skipped is set for versions left out by -max.
+	Skipped bool `json:"skipped,omitempty"`
+
+	capture *capture
+	hash    uint64
+}
+
+// groupVersions collapses runs of snapshots with identical digests into
+// versions.
+func groupVersions(snaps [][]string) []*version {
+	var versions []*version
+	for _, v := range snaps {
+		// urlkey, timestamp, original, mimetype, statuscode, digest, length
+		if len(v) < 6 {
+			continue
+		}
+		if n := len(versions); n > 0 && versions[n-1].Digest == v[5] {
+			versions[n-1].Last = v[1]
+			versions[n-1].Captures++
+			continue
+		}
+		versions = append(versions, &version{
+			Digest:   v[5],
+			First:    v[1],
+			Last:     v[1],
+			Captures: 1,
+			capture: &capture{
+				Timestamp: v[1],
+				URL:       fmt.Sprintf("https://web.archive.org/web/%s/%s", v[1], v[2]),
+				original:  v[2],
+			},
+		})
+	}
+	return versions
+}
+
+// errSkipped marks the captures of versions left out by -max.
+var errSkipped = errors.New("skipped")
+
+// sampleVersions returns the captures of at most max versions to fetch,
+// spread evenly from the first version to the last, and marks the rest
+// as skipped. A max of 0 or less returns every capture.
+func sampleVersions(versions []*version, max int) []*capture {
+	var captures []*capture
+	n := len(versions)
+	for i, v := range versions {
+		keep := max <= 0 || n <= max
+		if !keep {
+			// the version nearest each of max evenly spaced points
+			if max == 1 {
+				keep = i == n-1
+			} else {
+				j := (i*(max-1) + (n-1)/2) / (n - 1)
+				keep = i == (j*(n-1)+(max-1)/2)/(max-1)
+			}
+		}
+		if keep {
+			captures = append(captures, v.capture)
+			continue
+		}
+		v.Skipped = true
+		v.capture.err = errSkipped
+	}
+	return captures
+}
+
+// period is a span of time over which a page changed only cosmetically.
+type period struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Versions int    `json:"versions"`
+	Captures int    `json:"captures"`
+}
+
+// changeEvent is a substantive change between two versions.
+type changeEvent struct {
+	Timestamp  string  `json:"timestamp"`
+	Previous   string  `json:"previous"`
+	URL        string  `json:"url"`
+	Similarity float64 `json:"similarity"`
+	// Magnitude is the Hamming distance between the two SimHashes, 0-64.
+	Magnitude int `json:"magnitude"`
+}
+
+// timeline is the result written to timeline.json.
+type timeline struct {
+	Periods  []*period     `json:"periods"`
+	Events   []changeEvent `json:"events"`
+	Versions []*version    `json:"versions"`
+}
+
+// buildTimeline computes the SimHash of each fetched version and splits
+// the versions into stable periods wherever the similarity to the previous
+// fetched version falls below threshold. Versions that failed to download
+// or were skipped extend the current period.
+func buildTimeline(versions []*version, threshold float64) *timeline {
+	tl := &timeline{Versions: versions}
+	var cur *period
+	var prev *version
+	for _, v := range versions {
+		substantive := false
+		if v.capture.err == nil {
+			v.hash = simHash(v.capture.lines)
+			v.SimHash = fmt.Sprintf("%016x", v.hash)
+			if prev != nil {
+				distance := bits.OnesCount64(v.hash ^ prev.hash)
+				sim := 1 - float64(distance)/64
+				v.Similarity = &sim
+				v.Change = "cosmetic"
+				if sim < threshold {
+					v.Change = "substantive"
+					substantive = true
+					tl.Events = append(tl.Events, changeEvent{
+						Timestamp:  v.First,
+						Previous:   prev.Last,
+						URL:        v.capture.URL,
+						Similarity: sim,
+						Magnitude:  distance,
+					})
+				}
+			}
+			prev = v
+		}
+
+		if cur == nil || substantive {
+			cur = &period{Start: v.First}
+			tl.Periods = append(tl.Periods, cur)
+		}
+		cur.End = v.Last
+		cur.Versions++
+		cur.Captures += v.Captures
+	}
+	return tl
+}
+
+// simHash computes a 64-bit SimHash over the word 3-shingles of lines.
+// Near-duplicate documents produce hashes with a small Hamming distance.
+func simHash(lines []string) uint64 {
+	words := strings.Fields(strings.ToLower(strings.Join(lines, " ")))
+	const size = 3
+	var weights [64]int
+	add := func(feature string) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	if len(words) < size {
+		add(strings.Join(words, " "))
+	}
+	for i := 0; i+size <= len(words); i++ {
+		add(strings.Join(words[i:i+size], " "))
+	}
+
+	var hash uint64
+	for i, w := range weights {
+		if w > 0 {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+	"testing"
+)
+
+func TestSimHash(t *testing.T) {
+	text := strings.Fields(`the quick brown fox jumps over the lazy dog while the cat
+		sleeps in the warm afternoon sun and the birds sing in the tall old trees
+		near the river that runs past the quiet village at the edge of the forest`)
+	edited := append([]string{}, text...)
+	edited[10] = "kitten"
+	other := strings.Fields(`completely different words about archives snapshots captures
+		digests timestamps servers indexes crawlers mirrors collections and records
+		that share nothing with the other text at all beyond a few common words`)
+
+	distance := func(a, b []string) int { return bits.OnesCount64(simHash(a) ^ simHash(b)) }
+
+	if simHash(text) != simHash(text) {
+		t.Error("simHash isn't deterministic")
+	}
+	if d := distance(text, []string{strings.ToUpper(strings.Join(text, "  \n "))}); d != 0 {
+		t.Errorf("case and whitespace changed the hash by %d bits", d)
+	}
+	near, far := distance(text, edited), distance(text, other)
+	if near >= far {
+		t.Errorf("a one-word edit is %d bits away, unrelated text %d", near, far)
+	}
+	if near > 16 {
+		t.Errorf("a one-word edit is %d bits away, want a near-duplicate", near)
+	}
+	if far < 16 {
+		t.Errorf("unrelated text is only %d bits away", far)
+	}
+
+	// short and empty inputs still hash
+	if simHash([]string{"one two"}) == simHash([]string{"three four"}) {
+		t.Error("different short texts hash the same")
+	}
+	if simHash(nil) != simHash([]string{""}) {
+		t.Error("empty inputs hash differently")
+	}
+}
+
+// snapRow returns a CDX snapshot row with the given timestamp and digest.
+func snapRow(ts, digest string) []string {
+	return []string{"com,example)/", ts, "https://example.com/", "text/html", "200", digest, "100"}
+}
+
+func TestGroupVersions(t *testing.T) {
+	snaps := [][]string{
+		snapRow("20200101000000", "A"),
+		snapRow("20200201000000", "A"),
+		snapRow("20200301000000", "A"),
+		{"short", "row"},
+		snapRow("20200401000000", "B"),
+		snapRow("20200501000000", "A"),
+		snapRow("20200601000000", "A"),
+	}
+	versions := groupVersions(snaps)
+	want := []struct {
+		digest, first, last string
+		captures            int
+	}{
+		{"A", "20200101000000", "20200301000000", 3},
+		{"B", "20200401000000", "20200401000000", 1},
+		{"A", "20200501000000", "20200601000000", 2},
+	}
+	if len(versions) != len(want) {
+		t.Fatalf("got %d versions, want %d", len(versions), len(want))
+	}
+	for i, w := range want {
+		v := versions[i]
+		if v.Digest != w.digest || v.First != w.first || v.Last != w.last || v.Captures != w.captures {
+			t.Errorf("version %d = %+v, want %+v", i, v, w)
+		}
+		if v.capture.Timestamp != w.first || v.capture.original != "https://example.com/" {
+			t.Errorf("version %d fetches %+v", i, v.capture)
+		}
+	}
+	if got := groupVersions(nil); len(got) != 0 {
+		t.Errorf("groupVersions(nil) = %v", got)
+	}
+}
+
+func TestSampleVersions(t *testing.T) {
+	for _, tt := range []struct{ n, max, want int }{
+		{10, 0, 10},
+		{10, 10, 10},
+		{10, 20, 10},
+		{10, 1, 1},
+		{10, 2, 2},
+		{10, 3, 3},
+		{1000, 500, 500},
+		{1001, 7, 7},
+	} {
+		var snaps [][]string
+		for i := 0; i < tt.n; i++ {
+			snaps = append(snaps, snapRow(fmt.Sprintf("2020%010d", i), fmt.Sprint(i)))
+		}
+		versions := groupVersions(snaps)
+		captures := sampleVersions(versions, tt.max)
+		if len(captures) != tt.want {
+			t.Errorf("%d versions, -max %d: fetching %d, want %d", tt.n, tt.max, len(captures), tt.want)
+			continue
+		}
+		skipped := 0
+		for _, v := range versions {
+			if v.Skipped {
+				skipped++
+				if !errors.Is(v.capture.err, errSkipped) {
+					t.Errorf("skipped version %s isn't marked", v.First)
+				}
+			}
+		}
+		if skipped != tt.n-tt.want {
+			t.Errorf("%d versions, -max %d: %d skipped", tt.n, tt.max, skipped)
+		}
+		if tt.want > 1 && (captures[0] != versions[0].capture || captures[len(captures)-1] != versions[tt.n-1].capture) {
+			t.Errorf("%d versions, -max %d: the first and last versions aren't fetched", tt.n, tt.max)
+		}
+	}
+}
+
+func TestBuildTimeline(t *testing.T) {
+	page := strings.Fields(`welcome to the example company home page where we sell widgets
+		gadgets and gizmos to customers around the world since nineteen ninety`)
+	tweak := append([]string{}, page...)
+	tweak[len(tweak)-1] = "nineteen ninety one"
+	redesign := strings.Fields(`announcing our new platform for cloud native observability
+		built for engineering teams who ship software every single day`)
+
+	versions := groupVersions([][]string{
+		snapRow("20200101000000", "A"),
+		snapRow("20200201000000", "B"),
+		snapRow("20200301000000", "C"),
+		snapRow("20200401000000", "D"),
+	})
+	versions[0].capture.lines = page
+	versions[1].capture.lines = tweak
+	versions[2].capture.err = errSkipped
+	versions[3].capture.lines = redesign
+
+	tl := buildTimeline(versions, 0.7)
+	if len(tl.Periods) != 2 || len(tl.Events) != 1 {
+		t.Fatalf("got %d periods and %d events, want 2 and 1", len(tl.Periods), len(tl.Events))
+	}
+	if p := tl.Periods[0]; p.Start != "20200101000000" || p.End != "20200301000000" || p.Versions != 3 {
+		t.Errorf("first period = %+v", p)
+	}
+	if e := tl.Events[0]; e.Timestamp != "20200401000000" || e.Previous != "20200201000000" {
+		t.Errorf("event = %+v", e)
+	}
+	if versions[1].Change != "cosmetic" || versions[3].Change != "substantive" || versions[2].SimHash != "" {
+		t.Errorf("changes = %q, %q, %q", versions[1].Change, versions[2].SimHash, versions[3].Change)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// wellKnownFile is a file ghost looks for in the archive at a fixed path
+// under the target. If parse is not nil, it turns the file's contents into
+// a summary for the well-known report.
+type wellKnownFile struct {
+	path     string
+	filename string
+	parse    func([]byte) (interface{}, error)
+}
+
+// defaultAssets are always checked.
+var defaultAssets = []wellKnownFile{
+	{path: "robots.txt", filename: "robots.txt", parse: parseRobotsAsset},
+	{path: "sitemap.xml", filename: "sitemap.xml", parse: parseSitemapAsset},
+}
+
+// wellKnownAssets are checked with -wellknown.
+var wellKnownAssets = []wellKnownFile{
+	{path: ".well-known/security.txt", parse: parseSecurityTxt},
+	{path: "security.txt", parse: parseSecurityTxt},
+	{path: "humans.txt"},
+	{path: "crossdomain.xml", parse: parseCrossDomain},
+	{path: "clientaccesspolicy.xml", parse: parseClientAccessPolicy},
+	{path: "ads.txt", parse: parseAdsTxt},
+	{path: "app-ads.txt", parse: parseAdsTxt},
+	{path: "manifest.json", parse: parseJSONAsset},
+	{path: ".well-known/apple-app-site-association", parse: parseJSONAsset},
+	{path: "apple-app-site-association", parse: parseJSONAsset},
+	{path: ".well-known/openid-configuration", parse: parseJSONAsset},
+	{path: ".well-known/assetlinks.json", parse: parseJSONAsset},
+	{path: ".well-known/change-password"},
+}
+
+// assetParsers are used for paths added with -wkfile, by file name.
+var assetParsers = map[string]func([]byte) (interface{}, error){
+	"ads.txt":                parseAdsTxt,
+	"clientaccesspolicy.xml": parseClientAccessPolicy,
+	"crossdomain.xml":        parseCrossDomain,
+	"robots.txt":             parseRobotsAsset,
+	"security.txt":           parseSecurityTxt,
+	"sitemap.xml":            parseSitemapAsset,
+}
+
+// assets returns the files to check: robots.txt and sitemap.xml, plus the
+// well-known files with -wellknown and any paths listed in the -wkfile file.
+func (g *ghost) assets() ([]wellKnownFile, error) {
+	assets := append([]wellKnownFile{}, defaultAssets...)
+	if g.config.wellKnown {
+		assets = append(assets, wellKnownAssets...)
+	}
+	if g.config.wellKnownFile != "" {
+		lines, err := g.readInputFile(g.config.wellKnownFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read well-known file list: %w", err)
+		}
+		for _, l := range lines {
+			p := strings.TrimPrefix(strings.TrimSpace(l), "/")
+			if p == "" || strings.HasPrefix(p, "#") {
+				continue
+			}
+			wk := wellKnownFile{path: p, parse: assetParsers[path.Base(p)]}
+			if wk.parse == nil && strings.HasSuffix(p, ".json") {
+				wk.parse = parseJSONAsset
+			}
+			assets = append(assets, wk)
+		}
+	}
+
+	for i := range assets {
+		if assets[i].filename == "" {
+			assets[i].filename = path.Join("wellknown", sanitizeSourcePath(assets[i].path))
+		}
+	}
+	return assets, nil
+}
+
+// assetResult is a single entry in the well-known report.
+type assetResult struct {
+	Path      string      `json:"path"`
+	Status    string      `json:"status"`
+	Archived  string      `json:"archived,omitempty"`
+	Timestamp string      `json:"timestamp,omitempty"`
+	File      string      `json:"file,omitempty"`
+	Parsed    interface{} `json:"parsed,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// assetReport is a mutex-protected list of asset results.
+type assetReport struct {
+	mu      sync.Mutex
+	results []assetResult
+}
+
+// store adds a result to the report.
+func (r *assetReport) store(result assetResult) {
+	r.mu.Lock()
+	r.results = append(r.results, result)
+	r.mu.Unlock()
+}
+
+// snapshotTimestamp pulls the timestamp out of a Wayback Machine URL.
+var snapshotTimestamp = regexp.MustCompile(`/web/(\d{1,14})`)
+
+// assetReportWriter writes the well-known report to a file.
+func (g *ghost) assetReportWriter() {
+	results := g.assetResults.results
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+
+	var found int
+	for _, r := range results {
+		if r.Status == "found" {
+			found++
+		}
+	}
+	g.infoLog.Printf("Found %d of %d well-known file(s).\n", found, len(results))
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		g.errorLog.Printf("Marshal error: %v\n", err)
+		return
+	}
+	g.writeData("wellknown.json", b)
+}
+
+// parseRobotsAsset wraps parseRobots for the report.
+func parseRobotsAsset(data []byte) (interface{}, error) {
+	return parseRobots(data), nil
+}
+
+// parseSitemapAsset summarizes a sitemap for the report.
+func parseSitemapAsset(data []byte) (interface{}, error) {
+	kind, urls, children, err := parseSitemap(data)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"kind":     kind,
+		"urls":     len(urls),
+		"sitemaps": children,
+	}, nil
+}
+
+// parseSecurityTxt parses the fields of a security.txt (RFC 9116),
+// skipping any PGP signature wrapped around them.
+func parseSecurityTxt(data []byte) (interface{}, error) {
+	fields := make(map[string][]string)
+	inSignature, inHeader := false, false
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, "-----BEGIN PGP SIGNED MESSAGE"):
+			// armor headers like "Hash: SHA256" run until a blank line
+			inHeader = true
+		case strings.HasPrefix(line, "-----BEGIN PGP SIGNATURE"):
+			inSignature = true
+		case strings.HasPrefix(line, "-----END PGP SIGNATURE"):
+			inSignature = false
+		case inHeader && line == "":
+			inHeader = false
+		case inHeader, inSignature, line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, "-----"):
+		default:
+			key, value, found := strings.Cut(line, ":")
+			if found {
+				fields[strings.TrimSpace(key)] = append(fields[strings.TrimSpace(key)], strings.TrimSpace(value))
+			}
+		}
+	}
+	return fields, s.Err()
+}
+
+// crossDomainPolicy is a Flash crossdomain.xml.
+type crossDomainPolicy struct {
+	SiteControl struct {
+		Permitted string `xml:"permitted-cross-domain-policies,attr" json:"permitted_cross_domain_policies,omitempty"`
+	} `xml:"site-control" json:"site_control"`
+	AllowAccessFrom []struct {
+		Domain  string `xml:"domain,attr" json:"domain"`
+		Secure  string `xml:"secure,attr" json:"secure,omitempty"`
+		ToPorts string `xml:"to-ports,attr" json:"to_ports,omitempty"`
+	} `xml:"allow-access-from" json:"allow_access_from,omitempty"`
+	AllowHeadersFrom []struct {
+		Domain  string `xml:"domain,attr" json:"domain"`
+		Headers string `xml:"headers,attr" json:"headers"`
+	} `xml:"allow-http-request-headers-from" json:"allow_headers_from,omitempty"`
+	Wildcard bool `xml:"-" json:"wildcard"`
+}
+
+// parseCrossDomain parses a crossdomain.xml, flagging wildcard domains.
+func parseCrossDomain(data []byte) (interface{}, error) {
+	var p crossDomainPolicy
+	err := xml.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range p.AllowAccessFrom {
+		if a.Domain == "*" {
+			p.Wildcard = true
+		}
+	}
+	return p, nil
+}
+
+// clientAccessPolicy is a Silverlight clientaccesspolicy.xml.
+type clientAccessPolicy struct {
+	Policies []struct {
+		AllowFrom struct {
+			Headers string `xml:"http-request-headers,attr" json:"headers,omitempty"`
+			Domains []struct {
+				URI string `xml:"uri,attr" json:"uri"`
+			} `xml:"domain" json:"domains"`
+		} `xml:"allow-from" json:"allow_from"`
+		GrantTo struct {
+			Resources []struct {
+				Path            string `xml:"path,attr" json:"path"`
+				IncludeSubpaths string `xml:"include-subpaths,attr" json:"include_subpaths,omitempty"`
+			} `xml:"resource" json:"resources"`
+		} `xml:"grant-to" json:"grant_to"`
+	} `xml:"cross-domain-access>policy" json:"policies"`
+	Wildcard bool `xml:"-" json:"wildcard"`
+}
+
+// parseClientAccessPolicy parses a clientaccesspolicy.xml, flagging
+// wildcard domains.
+func parseClientAccessPolicy(data []byte) (interface{}, error) {
+	var p clientAccessPolicy
+	err := xml.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range p.Policies {
+		for _, d := range policy.AllowFrom.Domains {
+			if d.URI == "*" {
+				p.Wildcard = true
+			}
+		}
+	}
+	return p, nil
+}
+
+// adsRecord is a single line of an ads.txt.
+type adsRecord struct {
+	Domain       string `json:"domain"`
+	PublisherID  string `json:"publisher_id"`
+	Relationship string `json:"relationship"`
+	CertID       string `json:"cert_id,omitempty"`
+}
+
+// parseAdsTxt parses the records and variables (contact=, subdomain=,
+// and so on) of an ads.txt.
+func parseAdsTxt(data []byte) (interface{}, error) {
+	var out struct {
+		Records   []adsRecord         `json:"records"`
+		Variables map[string][]string `json:"variables,omitempty"`
+	}
+	out.Variables = make(map[string][]string)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if key, value, found := strings.Cut(line, "="); found && !strings.Contains(key, ",") {
+			out.Variables[strings.ToLower(strings.TrimSpace(key))] = append(out.Variables[strings.ToLower(strings.TrimSpace(key))], strings.TrimSpace(value))
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) < 3 {
+			continue
+		}
+		r := adsRecord{
+			Domain:       strings.TrimSpace(parts[0]),
+			PublisherID:  strings.TrimSpace(parts[1]),
+			Relationship: strings.ToUpper(strings.TrimSpace(parts[2])),
+		}
+		if len(parts) > 3 {
+			r.CertID = strings.TrimSpace(parts[3])
+		}
+		out.Records = append(out.Records, r)
+	}
+	return out, s.Err()
+}
+
+// parseJSONAsset parses a JSON file such as manifest.json,
+// apple-app-site-association, or openid-configuration as-is.
+func parseJSONAsset(data []byte) (interface{}, error) {
+	var v interface{}
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWhoisServer is where lookups start unless -whois-server is set.
+	defaultWhoisServer = "whois.iana.org"
+	// maxWhoisHops limits how many referrals are followed.
+	maxWhoisHops = 4
+	// maxWhoisResponse caps the size of a single whois response.
+	maxWhoisResponse = 1 << 20
+)
+
+// whoisQueryFormats are the query formats for registries that expect
+// more than the bare domain, keyed by server.
+var whoisQueryFormats = map[string]string{
+	// .de: ask for ASCII output
+	"whois.denic.de": "-T dn,ace %s",
+	// .com and .net: match the domain only, not hosts with the same name
+	"whois.verisign-grs.com": "domain %s",
+	// .jp: English output
+	"whois.jprs.jp": "%s/e",
+	// .dk: include contact handles
+	"whois.dk-hostmaster.dk": "--show-handles %s",
+	// ARIN: network records for an address
+	"whois.arin.net": "n + %s",
+}
+
+// whoisContact is a contact listed in a whois record.
+type whoisContact struct {
+	Name         string `json:"name,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Country      string `json:"country,omitempty"`
+}
+
+// whoisRecord is the parsed result written to whois.json. Fields from
+// later servers in the referral chain take precedence.
+type whoisRecord struct {
+	Domain       string       `json:"domain,omitempty"`
+	Registrar    string       `json:"registrar,omitempty"`
+	RegistrarURL string       `json:"registrar_url,omitempty"`
+	AbuseEmail   string       `json:"abuse_email,omitempty"`
+	Registrant   whoisContact `json:"registrant"`
+	Created      string       `json:"created,omitempty"`
+	Updated      string       `json:"updated,omitempty"`
+	Expires      string       `json:"expires,omitempty"`
+	NameServers  []string     `json:"name_servers,omitempty"`
+	Status       []string     `json:"status,omitempty"`
+	Servers      []string     `json:"servers"`
+}
+
+// whoisFields maps the (lowercased) keys used by various whois servers
+// to the field of a whoisRecord they fill.
+var whoisFields = map[string]func(r *whoisRecord, v string){
+	"domain":                                 func(r *whoisRecord, v string) { r.Domain = strings.ToLower(v) },
+	"domain name":                            func(r *whoisRecord, v string) { r.Domain = strings.ToLower(v) },
+	"registrar":                              func(r *whoisRecord, v string) { r.Registrar = v },
+	"registrar name":                         func(r *whoisRecord, v string) { r.Registrar = v },
+	"sponsoring registrar":                   func(r *whoisRecord, v string) { r.Registrar = v },
+	"registrar url":                          func(r *whoisRecord, v string) { r.RegistrarURL = v },
+	"referral url":                           func(r *whoisRecord, v string) { r.RegistrarURL = v },
+	"registrar abuse contact email":          func(r *whoisRecord, v string) { r.AbuseEmail = v },
+	"abuse-mailbox":                          func(r *whoisRecord, v string) { r.AbuseEmail = v },
+	"registrant":                             func(r *whoisRecord, v string) { r.Registrant.Name = v },
+	"registrant name":                        func(r *whoisRecord, v string) { r.Registrant.Name = v },
+	"registrant organization":                func(r *whoisRecord, v string) { r.Registrant.Organization = v },
+	"registrant organisation":                func(r *whoisRecord, v string) { r.Registrant.Organization = v },
+	"registrant email":                       func(r *whoisRecord, v string) { r.Registrant.Email = v },
+	"registrant country":                     func(r *whoisRecord, v string) { r.Registrant.Country = v },
+	"creation date":                          func(r *whoisRecord, v string) { r.Created = v },
+	"created":                                func(r *whoisRecord, v string) { r.Created = v },
+	"created on":                             func(r *whoisRecord, v string) { r.Created = v },
+	"registered":                             func(r *whoisRecord, v string) { r.Created = v },
+	"registered on":                          func(r *whoisRecord, v string) { r.Created = v },
+	"registration time":                      func(r *whoisRecord, v string) { r.Created = v },
+	"updated date":                           func(r *whoisRecord, v string) { r.Updated = v },
+	"last updated":                           func(r *whoisRecord, v string) { r.Updated = v },
+	"last modified":                          func(r *whoisRecord, v string) { r.Updated = v },
+	"changed":                                func(r *whoisRecord, v string) { r.Updated = v },
+	"registry expiry date":                   func(r *whoisRecord, v string) { r.Expires = v },
+	"registrar registration expiration date": func(r *whoisRecord, v string) { r.Expires = v },
+	"expiration date":                        func(r *whoisRecord, v string) { r.Expires = v },
+	"expiry date":                            func(r *whoisRecord, v string) { r.Expires = v },
+	"expires":                                func(r *whoisRecord, v string) { r.Expires = v },
+	"expires on":                             func(r *whoisRecord, v string) { r.Expires = v },
+	"paid-till":                              func(r *whoisRecord, v string) { r.Expires = v },
+	"name server":                            addNameServer,
+	"nserver":                                addNameServer,
+	"nameserver":                             addNameServer,
+	"domain status":                          addStatus,
+	"status":                                 addStatus,
+	"state":                                  addStatus,
+}
+
+// addNameServer adds a name server to r, skipping any glue addresses
+// listed after it.
+func addNameServer(r *whoisRecord, v string) {
+	ns := strings.ToLower(strings.TrimSuffix(strings.Fields(v)[0], "."))
+	for _, existing := range r.NameServers {
+		if existing == ns {
+			return
+		}
+	}
+	r.NameServers = append(r.NameServers, ns)
+}
+
+// addStatus adds a status to r, dropping the ICANN link that often
+// follows EPP status codes.
+func addStatus(r *whoisRecord, v string) {
+	status := strings.Fields(v)[0]
+	for _, existing := range r.Status {
+		if existing == status {
+			return
+		}
+	}
+	r.Status = append(r.Status, status)
+}
+
+// parseWhois parses a whois response into a record and returns it, along
+// with the next server to ask if the response refers elsewhere.
+func parseWhois(data []byte) (*whoisRecord, string) {
+	r := &whoisRecord{}
+	var referral string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, ">>>") {
+			// the rest is boilerplate
+			break
+		}
+		if line == "" || strings.HasPrefix(line, "%") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		switch key {
+		case "refer", "whois", "registrar whois server", "whois server", "referralserver":
+			if server := whoisServer(value); server != "" {
+				referral = server
+			}
+			continue
+		}
+		if set, ok := whoisFields[key]; ok {
+			set(r, value)
+		}
+	}
+	return r, referral
+}
+
+// merge copies the fields set in src over those in r.
+func (r *whoisRecord) merge(src *whoisRecord) {
+	for _, f := range []struct{ dst, src *string }{
+		{&r.Domain, &src.Domain},
+		{&r.Registrar, &src.Registrar},
+		{&r.RegistrarURL, &src.RegistrarURL},
+		{&r.AbuseEmail, &src.AbuseEmail},
+		{&r.Registrant.Name, &src.Registrant.Name},
+		{&r.Registrant.Organization, &src.Registrant.Organization},
+		{&r.Registrant.Email, &src.Registrant.Email},
+		{&r.Registrant.Country, &src.Registrant.Country},
+		{&r.Created, &src.Created},
+		{&r.Updated, &src.Updated},
+		{&r.Expires, &src.Expires},
+	} {
+		if *f.src != "" {
+			*f.dst = *f.src
+		}
+	}
+	if len(src.NameServers) > 0 {
+		r.NameServers = src.NameServers
+	}
+	if len(src.Status) > 0 {
+		r.Status = src.Status
+	}
+}
+
+// add parses a response from server into r and returns the next server
+// to ask, if any. IANA describes the TLD, not the domain, so only its
+// referral is used.
+func (r *whoisRecord) add(server string, data []byte) string {
+	parsed, referral := parseWhois(data)
+	if server != defaultWhoisServer || referral == "" {
+		r.merge(parsed)
+	}
+	return referral
+}
+
+// whoisServer turns a referral like "whois://whois.ripe.net:43" or
+// "http://whois.example.com" into a host name. rwhois referrals are
+// skipped.
+func whoisServer(v string) string {
+	if strings.HasPrefix(v, "rwhois://") {
+		return ""
+	}
+	if i := strings.Index(v, "://"); i >= 0 {
+		v = v[i+3:]
+	}
+	v = strings.SplitN(v, "/", 2)[0]
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		v = host
+	}
+	return strings.ToLower(strings.TrimSuffix(v, "."))
+}
+
+// whoisQueryLine returns the line to send server to look up query.
+func whoisQueryLine(server, query string) string {
+	format, ok := whoisQueryFormats[server]
+	if !ok {
+		format = "%s"
+	}
+	return fmt.Sprintf(format, query) + "\r\n"
+}
+
+// whoisQuery sends query to server on port 43 and returns the response.
+func (g *ghost) whoisQuery(server, query string, timeout int) (buff []byte, err error) {
+	r := g.requests.start("WHOIS", fmt.Sprintf("whois://%s/%s", server, query))
+	defer func() { g.requests.finish(r, 0, buff, err) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+
+	conn, err := g.guard.dialContext(ctx, "tcp", net.JoinHostPort(server, "43"))
+	if err != nil {
+		return nil, fmt.Errorf("whois connection failure: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	_, err = io.WriteString(conn, whoisQueryLine(server, query))
+	if err != nil {
+		return nil, fmt.Errorf("send to whois failure: %w", err)
+	}
+
+	buff, err = io.ReadAll(io.LimitReader(conn, maxWhoisResponse))
+	if err != nil {
+		return nil, fmt.Errorf("whois read failure: %w", err)
+	}
+	return buff, nil
+}
+
+// whois starts at -whois-server (whois.iana.org by default) and follows
+// the chain of referrals to the registry and registrar servers. It
+// returns the parsed record and the raw responses, each headed by the
+// server that sent it.
+func (g *ghost) whois(query string, timeout int) (*whoisRecord, []byte, error) {
+	server := g.config.whoisServer
+	if server == "" {
+		server = defaultWhoisServer
+	}
+
+	record := &whoisRecord{}
+	var raw bytes.Buffer
+	visited := make(map[string]bool)
+	for hop := 0; hop < maxWhoisHops && server != "" && !visited[server]; hop++ {
+		visited[server] = true
+		g.infoLog.Printf("checking whois: %s", server)
+		buff, err := g.whoisQuery(server, query, timeout)
+		if err != nil {
+			// keep what earlier servers returned
+			if len(record.Servers) > 0 {
+				g.errorLog.Printf("%s: %v\n", server, err)
+				break
+			}
+			return nil, nil, err
+		}
+		record.Servers = append(record.Servers, server)
+		fmt.Fprintf(&raw, "### %s\n", server)
+		raw.Write(buff)
+		if len(buff) > 0 && buff[len(buff)-1] != '\n' {
+			raw.WriteByte('\n')
+		}
+		server = record.add(server, buff)
+	}
+	return record, raw.Bytes(), nil
+}
+
+// whoisLookup looks up the domain, following referrals from the
+// starting server to the registry and registrar.
+func (g *ghost) whoisLookup(wg *sync.WaitGroup, domain string, timeout int) {
+	defer wg.Done()
+	g.saveWhois(domain, timeout)
+}
+
+// saveWhois looks up query with whois, writes the raw responses to
+// whois.txt and the parsed record to whois.json, and returns the record.
+func (g *ghost) saveWhois(query string, timeout int) *whoisRecord {
+	record, raw, err := g.whois(query, timeout)
+	if err != nil {
+		g.errorLog.Printf("%v\n", err)
+		return nil
+	}
+	if len(raw) == 0 {
+		g.infoLog.Println("No results for whois.")
+		return record
+	}
+	g.writeData("whois.txt", raw)
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		g.errorLog.Printf("whois marshal error: %v\n", err)
+		return record
+	}
+	g.writeData("whois.json", b)
+	g.storeTable(whoisTable(record))
+	return record
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readWhois parses the whois response saved in testdata/whois.
+func readWhois(t *testing.T, name string) (*whoisRecord, string) {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "whois", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseWhois(b)
+}
+
+func TestParseWhois(t *testing.T) {
+	tests := []struct {
+		file     string
+		referral string
+		want     whoisRecord
+	}{
+		{
+			file:     "iana.txt",
+			referral: "whois.verisign-grs.com",
+			want: whoisRecord{
+				Domain:      "com",
+				Created:     "1985-01-01",
+				Updated:     "2023-12-07",
+				NameServers: []string{"a.gtld-servers.net", "b.gtld-servers.net"},
+				Status:      []string{"ACTIVE"},
+			},
+		},
+		{
+			// the registrar line after the >>> footer is ignored
+			file:     "verisign.txt",
+			referral: "whois.example-registrar.com",
+			want: whoisRecord{
+				Domain:       "example.com",
+				Registrar:    "Example Registrar, Inc.",
+				RegistrarURL: "http://www.example-registrar.com",
+				AbuseEmail:   "abuse@example-registrar.com",
+				Created:      "1995-08-14T04:00:00Z",
+				Updated:      "2024-08-14T07:01:34Z",
+				Expires:      "2025-08-13T04:00:00Z",
+				NameServers:  []string{"a.iana-servers.net", "b.iana-servers.net"},
+				Status:       []string{"clientDeleteProhibited", "clientTransferProhibited", "clientUpdateProhibited"},
+			},
+		},
+		{
+			file:     "registrar.txt",
+			referral: "whois.example-registrar.com",
+			want: whoisRecord{
+				Domain:       "example.com",
+				Registrar:    "Example Registrar, Inc.",
+				RegistrarURL: "https://www.example-registrar.com",
+				AbuseEmail:   "abuse@example-registrar.com",
+				Registrant: whoisContact{
+					Name:         "REDACTED FOR PRIVACY",
+					Organization: "Internet Assigned Numbers Authority",
+					Email:        "Please query the RDDS service of the Registrar of Record identified in this output for information on how to contact the Registrant",
+					Country:      "US",
+				},
+				Created:     "1995-08-14T04:00:00+0000",
+				Updated:     "2024-08-14T07:01:34+0000",
+				Expires:     "2025-08-13T04:00:00+0000",
+				NameServers: []string{"a.iana-servers.net", "b.iana-servers.net"},
+				Status:      []string{"clientTransferProhibited"},
+			},
+		},
+		{
+			file: "denic.txt",
+			want: whoisRecord{
+				Domain:      "example.de",
+				Updated:     "2020-03-11T10:34:04+01:00",
+				NameServers: []string{"ns1.example.net", "ns2.example.net"},
+				Status:      []string{"connect"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, referral := readWhois(t, tt.file)
+		if referral != tt.referral {
+			t.Errorf("%s: referral = %q, want %q", tt.file, referral, tt.referral)
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", tt.file, *got, tt.want)
+		}
+	}
+}
+
+func TestWhoisRecordAdd(t *testing.T) {
+	lookup := func(hops ...[2]string) (*whoisRecord, []string) {
+		record := &whoisRecord{}
+		var referrals []string
+		for _, hop := range hops {
+			b, err := os.ReadFile(filepath.Join("testdata", "whois", hop[1]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			referrals = append(referrals, record.add(hop[0], b))
+		}
+		return record, referrals
+	}
+
+	// a .com lookup: IANA, then the registry, then the registrar
+	record, referrals := lookup(
+		[2]string{"whois.iana.org", "iana.txt"},
+		[2]string{"whois.verisign-grs.com", "verisign.txt"},
+		[2]string{"whois.example-registrar.com", "registrar.txt"},
+	)
+	registrar, _ := readWhois(t, "registrar.txt")
+	if !reflect.DeepEqual(record, registrar) {
+		t.Errorf("merged record:\ngot  %+v\nwant %+v", *record, *registrar)
+	}
+	if want := []string{"whois.verisign-grs.com", "whois.example-registrar.com", "whois.example-registrar.com"}; !reflect.DeepEqual(referrals, want) {
+		t.Errorf("referrals = %q, want %q", referrals, want)
+	}
+
+	// IANA's TLD record doesn't fill the gaps a registry leaves
+	record, _ = lookup(
+		[2]string{"whois.iana.org", "iana-de.txt"},
+		[2]string{"whois.denic.de", "denic.txt"},
+	)
+	denic, _ := readWhois(t, "denic.txt")
+	if !reflect.DeepEqual(record, denic) {
+		t.Errorf("merged record:\ngot  %+v\nwant %+v", *record, *denic)
+	}
+
+	// without a referral, IANA's answer is all there is
+	record, _ = lookup([2]string{"whois.iana.org", "denic.txt"})
+	if record.Domain != "example.de" {
+		t.Errorf("record = %+v", *record)
+	}
+}
+
+func TestWhoisServer(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"whois.verisign-grs.com", "whois.verisign-grs.com"},
+		{"WHOIS.Example.COM.", "whois.example.com"},
+		{"whois://whois.ripe.net:43", "whois.ripe.net"},
+		{"whois.arin.net:43", "whois.arin.net"},
+		{"http://whois.example.com/lookup", "whois.example.com"},
+		{"rwhois://rwhois.example.net:4321", ""},
+	}
+	for _, tt := range tests {
+		if got := whoisServer(tt.in); got != tt.want {
+			t.Errorf("whoisServer(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWhoisQueryLine(t *testing.T) {
+	tests := []struct {
+		server, query, want string
+	}{
+		{"whois.iana.org", "example.com", "example.com\r\n"},
+		{"whois.verisign-grs.com", "example.com", "domain example.com\r\n"},
+		{"whois.denic.de", "example.de", "-T dn,ace example.de\r\n"},
+		{"whois.jprs.jp", "example.jp", "example.jp/e\r\n"},
+		{"whois.dk-hostmaster.dk", "example.dk", "--show-handles example.dk\r\n"},
+		{"whois.arin.net", "192.0.2.1", "n + 192.0.2.1\r\n"},
+	}
+	for _, tt := range tests {
+		if got := whoisQueryLine(tt.server, tt.query); got != tt.want {
+			t.Errorf("whoisQueryLine(%q, %q) = %q, want %q", tt.server, tt.query, got, tt.want)
+		}
+	}
+}